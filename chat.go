@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"strings"
 	"time"
 )
 
@@ -39,13 +40,27 @@ type geminiResponse struct {
 	} `json:"error"`
 }
 
-// --- API Call Logic ---
+// --- AI Provider Settings ---
+
+func init() {
+	registerOption(
+		&option{name: "aimodel", kind: stringOption, def: "gemini-1.5-flash-latest",
+			help: "Gemini model the AI chat uses"},
+		&option{name: "aiendpoint", kind: stringOption, def: "https://generativelanguage.googleapis.com/v1beta", secure: true,
+			help: "Base URL of the Gemini API"},
+		&option{name: "aikeyvar", kind: stringOption, def: "GEMINI_API_KEY", secure: true,
+			help: "Environment variable holding the API key"},
+	)
+}
 
-func callGemini(ctx context.Context, apiKey string, history []ChatMessage, userMsg string) (string, error) {
-	if apiKey == "" {
-		return "", errors.New("GEMINI_API_KEY environment variable not set")
-	}
+// geminiURL returns the URL that generates content with a model.
+func geminiURL(endpoint, model, apiKey string) string {
+	return strings.TrimSuffix(endpoint, "/") + "/models/" + model + ":generateContent?key=" + apiKey
+}
+
+// --- API Call Logic ---
 
+func callGemini(ctx context.Context, url string, history []ChatMessage, userMsg string) (string, error) {
 	var contents []geminiContent
 	for _, msg := range history {
 		role := "user"
@@ -62,7 +77,6 @@ func callGemini(ctx context.Context, apiKey string, history []ChatMessage, userM
 		return "", fmt.Errorf("failed to marshal request: %w", err)
 	}
 
-	url := "https://generativelanguage.googleapis.com/v1beta/models/gemini-1.5-flash-latest:generateContent?key=" + apiKey
 	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(buf))
 	if err != nil {
 		return "", fmt.Errorf("failed to create request: %w", err)
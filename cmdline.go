@@ -0,0 +1,323 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+// --- Command-Line History and Completion ---
+
+// historyPrompts are the command-line prompts that each keep a history.
+const historyPrompts = ":/"
+
+// historyKey returns the prompt whose history is used at prompt: searches
+// with ? share the history of /.
+func historyKey(prompt byte) byte {
+	if prompt == '?' {
+		return '/'
+	}
+	return prompt
+}
+
+// historyBrowse is the state of moving through the history with Up and Down.
+type historyBrowse struct {
+	typed string // Text typed before browsing; only entries starting with it are shown
+	idx   int    // Entry shown; len(history) for the typed text
+}
+
+// completion is a command-line completion being cycled through with Tab.
+type completion struct {
+	head    string // Command-line text before the word being completed
+	word    string // The word as typed
+	matches []string
+	index   int // Selected match; -1 for the word as typed
+}
+
+func init() {
+	registerOption(&option{name: "history", short: "hi", kind: numberOption, def: 100,
+		help: "Number of command lines and searches remembered"})
+}
+
+// stateDir returns the directory Air keeps data in between sessions:
+// $XDG_STATE_HOME/air, or ~/.local/state/air.
+func stateDir() string {
+	if dir := os.Getenv("XDG_STATE_HOME"); dir != "" {
+		return filepath.Join(dir, "air")
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".local", "state", "air")
+}
+
+// historyFile returns the file the command-line history is saved in.
+func historyFile() string {
+	if dir := stateDir(); dir != "" {
+		return filepath.Join(dir, "history")
+	}
+	return ""
+}
+
+// loadHistory reads the history saved by earlier sessions. Each line of the
+// file is an entry preceded by its prompt character.
+func (e *Editor) loadHistory() {
+	if e.historyPath == "" {
+		return
+	}
+	lines, err := readLines(e.historyPath)
+	if err != nil {
+		return
+	}
+	for _, line := range lines {
+		if line != "" && strings.IndexByte(historyPrompts, line[0]) >= 0 {
+			e.addHistoryEntry(line[0], line[1:])
+		}
+	}
+}
+
+// saveHistory writes the history so later sessions can use it.
+func (e *Editor) saveHistory() {
+	if e.historyPath == "" {
+		return
+	}
+	var lines []string
+	for i := range historyPrompts {
+		for _, entry := range e.history[historyPrompts[i]] {
+			lines = append(lines, string(historyPrompts[i])+entry)
+		}
+	}
+	if err := writeLines(e.historyPath, lines, false); err != nil {
+		Log(fmt.Sprintf("Error saving history: %v", err))
+	}
+}
+
+// addHistory records an entered command line, such as ":w" or "/foo", and
+// saves the history.
+func (e *Editor) addHistory(line string) {
+	if line == "" || strings.IndexByte(historyPrompts, historyKey(line[0])) < 0 || strings.TrimSpace(line[1:]) == "" {
+		return
+	}
+	e.addHistoryEntry(historyKey(line[0]), line[1:])
+	e.saveHistory()
+}
+
+// addHistoryEntry adds an entry to the history of a prompt, dropping an
+// earlier copy of it and the oldest entries beyond the history option.
+func (e *Editor) addHistoryEntry(prompt byte, entry string) {
+	if e.history == nil {
+		e.history = make(map[byte][]string)
+	}
+	list := e.history[prompt][:0:0]
+	for _, h := range e.history[prompt] {
+		if h != entry {
+			list = append(list, h)
+		}
+	}
+	list = append(list, entry)
+	if max := e.intOpt("history"); len(list) > max {
+		list = list[len(list)-max:]
+	}
+	e.history[prompt] = list
+}
+
+// recallHistory shows the previous (dir -1) or next (dir 1) history entry
+// that starts with the text typed at the prompt.
+func (e *Editor) recallHistory(dir int) {
+	text := e.commandInput.GetText()
+	if text == "" {
+		return
+	}
+	prompt := text[0]
+	list := e.history[historyKey(prompt)]
+	if e.browse == nil {
+		e.browse = &historyBrowse{typed: text[1:], idx: len(list)}
+	}
+	for i := e.browse.idx + dir; i >= 0 && i <= len(list); i += dir {
+		if i == len(list) {
+			e.browse.idx = i
+			e.commandInput.SetText(string(prompt) + e.browse.typed)
+			return
+		}
+		if strings.HasPrefix(list[i], e.browse.typed) {
+			e.browse.idx = i
+			e.commandInput.SetText(string(prompt) + list[i])
+			return
+		}
+	}
+}
+
+// commandLineKey handles the keys of the command line that the input field
+// does not: Up and Down for the history and Tab and Shift-Tab to complete.
+func (e *Editor) commandLineKey(event *tcell.EventKey) *tcell.EventKey {
+	switch event.Key() {
+	case tcell.KeyUp, tcell.KeyDown:
+		e.endCompletion()
+		if event.Key() == tcell.KeyUp {
+			e.recallHistory(-1)
+		} else {
+			e.recallHistory(1)
+		}
+		return nil
+	case tcell.KeyTab, tcell.KeyBacktab:
+		e.browse = nil
+		if event.Key() == tcell.KeyTab {
+			e.completeNext(1)
+		} else {
+			e.completeNext(-1)
+		}
+		return nil
+	}
+	e.browse = nil
+	e.endCompletion()
+	return event
+}
+
+// skipRange returns the length of the range at the start of an ex command
+// line, without evaluating it.
+func skipRange(s string) int {
+	i := 0
+	for i < len(s) {
+		switch c := s[i]; {
+		case c >= '0' && c <= '9', strings.IndexByte(" \t.$%,;+-", c) >= 0:
+			i++
+		case c == '\'':
+			i += 2
+		case c == '/' || c == '?':
+			_, rest, _ := splitPattern(s[i+1:], c)
+			i = len(s) - len(rest)
+		default:
+			return i
+		}
+	}
+	return len(s)
+}
+
+// completeCommandLine finds the completions of the word before the end of a
+// command line: a command name, or the argument of a command that can
+// complete one. head is the text before the word.
+func (e *Editor) completeCommandLine(text string) (head, word string, matches []string) {
+	if !strings.HasPrefix(text, ":") {
+		return "", "", nil
+	}
+	s := text[1:]
+	i := len(s) - len(strings.TrimLeft(s, " \t:"))
+	i += skipRange(s[i:])
+	j := i
+	for j < len(s) && isExNameChar(s[j]) {
+		j++
+	}
+	if j == len(s) {
+		word = s[i:]
+		var names []string
+		for name := range exCommands {
+			if isExNameChar(name[0]) && strings.HasPrefix(name, word) {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		return text[:1+i], word, names
+	}
+
+	if j == i {
+		return "", "", nil
+	}
+	cmd := lookupEx(s[i:j])
+	if cmd == nil || cmd.complete == nil {
+		return "", "", nil
+	}
+	rest := s[j:]
+	if cmd.bang && strings.HasPrefix(rest, "!") {
+		rest = rest[1:]
+	}
+	if rest == "" || rest[0] != ' ' && rest[0] != '\t' {
+		return "", "", nil
+	}
+	word = rest[strings.LastIndexAny(rest, " \t")+1:]
+	return text[:len(text)-len(word)], word, cmd.complete(e, word)
+}
+
+// completeNext completes the word before the end of the command line. A
+// single match is inserted; several open the wildmenu, and each Tab
+// (dir 1) or Shift-Tab (dir -1) selects the next or previous one, and
+// finally the word as typed.
+func (e *Editor) completeNext(dir int) {
+	if e.wild == nil {
+		head, word, matches := e.completeCommandLine(e.commandInput.GetText())
+		switch len(matches) {
+		case 0:
+			return
+		case 1:
+			e.commandInput.SetText(head + matches[0])
+			return
+		}
+		e.wild = &completion{head: head, word: word, matches: matches, index: -1}
+		e.rebuildLayout()
+	}
+	w := e.wild
+	n := len(w.matches) + 1
+	w.index = (w.index+1+dir+n)%n - 1
+	if w.index < 0 {
+		e.commandInput.SetText(w.head + w.word)
+	} else {
+		e.commandInput.SetText(w.head + w.matches[w.index])
+	}
+	e.renderWildMenu()
+}
+
+// endCompletion closes the wildmenu, keeping the selected match.
+func (e *Editor) endCompletion() {
+	if e.wild != nil {
+		e.wild = nil
+		e.rebuildLayout()
+	}
+}
+
+// renderWildMenu shows the matches of the completion on one line above the
+// status bar, with the selected one highlighted. Matches that do not fit are
+// shown a page at a time, with < and > marking the hidden ones.
+func (e *Editor) renderWildMenu() {
+	w := e.wild
+	_, _, width, _ := e.statusBar.GetInnerRect()
+	start, end := 0, len(w.matches)
+	if width > 0 {
+		sel := w.index
+		if sel < 0 {
+			sel = 0
+		}
+		for start = 0; ; start = end {
+			used := 4 + len(w.matches[start]) // With room for the < and > markers
+			for end = start + 1; end < len(w.matches) && used+2+len(w.matches[end]) <= width; end++ {
+				used += 2 + len(w.matches[end])
+			}
+			if sel < end {
+				break
+			}
+		}
+	}
+
+	var b strings.Builder
+	if start > 0 {
+		b.WriteString("< ")
+	}
+	for i := start; i < end; i++ {
+		if i > start {
+			b.WriteString("  ")
+		}
+		if i == w.index {
+			fmt.Fprintf(&b, "%s%s[-:-]", e.colorTag("menucolor"), tview.Escape(w.matches[i]))
+		} else {
+			b.WriteString(tview.Escape(w.matches[i]))
+		}
+	}
+	if end < len(w.matches) {
+		b.WriteString(" >")
+	}
+	e.wildView.SetText(b.String())
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/rivo/tview"
+)
+
+// typeCommandLine feeds keys to the command-line input field, as typed after
+// : or / in normal mode.
+func typeCommandLine(e *Editor, keys string) {
+	handler := e.commandInput.InputHandler()
+	for _, ev := range parseKeys(keys) {
+		handler(ev, func(p tview.Primitive) {})
+	}
+}
+
+func TestCommandLineHistory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "history")
+	e := newTestEditor("one", "two", "three")
+	e.historyPath = path
+	typeKeys(e, ":")
+	typeCommandLine(e, "2<CR>")
+	typeKeys(e, ":")
+	typeCommandLine(e, "set ts=8<CR>")
+	typeKeys(e, ":")
+	typeCommandLine(e, "3<CR>")
+	typeKeys(e, "/")
+	typeCommandLine(e, "two<CR>")
+
+	typeKeys(e, ":")
+	typeCommandLine(e, "<Up>")
+	if got := e.commandInput.GetText(); got != ":3" {
+		t.Fatalf("Up should recall the last command, got %q", got)
+	}
+	typeCommandLine(e, "<Up><Up>")
+	if got := e.commandInput.GetText(); got != ":2" {
+		t.Fatalf("second and third Up: got %q", got)
+	}
+	typeCommandLine(e, "<Up>")
+	if got := e.commandInput.GetText(); got != ":2" {
+		t.Fatalf("Up at the oldest entry should stay, got %q", got)
+	}
+	typeCommandLine(e, "<Down><Down><Down>")
+	if got := e.commandInput.GetText(); got != ":" {
+		t.Fatalf("Down past the newest entry should restore the typed text, got %q", got)
+	}
+	typeCommandLine(e, "s<Up>")
+	if got := e.commandInput.GetText(); got != ":set ts=8" {
+		t.Fatalf("Up should only recall entries starting with the typed text, got %q", got)
+	}
+	typeCommandLine(e, "<Esc>")
+
+	typeKeys(e, "/")
+	typeCommandLine(e, "<Up>")
+	if got := e.commandInput.GetText(); got != "/two" {
+		t.Fatalf("searches should have their own history, got %q", got)
+	}
+	typeCommandLine(e, "<Esc>")
+
+	if got := readFile(t, path); got != ":2\n:set ts=8\n:3\n/two" {
+		t.Fatalf("saved history %q", got)
+	}
+	e2 := newTestEditor("")
+	e2.historyPath = path
+	e2.exec("set history=2")
+	e2.loadHistory()
+	if want := []string{"set ts=8", "3"}; !reflect.DeepEqual(e2.history[':'], want) {
+		t.Fatalf("loaded history %q, want %q", e2.history[':'], want)
+	}
+}
+
+func TestCommandLineCompletion(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"alpha.go", "alpine.txt", "beta.go"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	e := newTestEditor("")
+	e.chatHistory = []ChatMessage{{"user", "q"}, {"model", "a"}, {"user", "q"}, {"model", "b"}}
+	tests := []struct {
+		text  string
+		head  string
+		words []string
+	}{
+		{":wr", ":", []string{"write"}},
+		{":%subs", ":%", []string{"substitute"}},
+		{":'a,'bdel", ":'a,'b", []string{"delete"}},
+		{":se expandtab shi", ":se expandtab ", []string{"shiftwidth"}},
+		{":set noe", ":set ", []string{"noexpandtab"}},
+		{":w " + dir + "/alp", ":w ", []string{dir + "/alpha.go", dir + "/alpine.txt"}},
+		{":copy ", ":copy ", []string{"1", "2"}},
+		{":s/a/b/ ", "", nil},
+		{":!ls ", "", nil},
+		{"/wr", "", nil},
+	}
+	for _, tt := range tests {
+		head, _, matches := e.completeCommandLine(tt.text)
+		if head != tt.head || !reflect.DeepEqual(matches, tt.words) {
+			t.Errorf("%q: got %q %q, want %q %q", tt.text, head, matches, tt.head, tt.words)
+		}
+	}
+
+	typeKeys(e, ":")
+	typeCommandLine(e, "e "+dir+"/<Tab>")
+	if e.wild == nil || e.commandInput.GetText() != ":e "+dir+"/alpha.go" {
+		t.Fatalf("first Tab should select the first match, got %q", e.commandInput.GetText())
+	}
+	typeCommandLine(e, "<Tab><Tab>")
+	if got := e.commandInput.GetText(); got != ":e "+dir+"/beta.go" {
+		t.Fatalf("Tab should cycle the matches, got %q", got)
+	}
+	typeCommandLine(e, "<Tab>")
+	if got := e.commandInput.GetText(); got != ":e "+dir+"/" {
+		t.Fatalf("Tab after the last match should restore the typed word, got %q", got)
+	}
+	typeCommandLine(e, "<S-Tab>")
+	if got := e.commandInput.GetText(); got != ":e "+dir+"/beta.go" {
+		t.Fatalf("Shift-Tab should go back, got %q", got)
+	}
+	typeCommandLine(e, "<CR>")
+	if e.wild != nil || e.buffer.FilePath != dir+"/beta.go" {
+		t.Fatalf("Enter should run the completed command: wild %v, buffer %q", e.wild, e.buffer.FilePath)
+	}
+
+	typeKeys(e, ":")
+	typeCommandLine(e, "b alph<Tab>")
+	if got := e.commandInput.GetText(); got != ":b alph" || e.wild != nil {
+		t.Fatalf("buffers not open should not complete, got %q", got)
+	}
+	typeCommandLine(e, "<Esc>")
+	e.exec("e " + dir + "/alpha.go")
+	typeKeys(e, ":")
+	typeCommandLine(e, "b bet<Tab>")
+	if got := e.commandInput.GetText(); got != ":b "+dir+"/beta.go" || e.wild != nil {
+		t.Fatalf("a single match should be inserted without the wildmenu, got %q", got)
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// --- Startup Configuration ---
+
+// projectConfigName is the name of the project-local config file, looked for
+// at the root of the repository Air is started in.
+const projectConfigName = ".airrc"
+
+// maxSourceDepth limits how deeply config files can :source each other.
+const maxSourceDepth = 10
+
+func init() {
+	registerEx(&exCommand{name: "source", abbrev: "so", complete: completeFiles, run: func(e *Editor, c *exCall) error {
+		path, err := pathArg(c.arg)
+		if err != nil {
+			return err
+		}
+		if path == "" {
+			return fmt.Errorf("Argument required")
+		}
+		errs := e.source(path)
+		if len(errs) == 1 {
+			return errs[0]
+		}
+		e.reportConfigErrors(errs)
+		return nil
+	}})
+}
+
+// configDir returns the directory of the user's config:
+// $XDG_CONFIG_HOME/air, or ~/.config/air.
+func configDir() string {
+	if dir := os.Getenv("XDG_CONFIG_HOME"); dir != "" {
+		return filepath.Join(dir, "air")
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".config", "air")
+}
+
+// projectConfig returns the project-local config file for dir: .airrc at the
+// root of the git repository holding dir, or in dir itself outside a
+// repository. It returns "" if there is no such file.
+func projectConfig(dir string) string {
+	root := gitRoot(dir)
+	if root == "" {
+		root = dir
+	}
+	path := filepath.Join(root, projectConfigName)
+	if _, err := os.Stat(path); err != nil {
+		return ""
+	}
+	return path
+}
+
+// gitRoot returns the root of the git repository holding dir, or "" if it
+// is not in one.
+func gitRoot(dir string) string {
+	for d := dir; ; d = filepath.Dir(d) {
+		if _, err := os.Stat(filepath.Join(d, ".git")); err == nil {
+			return d
+		}
+		if filepath.Dir(d) == d {
+			return ""
+		}
+	}
+}
+
+// loadConfig runs the user's config, ~/.config/air/airrc, and then the
+// project's .airrc, and reports any errors. Commands in the project config
+// cannot run shell commands, write files, set secure options or map keys.
+func (e *Editor) loadConfig() {
+	var errs []error
+	if dir := configDir(); dir != "" {
+		if path := filepath.Join(dir, "airrc"); fileExists(path) {
+			errs = append(errs, e.source(path)...)
+		}
+	}
+	if cwd, err := os.Getwd(); err == nil {
+		if path := projectConfig(cwd); path != "" {
+			e.secure = true
+			errs = append(errs, e.source(path)...)
+			e.secure = false
+		}
+	}
+	e.reportConfigErrors(errs)
+}
+
+// fileExists reports whether path names an existing file.
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// source runs the ex commands of a file, one per line. Blank lines and
+// lines starting with " are skipped. Each error is returned with the file
+// and line it came from, and the remaining lines still run.
+func (e *Editor) source(path string) []error {
+	if e.sourceDepth >= maxSourceDepth {
+		return []error{fmt.Errorf("%s: Files sourced too deeply", path)}
+	}
+	lines, err := readLines(path)
+	if err != nil {
+		return []error{fmt.Errorf("Cannot read %s: %v", path, err)}
+	}
+	e.sourceDepth++
+	defer func() { e.sourceDepth-- }()
+
+	var errs []error
+	for i, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "\"") {
+			continue
+		}
+		if err := e.runEx(line); err != nil {
+			errs = append(errs, fmt.Errorf("%s:%d: %v", path, i+1, err))
+		}
+		e.mode = ModeNormal
+	}
+	return errs
+}
+
+// reportConfigErrors shows errors from config files: a single one in the
+// status bar, several in the pane.
+func (e *Editor) reportConfigErrors(errs []error) {
+	switch len(errs) {
+	case 0:
+		return
+	case 1:
+		e.statusMsg = errs[0].Error()
+		return
+	}
+	var b strings.Builder
+	for _, err := range errs {
+		b.WriteString(err.Error() + "\n")
+	}
+	e.showPane("Config errors", b.String())
+	e.statusMsg = fmt.Sprintf("%d errors in config files", len(errs))
+}
+
+// checkSecure returns an error while a project config runs, for commands
+// that run programs or write files, options that could make the editor do
+// so or send secrets elsewhere, and mappings, which could do either later.
+func (e *Editor) checkSecure() error {
+	if e.secure {
+		return fmt.Errorf("Not allowed in a project config")
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSourceReportsFileAndLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "airrc")
+	writeFile(t, path, "\" Settings\nset ts=8\n\nset nosuch\n:nnoremap <leader>x dd\nset keywordcolor=nocolor\n")
+	e := newTestEditor("one")
+	errs := e.source(path)
+	if len(errs) != 2 || errs[0].Error() != path+":4: Unknown option: nosuch" || errs[1].Error() != path+":6: Unknown color: nocolor" {
+		t.Fatalf("errors %v", errs)
+	}
+	if e.intOpt("tabstop") != 8 || e.keymaps['n'][`\x`] == nil {
+		t.Fatal("lines after an error should still run")
+	}
+
+	e.exec("source " + path)
+	if !e.paneVisible || e.statusMsg != "2 errors in config files" {
+		t.Fatalf(":source with errors: status %q", e.statusMsg)
+	}
+
+	loop := filepath.Join(t.TempDir(), "loop")
+	writeFile(t, loop, "source "+loop+"\n")
+	e.exec("source " + loop)
+	if !strings.Contains(e.outputView.GetText(false), "Files sourced too deeply") && !strings.Contains(e.statusMsg, "Files sourced too deeply") {
+		t.Fatalf("self-sourcing file: status %q", e.statusMsg)
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(home, "config"))
+	writeFile(t, filepath.Join(home, "config", "air", "airrc"), "set ts=2\nset sw=2\n")
+
+	repo := filepath.Join(home, "repo")
+	sub := filepath.Join(repo, "src", "pkg")
+	for _, dir := range []string{filepath.Join(repo, ".git"), sub} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile(t, filepath.Join(repo, ".airrc"), "set sw=6\n!echo hi\nw "+filepath.Join(home, "out")+"\nset aiendpoint=https://example.com aikeyvar=HOME\nset aikeyvar?\nset grepprg=touch\\ pwned\nnnoremap Q <Cmd>!touch pwned<CR>\n")
+	if got := projectConfig(sub); got != filepath.Join(repo, ".airrc") {
+		t.Fatalf("project config %q", got)
+	}
+
+	wd, _ := os.Getwd()
+	if err := os.Chdir(sub); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	e := newTestEditor("x")
+	e.loadConfig()
+	if e.intOpt("tabstop") != 2 || e.intOpt("shiftwidth") != 6 {
+		t.Fatalf("ts=%d sw=%d: the project config should apply after the user's", e.intOpt("tabstop"), e.intOpt("shiftwidth"))
+	}
+	text := e.outputView.GetText(false)
+	if !strings.Contains(text, ".airrc:2: Not allowed in a project config") || !strings.Contains(text, ".airrc:3: Not allowed in a project config") {
+		t.Fatalf("shell commands and writes should be refused:\n%s", text)
+	}
+	if !strings.Contains(text, ".airrc:4: Not allowed in a project config") || strings.Contains(text, ".airrc:5:") ||
+		!strings.Contains(text, ".airrc:6: Not allowed in a project config") || e.stringOpt("grepprg") != "" {
+		t.Fatalf("secure options should only be refused when set:\n%s", text)
+	}
+	if !strings.Contains(text, ".airrc:7: Not allowed in a project config") || e.keymaps['n']["Q"] != nil {
+		t.Fatalf("mappings should be refused:\n%s", text)
+	}
+	if e.stringOpt("aiendpoint") != options["aiendpoint"].def || e.stringOpt("aikeyvar") != "GEMINI_API_KEY" {
+		t.Fatalf("the project config set aiendpoint=%q aikeyvar=%q", e.stringOpt("aiendpoint"), e.stringOpt("aikeyvar"))
+	}
+	if _, err := os.Stat(filepath.Join(home, "out")); err == nil {
+		t.Fatal("the project config wrote a file")
+	}
+	if e.secure {
+		t.Fatal("secure mode should end with the project config")
+	}
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// --- Line Editing Commands ---
+
+// sessionKey applies a key of an insert or replace session.
+func (e *Editor) sessionKey(event *tcell.EventKey) {
+	if e.mode == ModeReplace {
+		e.replaceKey(event)
+		return
+	}
+	e.insertKey(event)
+}
+
+// nextCol returns the column after the character at col on the cursor line,
+// and false if col is already at the end of the line.
+func (e *Editor) nextCol(col int) (int, bool) {
+	line := e.buffer.Lines[e.cy]
+	if col >= len(line) {
+		return len(line), false
+	}
+	_, size := utf8.DecodeRuneInString(line[col:])
+	return col + size, true
+}
+
+// indentUnit returns the text added or removed by one level of indentation:
+// shiftwidth columns of spaces with expandtab set, or else as many tabs as
+// fit and spaces for the rest.
+func (e *Editor) indentUnit() string {
+	ts := e.intOpt("tabstop")
+	sw := e.intOpt("shiftwidth")
+	if sw == 0 {
+		sw = ts
+	}
+	if e.boolOpt("expandtab") {
+		return strings.Repeat(" ", sw)
+	}
+	return strings.Repeat("\t", sw/ts) + strings.Repeat(" ", sw%ts)
+}
+
+// openLine opens a new line below or above the cursor line and starts
+// inserting on it (o, O). A count opens that many lines with the same text.
+func (e *Editor) openLine(below bool, count int) {
+	e.pushUndo()
+	open := func() {
+		y := e.cy
+		indent := leadingWhitespace(e.buffer.Lines[e.cy])
+		if below {
+			y++
+			indent = e.newLineIndent(e.buffer.Lines[e.cy])
+		} else if !e.boolOpt("autoindent") {
+			indent = ""
+		}
+		e.buffer.InsertLines(y, indent)
+		e.cy, e.cx = y, len(indent)
+		e.autoIndented = indent != ""
+	}
+	open()
+	e.startInsert(count)
+	below = true // Repetitions go below the line just typed
+	e.insertAgain = open
+}
+
+// startReplace enters replace mode, where typed characters overwrite the text (R).
+func (e *Editor) startReplace(count int) {
+	e.startInsert(count)
+	e.mode = ModeReplace
+	e.replaced = nil
+}
+
+// replaceKey applies a single replace-mode key. Backspace restores the
+// characters overwritten in this session.
+func (e *Editor) replaceKey(event *tcell.EventKey) {
+	switch event.Key() {
+	case tcell.KeyRune:
+		e.pushUndo()
+		line := e.buffer.Lines[e.cy]
+		s := string(event.Rune())
+		next, _ := e.nextCol(e.cx)
+		e.replaced = append(e.replaced, line[e.cx:next])
+		e.buffer.SetLine(e.cy, line[:e.cx]+s+line[next:])
+		e.cx += len(s)
+	case tcell.KeyBackspace, tcell.KeyBackspace2:
+		if len(e.replaced) == 0 || e.cx == 0 {
+			e.moveCursor(-1)
+			return
+		}
+		e.pushUndo()
+		line := e.buffer.Lines[e.cy]
+		orig := e.replaced[len(e.replaced)-1]
+		e.replaced = e.replaced[:len(e.replaced)-1]
+		_, size := utf8.DecodeLastRuneInString(line[:e.cx])
+		e.cx -= size
+		e.buffer.SetLine(e.cy, line[:e.cx]+orig+line[e.cx+size:])
+	case tcell.KeyEnter:
+		e.insertNewline()
+		e.replaced = nil
+	default:
+		e.insertKey(event)
+	}
+}
+
+// joinLines joins count lines, at least two, starting at the cursor line
+// (J, gJ). With spaces set, leading whitespace of each joined line is
+// replaced by a single space, as J does.
+func (e *Editor) joinLines(count int, spaces bool) {
+	if count < 2 {
+		count = 2
+	}
+	if e.cy+1 >= len(e.buffer.Lines) {
+		return
+	}
+	e.pushUndo()
+	last := clamp(e.cy+count-1, e.cy+1, len(e.buffer.Lines)-1)
+	joined := e.buffer.Lines[e.cy]
+	col := 0
+	for y := e.cy + 1; y <= last; y++ {
+		next := e.buffer.Lines[y]
+		if spaces {
+			next = strings.TrimLeftFunc(next, unicode.IsSpace)
+			joined = strings.TrimRightFunc(joined, unicode.IsSpace)
+			col = len(joined)
+			if next != "" && joined != "" && !strings.HasPrefix(next, ")") {
+				joined += " "
+			}
+		} else {
+			col = len(joined)
+		}
+		joined += next
+	}
+	e.buffer.SetLine(e.cy, joined)
+	e.buffer.DeleteLines(e.cy+1, last+1)
+	e.cx = col
+}
+
+// replaceChars replaces count characters under the cursor with r (r).
+func (e *Editor) replaceChars(r rune, count int) {
+	line := e.buffer.Lines[e.cy]
+	if utf8.RuneCountInString(line[e.cx:]) < count {
+		return
+	}
+	e.pushUndo()
+	end := e.cx
+	for i := 0; i < count; i++ {
+		_, size := utf8.DecodeRuneInString(line[end:])
+		end += size
+	}
+	repl := strings.Repeat(string(r), count)
+	e.buffer.SetLine(e.cy, line[:e.cx]+repl+line[end:])
+	e.cx += len(repl) - len(string(r))
+}
+
+// toggleCase switches the case of count characters and moves past them (~).
+func (e *Editor) toggleCase(count int) {
+	line := e.buffer.Lines[e.cy]
+	if e.cx >= len(line) {
+		return
+	}
+	e.pushUndo()
+	end := e.cx
+	var b strings.Builder
+	for i := 0; i < count && end < len(line); i++ {
+		r, size := utf8.DecodeRuneInString(line[end:])
+		if unicode.IsUpper(r) {
+			r = unicode.ToLower(r)
+		} else {
+			r = unicode.ToUpper(r)
+		}
+		b.WriteRune(r)
+		end += size
+	}
+	e.buffer.SetLine(e.cy, line[:e.cx]+b.String()+line[end:])
+	e.cx = e.cx + b.Len()
+}
+
+// shiftLines changes the indentation of lines first to last by one level,
+// adding it for dir > 0 and removing it otherwise (>>, <<).
+func (e *Editor) shiftLines(first, last, dir int) {
+	e.pushUndo()
+	for y := first; y <= last; y++ {
+		if line := e.buffer.Lines[y]; line != "" {
+			e.buffer.SetLine(y, e.shiftedLine(line, dir))
+		}
+	}
+	e.cy = first
+	e.cx = firstNonBlank(e.buffer.Lines[first])
+}
+
+// shiftedLine returns line with one level of indentation added for dir > 0,
+// or up to one level's worth of leading whitespace removed otherwise.
+func (e *Editor) shiftedLine(line string, dir int) string {
+	unit := e.indentUnit()
+	if dir > 0 {
+		return unit + line
+	}
+	ts := e.intOpt("tabstop")
+	width := displayCol(unit, len(unit), ts)
+	cut := 0
+	for cut < len(line) && (line[cut] == ' ' || line[cut] == '\t') && displayCol(line, cut+1, ts) <= width {
+		cut++
+	}
+	return line[cut:]
+}
+
+// put pastes the clipboard count times after or before the cursor (p, P).
+// Whole lines go below or above the cursor line; other text goes after or
+// before the cursor character.
+func (e *Editor) put(after bool, count int) {
+	if e.clipboard == "" {
+		e.statusMsg = "Nothing to paste"
+		return
+	}
+	e.pushUndo()
+	if e.clipboardLinewise {
+		text := strings.TrimSuffix(e.clipboard, "\n")
+		var lines []string
+		for i := 0; i < count; i++ {
+			lines = append(lines, strings.Split(text, "\n")...)
+		}
+		y := e.cy
+		if after {
+			y++
+		}
+		e.buffer.InsertLines(y, lines...)
+		e.cy = y
+		e.cx = firstNonBlank(e.buffer.Lines[y])
+		return
+	}
+
+	text := strings.Repeat(e.clipboard, count)
+	at := pos{e.cy, e.cx}
+	if after && e.buffer.Lines[e.cy] != "" {
+		at.col, _ = e.nextCol(e.cx)
+	}
+	end := e.insertText(at, text)
+	if strings.Contains(text, "\n") {
+		e.cy, e.cx = at.line, at.col
+	} else {
+		e.cy, e.cx = end.line, end.col
+		if p, ok := e.prevPos(end); ok && p.line == end.line {
+			e.cx = p.col
+		}
+	}
+}
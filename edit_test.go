@@ -0,0 +1,53 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEditingCommands(t *testing.T) {
+	tests := []struct {
+		lines []string
+		keys  string
+		want  []string
+	}{
+		{[]string{"abc"}, "aX<Esc>", []string{"aXbc"}},
+		{[]string{"abc"}, "AX<Esc>", []string{"abcX"}},
+		{[]string{"  abc"}, "$IX<Esc>", []string{"  Xabc"}},
+		{[]string{"a", "b"}, "ox<Esc>", []string{"a", "x", "b"}},
+		{[]string{"a", "b"}, "jOx<Esc>", []string{"a", "x", "b"}},
+		{[]string{"a"}, "2ox<Esc>", []string{"a", "x", "x"}},
+		{[]string{"one", "  two", "three"}, "3J", []string{"one two three"}},
+		{[]string{"one", "  two"}, "gJ", []string{"one  two"}},
+		{[]string{"f(", ")"}, "J", []string{"f()"}},
+		{[]string{"abcd"}, "2rx", []string{"xxcd"}},
+		{[]string{"abcd"}, "lRXY<Esc>", []string{"aXYd"}},
+		{[]string{"ab"}, "lRXYZ<BS><BS><Esc>", []string{"aX"}},
+		{[]string{"aBc"}, "3~", []string{"AbC"}},
+		{[]string{"a", "b"}, "2>>", []string{"\ta", "\tb"}},
+		{[]string{"\t\ta"}, "<<", []string{"\ta"}},
+		{[]string{"a", "b"}, ">j", []string{"\ta", "\tb"}},
+		{[]string{"a", "b"}, "yyjp", []string{"a", "b", "a"}},
+		{[]string{"a", "b"}, "jyykP", []string{"b", "a", "b"}},
+		{[]string{"one two"}, "yw$p", []string{"one twoone "}},
+		{[]string{"one two"}, "dwP", []string{"one two"}},
+		{[]string{"ab"}, "x2p", []string{"baa"}},
+	}
+	for _, tt := range tests {
+		e := newTestEditor(tt.lines...)
+		typeKeys(e, tt.keys)
+		if !reflect.DeepEqual(e.buffer.Lines, tt.want) {
+			t.Errorf("%q on %q: got %q, want %q", tt.keys, tt.lines, e.buffer.Lines, tt.want)
+		}
+	}
+}
+
+func TestEditingCommandsAreSingleUndoSteps(t *testing.T) {
+	for _, keys := range []string{"3J", "3~", "2>>", "yy3p", "2rx"} {
+		e := newTestEditor("abc", "def", "ghi")
+		typeKeys(e, keys+"u")
+		if want := []string{"abc", "def", "ghi"}; !reflect.DeepEqual(e.buffer.Lines, want) {
+			t.Errorf("%q then u: got %q", keys, e.buffer.Lines)
+		}
+	}
+}
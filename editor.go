@@ -4,10 +4,11 @@ import (
 	"context"
 	"fmt"
 	"os"
-	"strconv"
+	"path/filepath"
 	"strings"
 	"time"
 	"unicode"
+	"unicode/utf8"
 
 	"github.com/gdamore/tcell/v2"
 	"github.com/rivo/tview"
@@ -17,10 +18,10 @@ import (
 
 func NewEditor() *Editor {
 	e := &Editor{
-		app:       tview.NewApplication(),
-		mode:      ModeNormal,
-		undoStack: make([][]string, 0),
-		redoStack: make([][]string, 0),
+		app:        tview.NewApplication(),
+		mode:       ModeNormal,
+		options:    make(map[string]interface{}),
+		winOptions: make(map[string]interface{}),
 	}
 
 	// Initialize UI components
@@ -33,6 +34,15 @@ func NewEditor() *Editor {
 		SetRegions(true).
 		SetWrap(true)
 	e.chatInput = tview.NewInputField().SetLabel("You: ").SetLabelColor(tcell.ColorYellow)
+	e.outputView = tview.NewTextView().SetScrollable(true).SetWrap(false)
+	e.outputView.SetBorder(true)
+	e.wildView = tview.NewTextView().SetDynamicColors(true).SetWrap(false)
+	e.quickfixView = tview.NewTextView().SetDynamicColors(true).SetScrollable(true).SetWrap(false)
+	e.quickfixView.SetBorder(true)
+	e.replaceView = tview.NewTextView().SetDynamicColors(true).SetScrollable(true).SetWrap(false)
+	e.replaceView.SetBorder(true)
+	e.undoView = tview.NewTextView().SetDynamicColors(true).SetScrollable(true).SetWrap(false)
+	e.undoView.SetBorder(true)
 
 	// Assemble chat panel
 	e.chatPanel = tview.NewFlex().SetDirection(tview.FlexRow).
@@ -54,30 +64,33 @@ func NewEditor() *Editor {
 
 	// Assemble main layout
 	e.mainLayout = tview.NewFlex().SetDirection(tview.FlexColumn)
-	layout := tview.NewFlex().SetDirection(tview.FlexRow).
-		AddItem(e.mainLayout, 0, 1, true).
-		AddItem(e.statusBar, 1, 0, false).
-		AddItem(e.commandInput, 1, 0, false)
+	e.rootLayout = tview.NewFlex().SetDirection(tview.FlexRow)
 
 	// Set root and input captures
-	e.app.SetRoot(layout, true).EnableMouse(true)
+	e.pages = tview.NewPages().
+		AddPage("main", e.rootLayout, true, true).
+		AddPage("finder", e.newFinderView(), true, false)
+	e.app.SetRoot(e.pages, true).EnableMouse(true)
 	e.app.SetInputCapture(e.globalInput)
 	e.commandInput.SetDoneFunc(e.commandInputHandler)
+	e.commandInput.SetInputCapture(e.commandLineKey)
+	e.commandInput.SetChangedFunc(e.incSearch)
 	e.chatInput.SetDoneFunc(e.chatInputHandler)
 
+	e.setDefaultKeymaps()
 	e.rebuildLayout()
 	return e
 }
 
 func (e *Editor) Run() error {
 	// Start a ticker to force redraws, which helps with async UI updates from the chat.
-	ticker := time.NewTicker(100 * time.Millisecond)
+	e.ticker = time.NewTicker(time.Duration(e.intOpt("redrawtick")) * time.Millisecond)
 	go func() {
-		for range ticker.C {
+		for range e.ticker.C {
 			e.app.QueueUpdateDraw(func() {})
 		}
 	}()
-	defer ticker.Stop()
+	defer e.ticker.Stop()
 
 	e.render() // Initial render
 	return e.app.Run()
@@ -87,13 +100,67 @@ func (e *Editor) Run() error {
 
 func (e *Editor) rebuildLayout() {
 	e.mainLayout.Clear()
-	if e.chatVisible {
-		e.mainLayout.AddItem(e.mainView, 0, 1, true).AddItem(e.chatPanel, 40, 0, false)
-	} else {
+	switch {
+	case !e.chatVisible:
 		e.mainLayout.AddItem(e.mainView, 0, 1, true)
+	case e.stringOpt("chatposition") == "left":
+		e.mainLayout.AddItem(e.chatPanel, e.intOpt("chatwidth"), 0, false).AddItem(e.mainView, 0, 1, true)
+	default:
+		e.mainLayout.AddItem(e.mainView, 0, 1, true).AddItem(e.chatPanel, e.intOpt("chatwidth"), 0, false)
+	}
+
+	e.rootLayout.Clear()
+	e.rootLayout.AddItem(e.mainLayout, 0, 1, true)
+	if e.qfVisible {
+		e.rootLayout.AddItem(e.quickfixView, 10, 0, false)
+	}
+	if e.replaceAll != nil {
+		e.rootLayout.AddItem(e.replaceView, 15, 0, false)
+	}
+	if e.undoTree != nil {
+		e.rootLayout.AddItem(e.undoView, 12, 0, false)
+	}
+	if e.paneVisible {
+		e.rootLayout.AddItem(e.outputView, 12, 0, false)
+	}
+	if e.wild != nil {
+		e.rootLayout.AddItem(e.wildView, 1, 0, false)
+	}
+	e.rootLayout.AddItem(e.statusBar, 1, 0, false).
+		AddItem(e.commandInput, 1, 0, false)
+}
+
+// showPane opens the pane below the text with a title and content.
+func (e *Editor) showPane(title, text string) {
+	e.outputView.SetTitle(" " + title + " ")
+	e.outputView.SetText(text).ScrollToBeginning()
+	if !e.paneVisible {
+		e.paneVisible = true
+		e.rebuildLayout()
+	}
+}
+
+// closePane closes the pane below the text.
+func (e *Editor) closePane() {
+	if e.paneVisible {
+		e.paneVisible = false
+		e.rebuildLayout()
 	}
 }
 
+// queueUpdate runs f on the UI goroutine and redraws. Background work such
+// as shell commands reports back through it.
+func (e *Editor) queueUpdate(f func()) {
+	if e.update != nil {
+		e.update(f)
+		return
+	}
+	e.app.QueueUpdateDraw(func() {
+		f()
+		e.render()
+	})
+}
+
 func (e *Editor) render() {
 	if e.buffer == nil {
 		return
@@ -103,55 +170,22 @@ func (e *Editor) render() {
 
 	var builder strings.Builder
 	_, _, width, height := e.mainView.GetInnerRect()
+	tview.TabSize = e.intOpt("tabstop")
 
+	last := e.rowOffset + height - 1
+	if last >= len(e.buffer.Lines) {
+		last = len(e.buffer.Lines) - 1
+	}
+	var matches []searchMatch
+	if last >= e.rowOffset {
+		matches = e.highlightMatches(e.rowOffset, last)
+	}
 	for y := 0; y < height; y++ {
 		fileY := y + e.rowOffset
 		if fileY >= len(e.buffer.Lines) {
 			builder.WriteString("~")
 		} else {
-			line := e.buffer.Lines[fileY]
-			if fileY == e.cy && e.mode == ModeInsert {
-				// Special handling for the cursor line to draw the cursor manually
-				e.calculateRx()
-
-				// Safe substringing for horizontal scroll
-				if e.colOffset > len(line) {
-					line = ""
-				} else {
-					line = line[e.colOffset:]
-				}
-
-				// Truncate line to fit view width
-				if len(line) > width {
-					line = line[:width]
-				}
-
-				cursorX := e.rx - e.colOffset
-				if cursorX < 0 {
-					cursorX = 0
-				}
-
-				// Invert the character at the cursor position
-				if cursorX < len(line) {
-					line = fmt.Sprintf("%s[white:black]%c[-:-]%s", line[:cursorX], line[cursorX], line[cursorX+1:])
-				} else if cursorX == len(line) {
-					line = fmt.Sprintf("%s[white:black] [-:-]", line) // Show cursor at end of line
-				}
-
-			} else {
-				// Regular line rendering
-				line = highlightLineGo(line)
-				if e.colOffset < len(line) {
-					if e.colOffset+width > len(line) {
-						line = line[e.colOffset:]
-					} else {
-						line = line[e.colOffset : e.colOffset+width]
-					}
-				} else {
-					line = ""
-				}
-			}
-			builder.WriteString(line)
+			builder.WriteString(e.renderLine(fileY, width, matches))
 		}
 		builder.WriteString("\n")
 	}
@@ -160,11 +194,109 @@ func (e *Editor) render() {
 	e.renderStatus()
 }
 
+// renderLine returns line y of the buffer as the main view shows it: with
+// keywords, search matches and the cursor colored, tabs expanded, and
+// scrolled to colOffset and cut at width.
+func (e *Editor) renderLine(y, width int, matches []searchMatch) string {
+	line := e.buffer.Lines[y]
+	// The color tag of each byte, and of the cursor past the end of the line
+	tags := make([]string, len(line)+1)
+	highlightGo(line, tags, e.colorTag("keywordcolor"))
+	for _, m := range matches {
+		if m.start.line > y || m.end.line < y || m.start == m.end {
+			continue
+		}
+		from, to := 0, len(line)
+		if m.start.line == y {
+			from = m.start.col
+		}
+		if m.end.line == y {
+			to = m.end.col
+		}
+		tag := e.colorTag("searchcolor")
+		if e.incMatch != nil && m == *e.incMatch {
+			tag = e.colorTag("incsearchcolor")
+		}
+		for i := from; i < to; i++ {
+			tags[i] = tag
+		}
+	}
+	if y == e.cy && e.mode != ModeSearch {
+		// The cursor, or the whole match waiting for confirmation
+		n := e.confirmLen()
+		if n < 1 {
+			n = 1
+			if e.cx < len(line) {
+				_, n = utf8.DecodeRuneInString(line[e.cx:])
+			}
+		}
+		for i := e.cx; i < e.cx+n && i <= len(line); i++ {
+			tags[i] = e.colorTag("cursorcolor")
+		}
+	}
+
+	var b, run strings.Builder
+	tag := ""
+	flush := func() {
+		if run.Len() == 0 {
+			return
+		}
+		if tag == "" {
+			b.WriteString(tview.Escape(run.String()))
+		} else {
+			b.WriteString(tag + tview.Escape(run.String()) + "[-:-]")
+		}
+		run.Reset()
+	}
+	ts := e.intOpt("tabstop")
+	left, right := e.colOffset, e.colOffset+width
+	for i, col := 0, 0; i <= len(line) && col < right; {
+		text, size, w := " ", 1, 1
+		switch {
+		case i == len(line):
+			if tags[i] == "" {
+				i++
+				continue
+			}
+		case line[i] == '\t':
+			w = ts - col%ts
+			text = strings.Repeat(" ", w)
+		default:
+			_, size = utf8.DecodeRuneInString(line[i:])
+			text = line[i : i+size]
+		}
+		// Only the visible part of a tab is drawn
+		if w > 1 {
+			from, to := col, col+w
+			if from < left {
+				from = left
+			}
+			if to > right {
+				to = right
+			}
+			text = strings.Repeat(" ", clamp(to-from, 0, w))
+		} else if col < left {
+			text = ""
+		}
+		if text != "" {
+			if tags[i] != tag {
+				flush()
+				tag = tags[i]
+			}
+			run.WriteString(text)
+		}
+		i += size
+		col += w
+	}
+	flush()
+	return b.String()
+}
+
 func (e *Editor) renderStatus() {
 	if e.buffer == nil {
 		return
 	}
-	mode := fmt.Sprintf("[black:white] %s [-:-:-]", strings.ToUpper(string(e.mode)))
+	mode := fmt.Sprintf("%s %s [-:-:-]", e.colorTag("statuscolor"), strings.ToUpper(string(e.mode)))
 	file := e.buffer.BaseName()
 	if e.buffer.Dirty {
 		file += " [+]"
@@ -221,15 +353,7 @@ func (e *Editor) scrollToCursor() {
 
 func (e *Editor) calculateRx() {
 	if e.cy < len(e.buffer.Lines) {
-		e.rx = 0
-		line := e.buffer.Lines[e.cy]
-		for i := 0; i < e.cx; i++ {
-			if i < len(line) && line[i] == '\t' {
-				e.rx += 4 - (e.rx % 4) // Tab stop of 4
-			} else {
-				e.rx++
-			}
-		}
+		e.rx = displayCol(e.buffer.Lines[e.cy], e.cx, e.intOpt("tabstop"))
 	}
 }
 
@@ -256,97 +380,53 @@ func (e *Editor) globalInput(event *tcell.EventKey) *tcell.EventKey {
 		return event
 	}
 
-	// Global commands that work in any mode
-	switch event.Key() {
-	case tcell.KeyCtrlA, tcell.KeyF2, tcell.KeyCtrlG:
-		Log("Chat toggle key pressed")
-		e.toggleChat()
-		return nil
-	case tcell.KeyCtrlS:
-		e.Save()
-		return nil
-	case tcell.KeyCtrlZ:
-		e.undo()
-		return nil
-	case tcell.KeyCtrlY:
-		e.redo()
-		return nil
-	case tcell.KeyCtrlC:
-		// Copy last AI response
-		e.copyLastAIResponse()
+	// Ctrl-C stops a running shell command, whatever it is mapped to.
+	if event.Key() == tcell.KeyCtrlC && e.shellCancel != nil {
+		e.shellCancel()
 		return nil
 	}
 
-	// Mode-specific handling
-	switch e.mode {
-	case ModeNormal:
-		return e.normalModeInput(event)
-	case ModeInsert:
-		return e.insertModeInput(event)
-	}
-
+	// Every other key goes through the key mappings, which also hold the
+	// global bindings such as Ctrl-A for the chat.
+	e.feedKey(event)
 	e.render()
-	return event
-}
-
-func (e *Editor) normalModeInput(event *tcell.EventKey) *tcell.EventKey {
-	switch event.Rune() {
-	case 'i':
-		e.mode = ModeInsert
-	case ':':
-		e.mode = ModeCommand
-		e.commandInput.SetText(":")
-		e.app.SetFocus(e.commandInput)
-	case '/':
-		e.mode = ModeSearch
-		e.commandInput.SetText("/")
-		e.app.SetFocus(e.commandInput)
-	case 'h':
-		e.moveCursor(-1)
-	case 'l':
-		e.moveCursor(1)
-	case 'k':
-		e.moveVertical(-1)
-	case 'j':
-		e.moveVertical(1)
-	case 'w':
-		e.moveWord(1)
-	case 'b':
-		e.moveWord(-1)
-	case 'g':
-		if e.lastKey == "g" {
-			e.cy = 0
-			e.cx = 0
-			e.lastKey = "" // Reset
-		} else {
-			e.lastKey = "g"
-		}
-	case 'G':
-		e.cy = len(e.buffer.Lines) - 1
-		e.cx = 0
-	case 'u':
-		e.undo()
-	case 'x':
-		e.deleteChar()
-	default:
-		if e.lastKey == "g" && event.Rune() != 'g' {
-			e.lastKey = ""
-		}
+	return nil
+}
+
+// insertModeKey records a key as part of the current insert or replace
+// session and applies it.
+func (e *Editor) insertModeKey(event *tcell.EventKey) {
+	e.cmdKeys = append(e.cmdKeys, event)
+	if event.Key() == tcell.KeyEsc {
+		e.stopInsert()
+		return
 	}
-	e.render()
-	return nil // Consume the event
+	e.sessionKey(event)
+	e.updateWantCol()
 }
 
-func (e *Editor) insertModeInput(event *tcell.EventKey) *tcell.EventKey {
+// insertKey applies a single insert-mode key to the buffer.
+func (e *Editor) insertKey(event *tcell.EventKey) {
 	switch event.Key() {
-	case tcell.KeyEsc:
-		e.mode = ModeNormal
 	case tcell.KeyEnter:
 		e.insertNewline()
+		return
+	case tcell.KeyCtrlT:
+		e.shiftInsertLine(1)
+		return
+	case tcell.KeyCtrlD:
+		e.shiftInsertLine(-1)
+		return
+	}
+
+	e.autoIndented = false
+	switch event.Key() {
 	case tcell.KeyBackspace, tcell.KeyBackspace2:
-		e.backspace()
+		if !e.boolOpt("autopairs") || !e.deletePair() {
+			e.backspace()
+		}
 	case tcell.KeyRune:
-		e.insertRune(event.Rune())
+		e.typeRune(event.Rune())
 	case tcell.KeyLeft:
 		e.moveCursor(-1)
 	case tcell.KeyRight:
@@ -359,25 +439,29 @@ func (e *Editor) insertModeInput(event *tcell.EventKey) *tcell.EventKey {
 		// Paste clipboard contents at cursor position
 		if e.clipboard != "" {
 			e.insertString(e.clipboard)
-			e.statusMsg = "Text pasted from AI response"
+			e.statusMsg = "Text pasted from clipboard"
 		}
 	}
-	e.render()
-	return nil // Consume the event
 }
 
 func (e *Editor) commandInputHandler(key tcell.Key) {
+	e.browse = nil
+	e.endCompletion()
+	if key == tcell.KeyEnter || key == tcell.KeyEsc {
+		e.endIncSearch()
+	}
 	if key == tcell.KeyEnter {
 		cmdText := e.commandInput.GetText()
 		e.commandInput.SetText("")
 		e.app.SetFocus(e.mainView)
+		e.addHistory(cmdText)
 
 		if strings.HasPrefix(cmdText, ":") {
-			e.exec(strings.TrimPrefix(cmdText, ":"))
 			e.mode = ModeNormal
-		} else if strings.HasPrefix(cmdText, "/") {
-			e.search(strings.TrimPrefix(cmdText, "/"))
+			e.exec(strings.TrimPrefix(cmdText, ":"))
+		} else if strings.HasPrefix(cmdText, "/") || strings.HasPrefix(cmdText, "?") {
 			e.mode = ModeNormal
+			e.search(cmdText[1:], cmdText[0] == '?')
 		}
 	} else if key == tcell.KeyEsc {
 		e.commandInput.SetText("")
@@ -401,65 +485,35 @@ func (e *Editor) chatInputHandler(key tcell.Key) {
 // --- Commands and Actions ---
 
 func (e *Editor) exec(cmd string) {
-	parts := strings.Split(cmd, " ")
-	switch parts[0] {
-	case "q":
-		if e.buffer.Dirty {
-			e.statusMsg = "No write since last change (use q! to override)"
-			return
-		}
-		e.app.Stop()
-	case "q!":
-		e.app.Stop()
-	case "w":
-		e.Save()
-	case "wq":
-		e.Save()
-		if !e.buffer.Dirty {
-			e.app.Stop()
-		}
-	case "chat":
-		e.toggleChat()
-	case "debugkeys":
-		e.debugKeys = !e.debugKeys
-		if e.debugKeys {
-			e.statusMsg = "Key debugging enabled"
-		} else {
-			e.statusMsg = "Key debugging disabled"
-		}
-	case "copy":
-		// Copy AI response by number: :copy 2
-		if len(parts) < 2 {
-			e.statusMsg = "Usage: copy <response-number>"
-			return
-		}
-		num, err := strconv.Atoi(parts[1])
-		if err != nil || num <= 0 {
-			e.statusMsg = "Invalid response number"
-			return
-		}
-		e.copyResponseByNumber(num)
-	default:
-		if lineNum, err := strconv.Atoi(parts[0]); err == nil {
-			if lineNum > 0 && lineNum <= len(e.buffer.Lines) {
-				e.cy = lineNum - 1
-				e.cx = 0
-			} else {
-				e.statusMsg = "Invalid line number"
-			}
-		} else {
-			e.statusMsg = fmt.Sprintf("Unknown command: %s", cmd)
-		}
+	if err := e.runEx(cmd); err != nil {
+		e.statusMsg = err.Error()
 	}
 }
 
-func (e *Editor) search(query string) {
-	e.searchQuery = query
-	e.searchResults = [][2]int{}
-	if query == "" {
-		return
+// confirm asks a question in the status bar that is answered by the next
+// key, whose rune is passed to answer. Esc is passed as 'q' and other
+// special keys as 0.
+func (e *Editor) confirm(question string, answer func(r rune)) {
+	e.mode = ModeConfirm
+	e.confirmFn = answer
+	e.statusMsg = question
+}
+
+// confirmKey answers the question asked by confirm.
+func (e *Editor) confirmKey(event *tcell.EventKey) {
+	answer := e.confirmFn
+	e.confirmFn = nil
+	e.mode = ModeNormal
+	var r rune
+	switch event.Key() {
+	case tcell.KeyRune:
+		r = event.Rune()
+	case tcell.KeyEsc:
+		r = 'q'
+	}
+	if answer != nil {
+		answer(r)
 	}
-	e.statusMsg = fmt.Sprintf("Search for '%s' (highlighted)", query)
 }
 
 func (e *Editor) toggleChat() {
@@ -484,12 +538,14 @@ func (e *Editor) sendChat(userInput string) {
 	e.chatHistory = append(e.chatHistory, ChatMessage{Role: "model", Content: "..."})
 	e.refreshChatView()
 
+	keyVar := e.stringOpt("aikeyvar")
+	apiKey := os.Getenv(keyVar)
+	url := geminiURL(e.stringOpt("aiendpoint"), e.stringOpt("aimodel"), apiKey)
 	go func(history []ChatMessage, prompt string) {
-		apiKey := os.Getenv("GEMINI_API_KEY")
 		if apiKey == "" {
 			e.app.QueueUpdateDraw(func() {
 				// Replace thinking message with error
-				e.chatHistory[len(e.chatHistory)-1] = ChatMessage{Role: "model", Content: "Error: GEMINI_API_KEY environment variable not set"}
+				e.chatHistory[len(e.chatHistory)-1] = ChatMessage{Role: "model", Content: "Error: " + keyVar + " environment variable not set"}
 				e.refreshChatView()
 			})
 			return
@@ -497,7 +553,7 @@ func (e *Editor) sendChat(userInput string) {
 
 		// Call the API
 		ctx := context.Background()
-		response, err := callGemini(ctx, apiKey, history[:len(history)-1], prompt)
+		response, err := callGemini(ctx, url, history[:len(history)-1], prompt)
 
 		e.app.QueueUpdateDraw(func() {
 			if err != nil {
@@ -585,34 +641,30 @@ func (e *Editor) copyResponseByNumber(num int) {
 }
 
 func (e *Editor) insertString(s string) {
-	// Insert each line of the string at the current cursor position
-	lines := strings.Split(s, "\n")
-	if len(lines) == 0 {
-		return
-	}
-
 	e.pushUndo()
-
-	// Insert first line at cursor position
-	line := e.buffer.Lines[e.cy]
-	if e.cx > len(line) {
-		e.cx = len(line)
+	if e.cx > len(e.buffer.Lines[e.cy]) {
+		e.cx = len(e.buffer.Lines[e.cy])
 	}
-	e.buffer.Lines[e.cy] = line[:e.cx] + lines[0] + line[e.cx:]
-	e.cx += len(lines[0])
-
-	// If we have more than one line, insert the rest as new lines
-	if len(lines) > 1 {
-		for _, l := range lines[1:] {
-			// Create a new line
-			e.cy++
-			// Insert the rest of the lines as new lines
-			e.buffer.Lines = append(e.buffer.Lines[:e.cy], append([]string{l}, e.buffer.Lines[e.cy:]...)...)
-			e.cx = len(l)
-		}
+	end := e.insertText(pos{e.cy, e.cx}, s)
+	e.cy, e.cx = end.line, end.col
+}
+
+// insertText inserts s, which may span several lines, at p and returns the
+// position just after the inserted text.
+func (e *Editor) insertText(p pos, s string) pos {
+	lines := strings.Split(s, "\n")
+	line := e.buffer.Lines[p.line]
+	if len(lines) == 1 {
+		e.buffer.SetLine(p.line, line[:p.col]+s+line[p.col:])
+		return pos{p.line, p.col + len(s)}
 	}
 
-	e.buffer.Dirty = true
+	// The text after p moves to the end of the last inserted line
+	last := lines[len(lines)-1]
+	e.buffer.SetLine(p.line, line[:p.col]+lines[0])
+	rest := append(append([]string(nil), lines[1:len(lines)-1]...), last+line[p.col:])
+	e.buffer.InsertLines(p.line+1, rest...)
+	return pos{p.line + len(lines) - 1, len(last)}
 }
 
 // --- Editing Operations ---
@@ -623,9 +675,8 @@ func (e *Editor) insertRune(r rune) {
 	if e.cx > len(line) {
 		e.cx = len(line)
 	}
-	e.buffer.Lines[e.cy] = line[:e.cx] + string(r) + line[e.cx:]
-	e.cx++
-	e.buffer.Dirty = true
+	e.buffer.SetLine(e.cy, line[:e.cx]+string(r)+line[e.cx:])
+	e.cx += len(string(r))
 }
 
 func (e *Editor) insertNewline() {
@@ -634,13 +685,27 @@ func (e *Editor) insertNewline() {
 	if e.cx > len(line) {
 		e.cx = len(line)
 	}
-	remaining := line[e.cx:]
-	e.buffer.Lines[e.cy] = line[:e.cx]
-
-	e.buffer.Lines = append(e.buffer.Lines[:e.cy+1], append([]string{remaining}, e.buffer.Lines[e.cy+1:]...)...)
+	// An untouched automatic indent is removed but still carries over.
+	indent := e.newLineIndent(line[:e.cx])
+	e.clearAutoIndent()
+	line = e.buffer.Lines[e.cy]
+	before, after := line[:e.cx], line[e.cx:]
+	if indent != "" {
+		after = strings.TrimLeft(after, " \t")
+	}
+	e.buffer.SetLine(e.cy, before)
+
+	// Between a pair of braces, the closer goes on its own line below the
+	// cursor, lined up with the opener.
+	trimmed := strings.TrimRight(before, " \t")
+	if e.smartIndenting() && e.opensBlock(trimmed) && after != "" && bracketPairs[trimmed[len(trimmed)-1]] == after[0] {
+		e.buffer.InsertLines(e.cy+1, indent, leadingWhitespace(before)+after)
+	} else {
+		e.buffer.InsertLines(e.cy+1, indent+after)
+	}
 	e.cy++
-	e.cx = 0
-	e.buffer.Dirty = true
+	e.cx = len(indent)
+	e.autoIndented = indent != ""
 }
 
 func (e *Editor) backspace() {
@@ -654,165 +719,109 @@ func (e *Editor) backspace() {
 		if e.cx > len(line) {
 			e.cx = len(line)
 		}
-		e.buffer.Lines[e.cy] = line[:e.cx-1] + line[e.cx:]
+		e.buffer.SetLine(e.cy, line[:e.cx-1]+line[e.cx:])
 		e.cx--
-		e.buffer.Dirty = true
 	} else {
 		prevLine := e.buffer.Lines[e.cy-1]
 		e.cx = len(prevLine)
-		e.buffer.Lines[e.cy-1] = prevLine + e.buffer.Lines[e.cy]
-		e.buffer.Lines = append(e.buffer.Lines[:e.cy], e.buffer.Lines[e.cy+1:]...)
+		e.buffer.SetLine(e.cy-1, prevLine+e.buffer.Lines[e.cy])
+		e.buffer.DeleteLines(e.cy, e.cy+1)
 		e.cy--
-		e.buffer.Dirty = true
 	}
 }
 
-func (e *Editor) deleteChar() {
-	if e.cy >= len(e.buffer.Lines) {
-		return
-	}
-	line := e.buffer.Lines[e.cy]
-	if e.cx >= len(line) {
-		return
-	}
-	e.pushUndo()
-	e.buffer.Lines[e.cy] = line[:e.cx] + line[e.cx+1:]
-	e.buffer.Dirty = true
-}
-
 // --- Cursor Movement ---
 
 func (e *Editor) moveCursor(delta int) {
 	if e.cy >= len(e.buffer.Lines) {
 		return
 	}
-	newPos := e.cx + delta
-	if newPos >= 0 && newPos <= len(e.buffer.Lines[e.cy]) {
-		e.cx = newPos
-	}
+	e.cx = clamp(e.cx+delta, 0, len(e.buffer.Lines[e.cy]))
 }
 
 func (e *Editor) moveVertical(delta int) {
-	newY := e.cy + delta
-	if newY >= 0 && newY < len(e.buffer.Lines) {
-		e.cy = newY
-		if e.cx > len(e.buffer.Lines[e.cy]) {
-			e.cx = len(e.buffer.Lines[e.cy])
-		}
+	if p, ok := e.verticalTarget(delta); ok {
+		e.cy, e.cx = p.line, p.col
 	}
 }
 
-func (e *Editor) moveWord(dir int) {
-	// Implementation omitted for brevity
-}
-
-// --- Undo/Redo ---
-
-func (e *Editor) pushUndo() {
-	e.undoMutex.Lock()
-	defer e.undoMutex.Unlock()
-
-	// Create a snapshot
-	snapshot := make([]string, len(e.buffer.Lines))
-	copy(snapshot, e.buffer.Lines)
+// --- File Operations ---
 
-	e.undoStack = append(e.undoStack, snapshot)
-	// If we have more than 100 undo states, trim the oldest one
-	if len(e.undoStack) > 100 {
-		e.undoStack = e.undoStack[1:]
+// writeBuffer saves the current buffer to its file.
+func (e *Editor) writeBuffer() error {
+	if err := e.checkSecure(); err != nil {
+		return err
 	}
-	// Any new action clears the redo stack
-	e.redoStack = nil
-}
-
-func (e *Editor) undo() {
-	e.undoMutex.Lock()
-	defer e.undoMutex.Unlock()
-
-	if len(e.undoStack) == 0 {
-		return
+	if e.buffer.FilePath == "" {
+		return fmt.Errorf("No file name (use :w <path>)")
 	}
-
-	// Pop from undo stack
-	lastState := e.undoStack[len(e.undoStack)-1]
-	e.undoStack = e.undoStack[:len(e.undoStack)-1]
-
-	// Push current state to redo stack
-	currentSnapshot := make([]string, len(e.buffer.Lines))
-	copy(currentSnapshot, e.buffer.Lines)
-	e.redoStack = append(e.redoStack, currentSnapshot)
-
-	// Restore buffer
-	e.buffer.Lines = lastState
-	e.buffer.Dirty = true
-	e.recomputeLineStarts()
-	// TODO: Restore cursor position?
-}
-
-func (e *Editor) redo() {
-	e.undoMutex.Lock()
-	defer e.undoMutex.Unlock()
-
-	if len(e.redoStack) == 0 {
-		return
+	if err := e.buffer.Save(); err != nil {
+		return fmt.Errorf("Error saving file: %v", err)
 	}
-
-	// Pop from redo stack
-	nextState := e.redoStack[len(e.redoStack)-1]
-	e.redoStack = e.redoStack[:len(e.redoStack)-1]
-
-	// Push current state to undo stack
-	currentSnapshot := make([]string, len(e.buffer.Lines))
-	copy(currentSnapshot, e.buffer.Lines)
-	e.undoStack = append(e.undoStack, currentSnapshot)
-
-	// Restore buffer
-	e.buffer.Lines = nextState
-	e.buffer.Dirty = true
-	e.recomputeLineStarts()
+	if err := e.saveUndo(); err != nil {
+		Log(fmt.Sprintf("Error saving undo history: %v", err))
+	}
+	e.statusMsg = fmt.Sprintf("File '%s' saved", e.buffer.BaseName())
+	return nil
 }
 
-// --- File Operations ---
-
-func (e *Editor) Save() {
-	if err := e.buffer.Save(); err != nil {
-		e.statusMsg = fmt.Sprintf("Error saving file: %v", err)
-	} else {
-		e.statusMsg = fmt.Sprintf("File '%s' saved", e.buffer.BaseName())
+// findBuffer returns the open buffer editing path, or nil.
+func (e *Editor) findBuffer(path string) *Buffer {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil
 	}
+	for _, b := range e.buffers {
+		if b.FilePath == "" {
+			continue
+		}
+		if babs, err := filepath.Abs(b.FilePath); err == nil && babs == abs {
+			return b
+		}
+	}
+	return nil
 }
 
 func (e *Editor) openFile(path string) {
+	if b := e.findBuffer(path); b != nil {
+		e.switchBuffer(b)
+		return
+	}
 	b, err := NewBuffer(path)
 	if err != nil {
 		e.statusMsg = fmt.Sprintf("Error opening file: %v", err)
 		return
 	}
-	e.buffer = b
-	e.cy, e.cx = 0, 0
-	e.rowOffset, e.colOffset = 0, 0
-	e.undoStack = make([][]string, 0)
-	e.redoStack = make([][]string, 0)
+	e.switchBuffer(b)
 }
 
 // --- Highlighting and Utility ---
 
-func highlightLineGo(line string) string {
-	keywords := map[string]bool{
-		"func": true, "var": true, "const": true, "type": true, "struct": true,
-		"interface": true, "package": true, "import": true, "return": true,
-		"go": true, "defer": true, "for": true, "range": true, "if": true, "else": true,
-		"switch": true, "case": true, "default": true, "map": true, "chan": true,
-	}
-	parts := strings.FieldsFunc(line, func(r rune) bool {
-		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
-	})
-	for _, part := range parts {
-		if keywords[part] {
-			line = strings.ReplaceAll(line, part, fmt.Sprintf("[blue]%s[-]", part))
+// goKeywords are the words highlighted with keywordcolor.
+var goKeywords = map[string]bool{
+	"func": true, "var": true, "const": true, "type": true, "struct": true,
+	"interface": true, "package": true, "import": true, "return": true,
+	"go": true, "defer": true, "for": true, "range": true, "if": true, "else": true,
+	"switch": true, "case": true, "default": true, "map": true, "chan": true,
+}
+
+// highlightGo sets tag for the bytes of the keywords in line.
+func highlightGo(line string, tags []string, tag string) {
+	start := -1
+	for i, r := range line + " " {
+		if unicode.IsLetter(r) || unicode.IsNumber(r) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		if start >= 0 && goKeywords[line[start:i]] {
+			for j := start; j < i; j++ {
+				tags[j] = tag
+			}
 		}
+		start = -1
 	}
-	return line
 }
 
 func eventToKeyString(ev *tcell.EventKey) string {
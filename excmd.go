@@ -0,0 +1,480 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// --- Ex Commands ---
+
+// Default ranges for commands given without one.
+const (
+	rangeNone = iota // The command takes no range
+	rangeLine        // Defaults to the cursor line
+	rangeFile        // Defaults to the whole buffer
+)
+
+// exCommand describes a command that can be run from the : prompt.
+type exCommand struct {
+	name     string // Full name
+	abbrev   string // Shortest accepted abbreviation, a prefix of name
+	rng      int    // rangeNone, rangeLine or rangeFile
+	bang     bool   // Accepts a ! after the name
+	zero     bool   // Accepts line 0, before the first line, as in :0r
+	complete func(e *Editor, arg string) []string
+	run      func(e *Editor, c *exCall) error
+}
+
+// exCall is a parsed command line. Lines are 0-based and inclusive.
+type exCall struct {
+	cmd          *exCommand
+	line1, line2 int
+	addrs        int // Number of addresses given
+	bang         bool
+	arg          string // Text after the name, with leading blanks removed
+}
+
+var exCommands = map[string]*exCommand{}
+
+// registerEx adds commands to the registry.
+func registerEx(cmds ...*exCommand) {
+	for _, c := range cmds {
+		if c.abbrev == "" {
+			c.abbrev = c.name
+		}
+		exCommands[c.name] = c
+	}
+}
+
+// lookupEx finds a command by its name or an abbreviation of it.
+func lookupEx(name string) *exCommand {
+	if c, ok := exCommands[name]; ok {
+		return c
+	}
+	var names []string
+	for n := range exCommands {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		c := exCommands[n]
+		if strings.HasPrefix(n, name) && len(name) >= len(c.abbrev) {
+			return c
+		}
+	}
+	return nil
+}
+
+func init() {
+	registerEx(
+		&exCommand{name: "quit", abbrev: "q", bang: true, run: exQuit},
+		&exCommand{name: "chat", run: func(e *Editor, c *exCall) error {
+			e.toggleChat()
+			return nil
+		}},
+		&exCommand{name: "copy", complete: completeResponses, run: exCopyResponse},
+		&exCommand{name: "undo", abbrev: "u", run: func(e *Editor, c *exCall) error {
+			e.undo()
+			return nil
+		}},
+		&exCommand{name: "redo", abbrev: "red", run: func(e *Editor, c *exCall) error {
+			e.redo()
+			return nil
+		}},
+		&exCommand{name: "debugkeys", run: func(e *Editor, c *exCall) error {
+			e.debugKeys = !e.debugKeys
+			if e.debugKeys {
+				e.statusMsg = "Key debugging enabled"
+			} else {
+				e.statusMsg = "Key debugging disabled"
+			}
+			return nil
+		}},
+	)
+}
+
+// runEx parses and runs a command line.
+func (e *Editor) runEx(line string) error {
+	c, err := e.parseEx(line)
+	if err != nil || c == nil {
+		return err
+	}
+	if c.cmd == nil {
+		// A range on its own moves to its last line.
+		e.setJump()
+		e.cy = c.line2
+		e.cx = firstNonBlank(e.buffer.Lines[e.cy])
+		return nil
+	}
+	return c.cmd.run(e, c)
+}
+
+// parseEx splits a command line into its range, command, bang and argument.
+// It returns nil for an empty line.
+func (e *Editor) parseEx(line string) (*exCall, error) {
+	s := strings.TrimLeft(line, " \t:")
+	if s == "" {
+		return nil, nil
+	}
+	c := &exCall{line1: e.cy, line2: e.cy}
+	s, err := e.parseRange(s, c)
+	if err != nil {
+		return nil, err
+	}
+	s = strings.TrimLeft(s, " \t")
+
+	if s == "" {
+		if c.addrs == 0 {
+			return nil, nil
+		}
+		if c.line2 == -1 {
+			c.line2 = 0 // :0 goes to the first line
+		}
+		if c.line2 < 0 || c.line2 >= len(e.buffer.Lines) {
+			return nil, fmt.Errorf("Invalid line number")
+		}
+		return c, nil
+	}
+
+	n := 0
+	for n < len(s) && isExNameChar(s[n]) {
+		n++
+	}
+	if n == 0 {
+		n = 1 // Single-character commands such as ! and &
+	}
+	name := s[:n]
+	s = s[n:]
+	c.cmd = lookupEx(name)
+	if c.cmd == nil {
+		return nil, fmt.Errorf("Unknown command: %s", strings.TrimSpace(line))
+	}
+	if c.cmd.bang && strings.HasPrefix(s, "!") {
+		c.bang = true
+		s = s[1:]
+	}
+	c.arg = strings.TrimLeft(s, " \t")
+
+	switch {
+	case c.addrs > 0 && c.cmd.rng == rangeNone:
+		return nil, fmt.Errorf("No range allowed")
+	case c.addrs == 0 && c.cmd.rng == rangeFile:
+		c.line1, c.line2 = 0, len(e.buffer.Lines)-1
+	}
+	if c.line1 < 0 && !(c.cmd.zero && c.line1 == -1) || c.line2 >= len(e.buffer.Lines) {
+		return nil, fmt.Errorf("Invalid range")
+	}
+	return c, nil
+}
+
+// isExNameChar reports whether b can be part of a command name.
+func isExNameChar(b byte) bool {
+	return b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z'
+}
+
+// parseRange parses the range at the start of s into c and returns the rest.
+// A backwards range is swapped.
+func (e *Editor) parseRange(s string, c *exCall) (string, error) {
+	if strings.HasPrefix(s, "%") {
+		c.line1, c.line2, c.addrs = 0, len(e.buffer.Lines)-1, 2
+		return s[1:], nil
+	}
+	cur := e.cy
+	afterSep := false
+	for {
+		line, rest, ok, err := e.parseAddress(s, cur)
+		if err != nil {
+			return s, err
+		}
+		sep := rest != "" && (rest[0] == ',' || rest[0] == ';')
+		if !ok {
+			if !sep && !afterSep {
+				break
+			}
+			line = cur // A missing address next to a separator is the cursor line
+		}
+		c.line1, c.line2 = c.line2, line
+		c.addrs++
+		s = rest
+		if !sep {
+			break
+		}
+		if s[0] == ';' {
+			cur = line
+		}
+		s = s[1:]
+		afterSep = true
+	}
+	if c.addrs == 1 {
+		c.line1 = c.line2
+	}
+	if c.line1 > c.line2 {
+		c.line1, c.line2 = c.line2, c.line1
+	}
+	return s, nil
+}
+
+// parseAddress parses one line address with any offsets, relative to line
+// cur. ok is false if s does not start with an address.
+func (e *Editor) parseAddress(s string, cur int) (line int, rest string, ok bool, err error) {
+	last := len(e.buffer.Lines) - 1
+	switch {
+	case s == "":
+		return 0, s, false, nil
+	case s[0] >= '0' && s[0] <= '9':
+		n, rest := leadingNumber(s)
+		line, s = n-1, rest
+	case s[0] == '.':
+		line, s = cur, s[1:]
+	case s[0] == '$':
+		line, s = last, s[1:]
+	case s[0] == '\'':
+		if len(s) < 2 {
+			return 0, s, false, fmt.Errorf("Invalid range")
+		}
+		b, m, err := e.markPosition(rune(s[1]))
+		if err != nil {
+			return 0, s, false, err
+		}
+		if b != e.buffer {
+			return 0, s, false, fmt.Errorf("Mark not in this file: %c", s[1])
+		}
+		line, s = m.line, s[2:]
+	case s[0] == '/' || s[0] == '?':
+		pat, rest, _ := splitPattern(s[1:], s[0])
+		line, err = e.findLine(pat, cur, s[0] == '/')
+		if err != nil {
+			return 0, s, false, err
+		}
+		s = rest
+	case s[0] == '+' || s[0] == '-':
+		line = cur
+	default:
+		return 0, s, false, nil
+	}
+
+	for len(s) > 0 && (s[0] == '+' || s[0] == '-') {
+		sign := 1
+		if s[0] == '-' {
+			sign = -1
+		}
+		n, rest := leadingNumber(s[1:])
+		if rest == s[1:] {
+			n = 1
+		}
+		line += sign * n
+		s = rest
+	}
+	return line, s, true, nil
+}
+
+// leadingNumber parses the decimal number at the start of s and returns the
+// rest. It returns 0 and s unchanged if s does not start with a digit.
+func leadingNumber(s string) (int, string) {
+	n := 0
+	for n < len(s) && s[n] >= '0' && s[n] <= '9' {
+		n++
+	}
+	v, _ := strconv.Atoi(s[:n])
+	return v, s[n:]
+}
+
+// validDelim reports whether b can delimit a pattern, as in s/a/b/ or s#a#b#.
+func validDelim(b byte) bool {
+	return !isExNameChar(b) && !(b >= '0' && b <= '9') && b != '\\' && b != '"' && b != ' ' && b != '\t'
+}
+
+// splitPattern splits s at the first unescaped delim. An escaped delimiter
+// in the pattern is unescaped. closed reports whether the delimiter was found.
+func splitPattern(s string, delim byte) (pat, rest string, closed bool) {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && i+1 < len(s) && s[i+1] == delim:
+			b.WriteByte(delim)
+			i++
+		case s[i] == '\\' && i+1 < len(s):
+			b.WriteString(s[i : i+2])
+			i++
+		case s[i] == delim:
+			return b.String(), s[i+1:], true
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String(), "", false
+}
+
+// findLine returns the next line after from that matches pat, searching
+// backwards if forward is false and wrapping around the buffer. An empty
+// pattern repeats the last search.
+func (e *Editor) findLine(pat string, from int, forward bool) (int, error) {
+	if pat == "" {
+		pat = e.searchQuery
+	}
+	if pat == "" {
+		return 0, fmt.Errorf("No previous search pattern")
+	}
+	re, err := e.compilePattern(pat)
+	if err != nil {
+		return 0, err
+	}
+	e.searchQuery = pat
+	n := len(e.buffer.Lines)
+	step := 1
+	if !forward {
+		step = n - 1
+	}
+	for i, y := 0, (from+step)%n; i < n; i, y = i+1, (y+step)%n {
+		if re.MatchString(e.buffer.Lines[y]) {
+			return y, nil
+		}
+	}
+	return 0, fmt.Errorf("Pattern not found: %s", pat)
+}
+
+// splitArgs splits a command argument at blanks. Double or single quotes
+// group words, and a backslash escapes the next character outside single
+// quotes.
+func splitArgs(s string) ([]string, error) {
+	var args []string
+	var cur strings.Builder
+	inArg := false
+	var quote rune
+	escaped := false
+	for _, r := range s {
+		switch {
+		case escaped:
+			cur.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped, inArg = true, true
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote != 0:
+			cur.WriteRune(r)
+		case r == '"' || r == '\'':
+			quote, inArg = r, true
+		case unicode.IsSpace(r):
+			if inArg {
+				args = append(args, cur.String())
+				cur.Reset()
+				inArg = false
+			}
+		default:
+			cur.WriteRune(r)
+			inArg = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("Unterminated quote")
+	}
+	if inArg {
+		args = append(args, cur.String())
+	}
+	return args, nil
+}
+
+// expandPath expands a leading ~ in a path to the home directory.
+func expandPath(path string) string {
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			return home + path[1:]
+		}
+	}
+	return path
+}
+
+// pathArg returns the single file name in a command argument, or "" if
+// there is none.
+func pathArg(arg string) (string, error) {
+	args, err := splitArgs(arg)
+	if err != nil {
+		return "", err
+	}
+	switch len(args) {
+	case 0:
+		return "", nil
+	case 1:
+		return expandPath(args[0]), nil
+	}
+	return "", fmt.Errorf("Only one file name allowed")
+}
+
+// completeFiles returns the paths that complete arg.
+func completeFiles(e *Editor, arg string) []string {
+	matches, _ := filepath.Glob(expandPath(arg) + "*")
+	for i, m := range matches {
+		if fi, err := os.Stat(m); err == nil && fi.IsDir() {
+			matches[i] = m + string(filepath.Separator)
+		}
+	}
+	return matches
+}
+
+// completeResponses returns the numbers of the AI responses that complete arg.
+func completeResponses(e *Editor, arg string) []string {
+	var nums []string
+	n := 0
+	for _, m := range e.chatHistory {
+		if m.Role == "model" && m.Content != "..." {
+			n++
+			if s := strconv.Itoa(n); strings.HasPrefix(s, arg) {
+				nums = append(nums, s)
+			}
+		}
+	}
+	return nums
+}
+
+func exQuit(e *Editor, c *exCall) error {
+	if !c.bang {
+		if err := e.checkUnsaved(); err != nil {
+			return err
+		}
+	}
+	e.app.Stop()
+	return nil
+}
+
+// checkUnsaved returns an error naming the current buffer, or else the
+// first other buffer, with unsaved changes.
+func (e *Editor) checkUnsaved() error {
+	if e.buffer.Dirty {
+		return fmt.Errorf("No write since last change (add ! to override)")
+	}
+	for _, b := range e.buffers {
+		if b.Dirty {
+			return fmt.Errorf("No write since last change for buffer \"%s\" (add ! to override)", b.BaseName())
+		}
+	}
+	return nil
+}
+
+// exCopyResponse copies an AI response by number, or the last one without
+// a number: :copy 2
+func exCopyResponse(e *Editor, c *exCall) error {
+	args, err := splitArgs(c.arg)
+	if err != nil {
+		return err
+	}
+	switch len(args) {
+	case 0:
+		e.copyLastAIResponse()
+		return nil
+	case 1:
+	default:
+		return fmt.Errorf("Usage: copy [response-number]")
+	}
+	num, err := strconv.Atoi(args[0])
+	if err != nil || num <= 0 {
+		return fmt.Errorf("Invalid response number")
+	}
+	e.copyResponseByNumber(num)
+	return nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseExRanges(t *testing.T) {
+	e := newTestEditor("alpha", "beta", "gamma", "delta", "epsilon")
+	typeKeys(e, "jmajjmbk") // a on line 1, b on line 3, cursor on line 2
+	tests := []struct {
+		cmd          string
+		line1, line2 int
+		addrs        int
+	}{
+		{"chat", 2, 2, 0},
+		{"%chat", 0, 4, 2},
+		{"2,4chat", 1, 3, 2},
+		{".,$chat", 2, 4, 2},
+		{".-1,.+1chat", 1, 3, 2},
+		{"'a,'bchat", 1, 3, 2},
+		{"4,2chat", 1, 3, 2},
+		{"/del/chat", 3, 3, 1},
+		{"?alp?,/eps/chat", 0, 4, 2},
+		{"2;+2chat", 1, 3, 2},
+		{",$chat", 2, 4, 2},
+		{"$-chat", 3, 3, 1},
+	}
+	for _, tt := range tests {
+		// Accept a range for the test without running anything.
+		exCommands["chat"].rng = rangeLine
+		c, err := e.parseEx(tt.cmd)
+		exCommands["chat"].rng = rangeNone
+		if err != nil {
+			t.Errorf("%q: %v", tt.cmd, err)
+			continue
+		}
+		if c.line1 != tt.line1 || c.line2 != tt.line2 || c.addrs != tt.addrs {
+			t.Errorf("%q: got %d,%d (%d addrs), want %d,%d (%d addrs)",
+				tt.cmd, c.line1, c.line2, c.addrs, tt.line1, tt.line2, tt.addrs)
+		}
+	}
+}
+
+func TestParseExErrors(t *testing.T) {
+	e := newTestEditor("one", "two")
+	for _, cmd := range []string{"1,9chat", "2chat", "'zchat", "/nope/", "frobnicate", "copy 1 2"} {
+		if err := e.runEx(cmd); err == nil {
+			t.Errorf("%q: expected an error", cmd)
+		}
+	}
+}
+
+func TestExNamesAndBang(t *testing.T) {
+	e := newTestEditor("x")
+	for _, name := range []string{"q", "qu", "quit"} {
+		c, err := e.parseEx(name + "!")
+		if err != nil || c.cmd.name != "quit" || !c.bang {
+			t.Errorf("%q!: got %+v, %v", name, c, err)
+		}
+	}
+	if c, err := e.parseEx("w foo"); err != nil || c.cmd.name != "write" || c.arg != "foo" {
+		t.Errorf("w foo: got %+v, %v", c, err)
+	}
+	if _, err := e.parseEx("quitx"); err == nil {
+		t.Error("quitx should not be a command")
+	}
+}
+
+func TestExGotoLine(t *testing.T) {
+	e := newTestEditor("a", "  b", "c")
+	e.exec("2")
+	if e.cy != 1 || e.cx != 2 {
+		t.Fatalf(":2 moved to %d,%d", e.cy, e.cx)
+	}
+	e.exec("9")
+	if e.cy != 1 || e.statusMsg != "Invalid line number" {
+		t.Fatalf(":9 gave cy=%d status=%q", e.cy, e.statusMsg)
+	}
+	typeKeys(e, "''")
+	if e.cy != 0 {
+		t.Fatalf("'' after :2 should return to line 0, got %d", e.cy)
+	}
+	e.exec("3")
+	e.statusMsg = ""
+	typeKeys(e, ":0<CR>")
+	if e.cy != 0 || e.statusMsg != "" {
+		t.Fatalf(":0 gave cy=%d status=%q", e.cy, e.statusMsg)
+	}
+	e.exec("0d")
+	if len(e.buffer.Lines) != 3 || e.statusMsg != "Invalid range" {
+		t.Fatalf(":0d should need a command that takes line 0: %q status=%q", e.buffer.Lines, e.statusMsg)
+	}
+}
+
+func TestSplitArgs(t *testing.T) {
+	tests := map[string][]string{
+		`a b  c`:            {"a", "b", "c"},
+		`"two words" x`:     {"two words", "x"},
+		`'it''s' a\ b`:      {"its", "a b"},
+		`"say \"hi\"" 'a\'`: {`say "hi"`, `a\`},
+		``:                  nil,
+	}
+	for in, want := range tests {
+		got, err := splitArgs(in)
+		if err != nil || !reflect.DeepEqual(got, want) {
+			t.Errorf("splitArgs(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+	if _, err := splitArgs(`"open`); err == nil {
+		t.Error("unterminated quote should be an error")
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// --- Line Commands ---
+
+func init() {
+	registerEx(
+		&exCommand{name: "delete", abbrev: "d", rng: rangeLine, run: exDelete},
+		&exCommand{name: "move", abbrev: "m", rng: rangeLine, run: exMove},
+		&exCommand{name: "t", rng: rangeLine, run: exCopyLines},
+		&exCommand{name: "normal", abbrev: "norm", rng: rangeLine, bang: true, run: exNormal},
+		&exCommand{name: "global", abbrev: "g", rng: rangeFile, bang: true, run: func(e *Editor, c *exCall) error {
+			return e.global(c, !c.bang)
+		}},
+		&exCommand{name: "vglobal", abbrev: "v", rng: rangeFile, run: func(e *Editor, c *exCall) error {
+			return e.global(c, false)
+		}},
+	)
+}
+
+// countArg applies a trailing count argument, which makes the range start
+// at its last line and cover that many lines.
+func (e *Editor) countArg(c *exCall, arg string) error {
+	arg = strings.TrimSpace(arg)
+	if arg == "" {
+		return nil
+	}
+	n, rest := leadingNumber(arg)
+	if n == 0 || rest != "" {
+		return fmt.Errorf("Trailing characters: %s", arg)
+	}
+	c.line1 = c.line2
+	c.line2 = clamp(c.line2+n-1, 0, len(e.buffer.Lines)-1)
+	return nil
+}
+
+// destination parses the target line of :m and :t. Line -1 stands for
+// address 0, above the first line.
+func (e *Editor) destination(arg string) (int, error) {
+	line, rest, ok, err := e.parseAddress(strings.TrimSpace(arg), e.cy)
+	if err != nil {
+		return 0, err
+	}
+	if !ok || strings.TrimSpace(rest) != "" || line < -1 || line >= len(e.buffer.Lines) {
+		return 0, fmt.Errorf("Invalid address")
+	}
+	return line, nil
+}
+
+// exDelete deletes the lines of the range into the clipboard: :[range]d [count]
+func exDelete(e *Editor, c *exCall) error {
+	if err := e.countArg(c, c.arg); err != nil {
+		return err
+	}
+	opDelete(e, textRange{start: pos{c.line1, 0}, end: pos{c.line2, 0}, linewise: true})
+	if n := c.line2 - c.line1 + 1; n > 2 {
+		e.statusMsg = fmt.Sprintf("%d fewer lines", n)
+	}
+	return nil
+}
+
+// exMove moves the lines of the range below a line: :[range]m {address}
+func exMove(e *Editor, c *exCall) error {
+	dest, err := e.destination(c.arg)
+	if err != nil {
+		return err
+	}
+	if dest >= c.line1 && dest < c.line2 {
+		return fmt.Errorf("Cannot move a range of lines into itself")
+	}
+	n := c.line2 - c.line1 + 1
+	e.pushUndo()
+	e.buffer.MoveLines(c.line1, c.line2+1, dest+1)
+	if dest > c.line2 {
+		e.cy = dest
+	} else {
+		e.cy = dest + n
+	}
+	e.cx = firstNonBlank(e.buffer.Lines[e.cy])
+	return nil
+}
+
+// exCopyLines copies the lines of the range below a line: :[range]t {address}
+func exCopyLines(e *Editor, c *exCall) error {
+	dest, err := e.destination(c.arg)
+	if err != nil {
+		return err
+	}
+	lines := append([]string(nil), e.buffer.Lines[c.line1:c.line2+1]...)
+	e.pushUndo()
+	e.buffer.InsertLines(dest+1, lines...)
+	e.cy = dest + len(lines)
+	e.cx = firstNonBlank(e.buffer.Lines[e.cy])
+	return nil
+}
+
+// exNormal runs normal-mode keys on each line of the range, or once at the
+// cursor without one: :[range]norm[!] {keys}. Mappings apply to the keys
+// unless ! is given. An unfinished insert is ended as if by <Esc>.
+func exNormal(e *Editor, c *exCall) error {
+	if c.arg == "" {
+		return fmt.Errorf("Argument required")
+	}
+	keys := parseKeys(c.arg)
+	run := func() {
+		e.mode = ModeNormal
+		e.runKeys(keys, !c.bang)
+		switch e.mode {
+		case ModeInsert, ModeReplace:
+			e.stopInsert()
+		case ModeConfirm:
+			e.confirmKey(parseKeys("<Esc>")[0])
+		case ModeCommand, ModeSearch:
+			e.commandInputHandler(tcell.KeyEsc)
+		}
+		e.resetNormal()
+		e.mode = ModeNormal
+	}
+
+	e.beginUndoGroup()
+	defer e.endUndoGroup()
+	if c.addrs == 0 {
+		run()
+		return nil
+	}
+	var marks []*mark
+	for y := c.line1; y <= c.line2; y++ {
+		marks = append(marks, e.buffer.anchor(y, 0))
+	}
+	for _, m := range marks {
+		if !m.deleted {
+			e.cy, e.cx = m.line, 0
+			run()
+		}
+		e.buffer.release(m)
+	}
+	return nil
+}
+
+// global runs an ex command on every line in the range that matches a
+// pattern, or that does not match it if match is false:
+// :[range]g/pattern/command. The lines are marked before any command runs,
+// so the commands can add and delete lines, and the whole run undoes as
+// one step.
+func (e *Editor) global(c *exCall, match bool) error {
+	if e.inGlobal {
+		return fmt.Errorf("Cannot nest :global")
+	}
+	if c.arg == "" || !validDelim(c.arg[0]) {
+		return fmt.Errorf("Usage: g/pattern/command")
+	}
+	pat, cmd, _ := splitPattern(c.arg[1:], c.arg[0])
+	if pat == "" {
+		pat = e.searchQuery
+	}
+	if pat == "" {
+		return fmt.Errorf("No previous search pattern")
+	}
+	re, err := e.compilePattern(pat)
+	if err != nil {
+		return err
+	}
+	e.searchQuery = pat
+
+	var marks []*mark
+	for y := c.line1; y <= c.line2; y++ {
+		if re.MatchString(e.buffer.Lines[y]) == match {
+			marks = append(marks, e.buffer.anchor(y, 0))
+		}
+	}
+	if len(marks) == 0 {
+		if match {
+			return fmt.Errorf("Pattern not found: %s", pat)
+		}
+		return fmt.Errorf("Pattern found in every line: %s", pat)
+	}
+	cmd = strings.TrimSpace(cmd)
+	if cmd == "" {
+		for _, m := range marks {
+			e.buffer.release(m)
+		}
+		e.statusMsg = plural(len(marks), "matching line", "matching lines")
+		return nil
+	}
+
+	e.inGlobal = true
+	e.beginUndoGroup()
+	defer func() {
+		e.endUndoGroup()
+		e.inGlobal = false
+	}()
+	before := len(e.buffer.Lines)
+	changed, ran := 0, 0
+	var firstErr error
+	for _, m := range marks {
+		if !m.deleted {
+			tick := e.buffer.changedTick
+			e.cy, e.cx = m.line, 0
+			if err := e.runEx(cmd); err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+			} else {
+				ran++
+			}
+			if e.buffer.changedTick != tick {
+				changed++
+			}
+		}
+		e.buffer.release(m)
+	}
+	if ran == 0 && firstErr != nil {
+		return firstErr
+	}
+	e.clampCursor()
+
+	switch d := len(e.buffer.Lines) - before; {
+	case d < 0:
+		e.statusMsg = fmt.Sprintf("%d fewer lines", -d)
+	case d > 0:
+		e.statusMsg = fmt.Sprintf("%d more lines", d)
+	case changed > 0:
+		e.statusMsg = plural(changed, "line", "lines") + " changed"
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLineCommands(t *testing.T) {
+	tests := []struct {
+		cmd  string
+		want []string
+		cy   int
+	}{
+		{"2,3d", []string{"a", "d", "e"}, 1},
+		{"2d 2", []string{"a", "d", "e"}, 1},
+		{"1m$", []string{"b", "c", "d", "e", "a"}, 4},
+		{"4,5m0", []string{"d", "e", "a", "b", "c"}, 1},
+		{"1,2m3", []string{"c", "a", "b", "d", "e"}, 2},
+		{"1t.", []string{"a", "b", "c", "a", "d", "e"}, 3},
+		{"1,2t0", []string{"a", "b", "a", "b", "c", "d", "e"}, 1},
+		{"%norm A;", []string{"a;", "b;", "c;", "d;", "e;"}, 4},
+		{"2,3norm ix<Esc>", []string{"a", "xb", "xc", "d", "e"}, 2},
+	}
+	for _, tt := range tests {
+		e := newTestEditor("a", "b", "c", "d", "e")
+		e.cy = 2
+		e.exec(tt.cmd)
+		if !reflect.DeepEqual(e.buffer.Lines, tt.want) || e.cy != tt.cy {
+			t.Errorf("%q: got %q cy=%d (status %q), want %q cy=%d",
+				tt.cmd, e.buffer.Lines, e.cy, e.statusMsg, tt.want, tt.cy)
+		}
+	}
+
+	e := newTestEditor("a", "b", "c")
+	e.exec("1,3m2")
+	if e.statusMsg != "Cannot move a range of lines into itself" {
+		t.Errorf("move into itself: status %q", e.statusMsg)
+	}
+}
+
+func TestGlobal(t *testing.T) {
+	tests := []struct {
+		cmd  string
+		want []string
+	}{
+		{"g/x/d", []string{"b", "d"}},
+		{"v/x/d", []string{"x1", "x2", "x3"}},
+		{"g!/x/d", []string{"x1", "x2", "x3"}},
+		{"g/^/m0", []string{"d", "x3", "x2", "b", "x1"}},
+		{"g/x/t.", []string{"x1", "x1", "b", "x2", "x2", "x3", "x3", "d"}},
+		{"g/x/s/\\d/N/", []string{"xN", "b", "xN", "xN", "d"}},
+		{"g/x/norm Ay", []string{"x1y", "b", "x2y", "x3y", "d"}},
+		{"g/x/.,+1d", []string{"d"}},
+		{"2,4g/x/d", []string{"x1", "b", "d"}},
+	}
+	for _, tt := range tests {
+		e := newTestEditor("x1", "b", "x2", "x3", "d")
+		e.exec(tt.cmd)
+		if !reflect.DeepEqual(e.buffer.Lines, tt.want) {
+			t.Errorf("%q: got %q (status %q), want %q", tt.cmd, e.buffer.Lines, e.statusMsg, tt.want)
+			continue
+		}
+		e.undo()
+		if want := []string{"x1", "b", "x2", "x3", "d"}; !reflect.DeepEqual(e.buffer.Lines, want) {
+			t.Errorf("%q: one undo should restore the buffer, got %q", tt.cmd, e.buffer.Lines)
+		}
+		if n := len(e.buffer.anchors) - len(e.buffer.marks); n != 0 {
+			t.Errorf("%q: %d anchors left behind", tt.cmd, n)
+		}
+	}
+
+	e := newTestEditor("a", "b")
+	e.exec("g/z/d")
+	if e.statusMsg != "Pattern not found: z" {
+		t.Errorf("no match: status %q", e.statusMsg)
+	}
+	e.exec("g/a/g/b/d")
+	if e.statusMsg != "Cannot nest :global" {
+		t.Errorf("nested: status %q", e.statusMsg)
+	}
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// --- File Commands ---
+
+func init() {
+	registerEx(
+		&exCommand{name: "write", abbrev: "w", rng: rangeFile, bang: true, complete: completeFiles, run: exWrite},
+		&exCommand{name: "wq", rng: rangeFile, bang: true, complete: completeFiles, run: exWriteQuit},
+		&exCommand{name: "saveas", abbrev: "sav", bang: true, complete: completeFiles, run: exSaveAs},
+		&exCommand{name: "read", abbrev: "r", rng: rangeLine, zero: true, complete: completeFiles, run: exRead},
+		&exCommand{name: "edit", abbrev: "e", bang: true, complete: completeFiles, run: exEdit},
+		&exCommand{name: "buffer", abbrev: "b", complete: completeBuffers, run: exBuffer},
+		&exCommand{name: "buffers", run: exBuffers},
+		&exCommand{name: "ls", run: exBuffers},
+	)
+}
+
+// completeBuffers returns the names of the open buffers that contain arg.
+func completeBuffers(e *Editor, arg string) []string {
+	var names []string
+	for _, b := range e.buffers {
+		if b.FilePath != "" && strings.Contains(b.FilePath, arg) {
+			names = append(names, b.FilePath)
+		}
+	}
+	return names
+}
+
+// samePath reports whether two paths name the same file.
+func samePath(a, b string) bool {
+	if a == "" || b == "" {
+		return false
+	}
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	return errA == nil && errB == nil && absA == absB
+}
+
+// confirmOverwrite runs write, first asking whether to replace path if it
+// already exists and force is not set.
+func (e *Editor) confirmOverwrite(path string, force bool, write func() error) error {
+	if _, err := os.Stat(path); force || err != nil {
+		return write()
+	}
+	e.confirm(fmt.Sprintf("Overwrite existing file %s? (y/n)", path), func(r rune) {
+		if r != 'y' {
+			e.statusMsg = "Not written"
+			return
+		}
+		if err := write(); err != nil {
+			e.statusMsg = err.Error()
+		}
+	})
+	return nil
+}
+
+// writeCopy writes lines to a file other than the buffer's own.
+func (e *Editor) writeCopy(path string, lines []string, appendTo bool) error {
+	if err := e.checkSecure(); err != nil {
+		return err
+	}
+	if err := writeLines(path, lines, appendTo); err != nil {
+		return fmt.Errorf("Error writing %s: %v", path, err)
+	}
+	verb := "written"
+	if appendTo {
+		verb = "appended"
+	}
+	e.statusMsg = fmt.Sprintf("'%s' %s %s", path, plural(len(lines), "line", "lines"), verb)
+	return nil
+}
+
+// exWrite saves the buffer, or writes the lines of the range to a file:
+// :[range]w[!] [path] and :[range]w >> [path]. An unnamed buffer takes the
+// name of the file it is first written to. :[range]w !cmd pipes the lines
+// to a command instead.
+func exWrite(e *Editor, c *exCall) error {
+	if strings.HasPrefix(c.arg, "!") {
+		command, err := e.shellCommand(c.arg[1:], false)
+		if err != nil {
+			return err
+		}
+		lines := append([]string(nil), e.buffer.Lines[c.line1:c.line2+1]...)
+		return e.startShell(command, lines, func(out []byte, err error) {
+			e.showOutput(command, out, err)
+		})
+	}
+	arg := c.arg
+	appendTo := strings.HasPrefix(arg, ">>")
+	if appendTo {
+		arg = arg[2:]
+	}
+	path, err := pathArg(arg)
+	if err != nil {
+		return err
+	}
+	whole := c.line1 == 0 && c.line2 == len(e.buffer.Lines)-1
+	if path == "" {
+		if e.buffer.FilePath == "" {
+			return fmt.Errorf("No file name (use :w <path>)")
+		}
+		if whole && !appendTo {
+			return e.writeBuffer()
+		}
+		if !appendTo && !c.bang {
+			return fmt.Errorf("Use ! to write part of the buffer to its file")
+		}
+		path = e.buffer.FilePath
+	}
+
+	if whole && !appendTo {
+		if samePath(path, e.buffer.FilePath) {
+			return e.writeBuffer()
+		}
+		if e.buffer.FilePath == "" {
+			return e.confirmOverwrite(path, c.bang, func() error {
+				e.buffer.SetPath(path)
+				return e.writeBuffer()
+			})
+		}
+	}
+	lines := append([]string(nil), e.buffer.Lines[c.line1:c.line2+1]...)
+	if appendTo {
+		return e.writeCopy(path, lines, true)
+	}
+	return e.confirmOverwrite(path, c.bang, func() error {
+		return e.writeCopy(path, lines, false)
+	})
+}
+
+// exWriteQuit writes like :w and quits if the buffer was saved, unless
+// another buffer has unsaved changes and there is no !.
+func exWriteQuit(e *Editor, c *exCall) error {
+	if err := exWrite(e, c); err != nil {
+		return err
+	}
+	if e.mode == ModeConfirm || e.buffer.Dirty {
+		return nil
+	}
+	if !c.bang {
+		if err := e.checkUnsaved(); err != nil {
+			return err
+		}
+	}
+	e.app.Stop()
+	return nil
+}
+
+// exSaveAs saves the buffer under a new name, which it keeps: :saveas[!] path
+func exSaveAs(e *Editor, c *exCall) error {
+	path, err := pathArg(c.arg)
+	if err != nil {
+		return err
+	}
+	if path == "" {
+		return fmt.Errorf("Argument required")
+	}
+	if b := e.findBuffer(path); b != nil && b != e.buffer {
+		return fmt.Errorf("File is loaded in another buffer: %s", path)
+	}
+	return e.confirmOverwrite(path, c.bang || samePath(path, e.buffer.FilePath), func() error {
+		e.buffer.SetPath(path)
+		return e.writeBuffer()
+	})
+}
+
+// exRead inserts the lines of a file below the cursor line, or below the
+// line given: :[line]r [path]. :0r inserts them at the top, and :r !cmd
+// inserts the output of a command.
+func exRead(e *Editor, c *exCall) error {
+	if strings.HasPrefix(c.arg, "!") {
+		command, err := e.shellCommand(c.arg[1:], false)
+		if err != nil {
+			return err
+		}
+		return e.readCommand(c.line2, command)
+	}
+	path, err := pathArg(c.arg)
+	if err != nil {
+		return err
+	}
+	if path == "" {
+		if path = e.buffer.FilePath; path == "" {
+			return fmt.Errorf("No file name")
+		}
+	}
+	lines, err := readLines(path)
+	if err != nil {
+		return fmt.Errorf("Error reading %s: %v", path, err)
+	}
+	if len(lines) == 0 {
+		e.statusMsg = fmt.Sprintf("'%s' is empty", path)
+		return nil
+	}
+	e.pushUndo()
+	e.buffer.InsertLines(c.line2+1, lines...)
+	e.cy = c.line2 + 1
+	e.cx = firstNonBlank(e.buffer.Lines[e.cy])
+	e.statusMsg = fmt.Sprintf("'%s' %s read", path, plural(len(lines), "line", "lines"))
+	return nil
+}
+
+// exEdit opens a file in its own buffer, or switches to the buffer already
+// editing it: :e path. Without a path the buffer's file is read again, which
+// needs ! to throw away changes: :e[!]
+func exEdit(e *Editor, c *exCall) error {
+	path, err := pathArg(c.arg)
+	if err != nil {
+		return err
+	}
+	if path == "" {
+		return e.reloadBuffer(c.bang)
+	}
+	if err := e.editFile(path); err != nil {
+		return err
+	}
+	e.statusMsg = fmt.Sprintf("'%s' %s", path, plural(len(e.buffer.Lines), "line", "lines"))
+	return nil
+}
+
+// editFile makes the buffer of a file current, opening the file if no
+// buffer holds it yet.
+func (e *Editor) editFile(path string) error {
+	b := e.findBuffer(path)
+	opened := b == nil
+	if opened {
+		var err error
+		if b, err = NewBuffer(path); err != nil {
+			return fmt.Errorf("Error opening %s: %v", path, err)
+		}
+	}
+	if b != e.buffer {
+		e.setJump()
+		e.switchBuffer(b)
+	}
+	if opened {
+		e.loadUndo()
+	}
+	return nil
+}
+
+// reloadBuffer replaces the buffer's lines with its file's. The reload can
+// be undone.
+func (e *Editor) reloadBuffer(force bool) error {
+	b := e.buffer
+	if b.FilePath == "" {
+		return fmt.Errorf("No file name")
+	}
+	if b.Dirty && !force {
+		return fmt.Errorf("No write since last change (add ! to override)")
+	}
+	lines, err := readLines(b.FilePath)
+	if err != nil {
+		return fmt.Errorf("Error reading %s: %v", b.FilePath, err)
+	}
+	if len(lines) == 0 {
+		lines = []string{""}
+	}
+	e.pushUndo()
+	b.ReplaceLines(0, len(b.Lines), lines...)
+	b.Dirty = false
+	e.clampCursor()
+	e.statusMsg = fmt.Sprintf("'%s' %s", b.FilePath, plural(len(lines), "line", "lines"))
+	return nil
+}
+
+// exBuffer switches to a buffer given by its number in :ls or by part of
+// its name: :b 2, :b main.go
+func exBuffer(e *Editor, c *exCall) error {
+	arg := strings.TrimSpace(c.arg)
+	if arg == "" {
+		e.statusMsg = fmt.Sprintf("'%s' buffer %d", e.buffer.BaseName(), e.bufferNumber(e.buffer))
+		return nil
+	}
+	var target *Buffer
+	if n, rest := leadingNumber(arg); rest == "" {
+		if n < 1 || n > len(e.buffers) {
+			return fmt.Errorf("Buffer %d does not exist", n)
+		}
+		target = e.buffers[n-1]
+	} else if target = e.findBuffer(arg); target == nil {
+		matches := completeBuffers(e, arg)
+		switch len(matches) {
+		case 0:
+			return fmt.Errorf("No matching buffer for %s", arg)
+		case 1:
+			target = e.findBuffer(matches[0])
+		default:
+			return fmt.Errorf("More than one match for %s", arg)
+		}
+	}
+	if target != e.buffer {
+		e.setJump()
+		e.switchBuffer(target)
+	}
+	return nil
+}
+
+// bufferNumber returns the number :ls shows for b.
+func (e *Editor) bufferNumber(b *Buffer) int {
+	for i, ob := range e.buffers {
+		if ob == b {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// exBuffers lists the open buffers in the pane: :ls. % marks the current
+// buffer and + one with unsaved changes.
+func exBuffers(e *Editor, c *exCall) error {
+	var sb strings.Builder
+	for i, b := range e.buffers {
+		flags, line := " ", e.cy+1
+		if b == e.buffer {
+			flags = "%"
+		} else if m := b.getMark('"'); m != nil {
+			line = m.line + 1
+		} else {
+			line = 1
+		}
+		if b.Dirty {
+			flags += "+"
+		} else {
+			flags += " "
+		}
+		name := b.FilePath
+		if name == "" {
+			name = b.BaseName()
+		}
+		fmt.Fprintf(&sb, "%3d %s %-30q line %d\n", i+1, flags, name, line)
+	}
+	e.showPane("Buffers", sb.String())
+	return nil
+}
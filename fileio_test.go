@@ -0,0 +1,191 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestWriteNamesUnnamedBuffer(t *testing.T) {
+	dir := t.TempDir()
+	e := newTestEditor("one", "two")
+	e.exec("w")
+	if e.statusMsg != "No file name (use :w <path>)" {
+		t.Fatalf("saving an unnamed buffer: status %q", e.statusMsg)
+	}
+
+	path := filepath.Join(dir, "sub", "dir", "new.go")
+	e.exec("w " + path)
+	if got := readFile(t, path); got != "one\ntwo" {
+		t.Fatalf("written %q", got)
+	}
+	if e.buffer.FilePath != path || e.buffer.FileType != "go" || e.buffer.Dirty {
+		t.Fatalf("buffer should now be %s: %+v", path, e.buffer)
+	}
+}
+
+func TestWriteCopiesAndRanges(t *testing.T) {
+	dir := t.TempDir()
+	own := filepath.Join(dir, "own.txt")
+	e := newTestEditor("a", "b", "c")
+	e.buffer.SetPath(own)
+	e.buffer.markChanged()
+
+	cp := filepath.Join(dir, "copy.txt")
+	e.exec("w " + cp)
+	if got := readFile(t, cp); got != "a\nb\nc" || e.buffer.FilePath != own || !e.buffer.Dirty {
+		t.Fatalf("copy %q, path %s, dirty %v", got, e.buffer.FilePath, e.buffer.Dirty)
+	}
+
+	part := filepath.Join(dir, "part.txt")
+	e.exec("2,3w " + part)
+	e.exec("1w >> " + part)
+	if got := readFile(t, part); got != "b\nc\na" {
+		t.Fatalf("range write and append gave %q", got)
+	}
+
+	e.exec("2w")
+	if e.statusMsg != "Use ! to write part of the buffer to its file" {
+		t.Fatalf("partial write to own file: status %q", e.statusMsg)
+	}
+}
+
+func TestWriteAsksBeforeOverwriting(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "exists.txt")
+	os.WriteFile(path, []byte("old"), 0644)
+	e := newTestEditor("new")
+
+	e.exec("w " + path)
+	if e.mode != ModeConfirm {
+		t.Fatalf("should ask before overwriting, mode %s", e.mode)
+	}
+	typeKeys(e, "n")
+	if got := readFile(t, path); got != "old" || e.statusMsg != "Not written" {
+		t.Fatalf("after n: file %q status %q", got, e.statusMsg)
+	}
+
+	e.exec("w " + path)
+	typeKeys(e, "y")
+	if got := readFile(t, path); got != "new" || e.buffer.FilePath != path {
+		t.Fatalf("after y: file %q path %q", got, e.buffer.FilePath)
+	}
+
+	e.buffer.SetLine(0, "newer")
+	other := filepath.Join(dir, "other.txt")
+	os.WriteFile(other, []byte("x"), 0644)
+	e.exec("w! " + other)
+	if got := readFile(t, other); got != "newer" || e.mode != ModeNormal {
+		t.Fatalf(":w! should overwrite without asking, file %q mode %s", got, e.mode)
+	}
+}
+
+func TestSaveAsAndRead(t *testing.T) {
+	dir := t.TempDir()
+	e := newTestEditor("x", "y")
+	e.buffer.SetPath(filepath.Join(dir, "first.txt"))
+	renamed := filepath.Join(dir, "second.md")
+	e.exec("saveas " + renamed)
+	if e.buffer.FilePath != renamed || e.buffer.FileType != "markdown" || readFile(t, renamed) != "x\ny" {
+		t.Fatalf("saveas: path %s type %s", e.buffer.FilePath, e.buffer.FileType)
+	}
+
+	src := filepath.Join(dir, "in.txt")
+	os.WriteFile(src, []byte("r1\nr2\n"), 0644)
+	e.exec("r " + src)
+	if want := []string{"x", "r1", "r2", "y"}; !reflect.DeepEqual(e.buffer.Lines, want) || e.cy != 1 {
+		t.Fatalf(":r gave %q cy=%d", e.buffer.Lines, e.cy)
+	}
+	e.exec("0r " + src)
+	e.exec("$r " + src)
+	if want := []string{"r1", "r2", "x", "r1", "r2", "y", "r1", "r2"}; !reflect.DeepEqual(e.buffer.Lines, want) {
+		t.Fatalf(":0r and :$r gave %q", e.buffer.Lines)
+	}
+	e.exec("r " + filepath.Join(dir, "missing"))
+	if e.statusMsg == "" || len(e.buffer.Lines) != 8 {
+		t.Fatalf("reading a missing file: status %q", e.statusMsg)
+	}
+}
+
+func TestEditAndBufferCommands(t *testing.T) {
+	dir := t.TempDir()
+	a, b := filepath.Join(dir, "a.txt"), filepath.Join(dir, "b.txt")
+	os.WriteFile(a, []byte("a1\na2\n"), 0644)
+	os.WriteFile(b, []byte("b1\n"), 0644)
+	e := NewEditor()
+	buf, _ := NewBuffer(a)
+	e.switchBuffer(buf)
+
+	e.exec("e " + b)
+	if e.buffer.FilePath != b || len(e.buffers) != 2 {
+		t.Fatalf(":e should open %s in a second buffer, got %q", b, e.buffer.FilePath)
+	}
+	e.exec("b 1")
+	if e.buffer.FilePath != a {
+		t.Fatalf(":b 1 should switch back, got %q", e.buffer.FilePath)
+	}
+	e.exec("b b.t")
+	if e.buffer.FilePath != b {
+		t.Fatalf(":b with part of a name should switch, got %q", e.buffer.FilePath)
+	}
+	e.exec("b .txt")
+	if e.statusMsg != "More than one match for .txt" {
+		t.Fatalf("ambiguous :b: status %q", e.statusMsg)
+	}
+	e.exec("b 3")
+	if e.statusMsg != "Buffer 3 does not exist" {
+		t.Fatalf(":b 3: status %q", e.statusMsg)
+	}
+
+	typeKeys(e, "ddix<Esc>")
+	e.exec("e")
+	if e.statusMsg != "No write since last change (add ! to override)" {
+		t.Fatalf(":e on a changed buffer: status %q", e.statusMsg)
+	}
+	e.exec("e!")
+	if !reflect.DeepEqual(e.buffer.Lines, []string{"b1"}) || e.buffer.Dirty {
+		t.Fatalf(":e! should reload the file, got %q dirty %v", e.buffer.Lines, e.buffer.Dirty)
+	}
+	e.undo()
+	if !reflect.DeepEqual(e.buffer.Lines, []string{"x"}) {
+		t.Fatalf("the reload should undo, got %q", e.buffer.Lines)
+	}
+
+	e.exec("ls")
+	if !e.paneVisible {
+		t.Fatal(":ls should open the pane")
+	}
+}
+
+func TestQuitChecksEveryBuffer(t *testing.T) {
+	dir := t.TempDir()
+	a, b := filepath.Join(dir, "a.txt"), filepath.Join(dir, "b.txt")
+	os.WriteFile(a, []byte("a1\n"), 0644)
+	os.WriteFile(b, []byte("b1\n"), 0644)
+	e := NewEditor()
+	buf, _ := NewBuffer(a)
+	e.switchBuffer(buf)
+	typeKeys(e, "x")
+	e.exec("e! " + b)
+
+	want := `No write since last change for buffer "a.txt" (add ! to override)`
+	if err := e.runEx("q"); err == nil || err.Error() != want {
+		t.Fatalf(":q with another buffer changed: %v", err)
+	}
+	if err := e.runEx("wq"); err == nil || err.Error() != want {
+		t.Fatalf(":wq with another buffer changed: %v", err)
+	}
+	if err := e.runEx("q!"); err != nil {
+		t.Fatalf(":q! should quit anyway: %v", err)
+	}
+}
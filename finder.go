@@ -0,0 +1,496 @@
+package main
+
+import (
+	"container/heap"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+// --- Fuzzy File Finder ---
+
+const (
+	finderBatch       = 2000                   // Files indexed between updates of the list
+	finderBatchTime   = 100 * time.Millisecond // Longest time between updates while indexing
+	finderMaxResults  = 500                    // Most matches listed
+	finderPreviewSize = 32 * 1024              // Bytes of the selected file previewed
+)
+
+// finder is the state of the file finder overlay.
+type finder struct {
+	root     string   // Directory the listed paths are relative to
+	files    []string // Files found so far
+	cached   bool     // files are those of an earlier walk, kept until this one ends
+	indexing bool
+	cancel   context.CancelFunc // Stops the indexing
+	results  []fuzzyResult
+	sel      int  // Selected result
+	prevMode Mode // Mode to go back to when the finder closes
+
+	lastQuery string   // Query the results are for
+	matched   []string // Files matching lastQuery, of the first matchedOf files
+	matchedOf int
+}
+
+// fuzzyResult is a file matching the finder's query.
+type fuzzyResult struct {
+	path      string
+	score     int
+	positions []int // Byte offsets of the matched characters
+}
+
+func init() {
+	registerEx(&exCommand{name: "find", abbrev: "fin", run: func(e *Editor, c *exCall) error {
+		return e.openFinder(c.arg)
+	}})
+}
+
+// newFinderView builds the finder overlay: the query above the matching
+// files and a preview of the selected one, centered over the editor.
+func (e *Editor) newFinderView() tview.Primitive {
+	e.finderInput = tview.NewInputField().SetLabel("> ").SetFieldBackgroundColor(tcell.ColorBlack)
+	e.finderInput.SetChangedFunc(func(string) { e.rankFiles(false) })
+	e.finderList = tview.NewTextView().SetDynamicColors(true).SetWrap(false)
+	e.finderPreview = tview.NewTextView().SetWrap(false)
+	e.finderPreview.SetBorder(true)
+	e.finderView = tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(e.finderInput, 1, 0, true).
+		AddItem(tview.NewFlex().
+			AddItem(e.finderList, 0, 1, false).
+			AddItem(e.finderPreview, 0, 1, false), 0, 1, false)
+	e.finderView.SetBorder(true)
+
+	return tview.NewFlex().
+		AddItem(nil, 0, 1, false).
+		AddItem(tview.NewFlex().SetDirection(tview.FlexRow).
+			AddItem(nil, 0, 1, false).
+			AddItem(e.finderView, 0, 8, true).
+			AddItem(nil, 0, 1, false), 0, 8, true).
+		AddItem(nil, 0, 1, false)
+}
+
+// openFinder shows the finder over the editor, listing the files of the
+// project: the git repository holding the current directory, or the
+// directory itself. Files found earlier are listed at once while the
+// project is indexed again in the background.
+func (e *Editor) openFinder(query string) error {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	root := gitRoot(cwd)
+	if root == "" {
+		root = cwd
+	}
+	f := &finder{root: root, prevMode: e.mode}
+	if e.fileIndexRoot == root {
+		f.files, f.cached = e.fileIndex, true
+	}
+	e.finder = f
+	e.mode = ModeFinder
+	e.indexFiles(f)
+	e.finderInput.SetText(query)
+	e.rankFiles(false)
+	e.pages.ShowPage("finder")
+	return nil
+}
+
+// closeFinder hides the finder and stops its indexing.
+func (e *Editor) closeFinder() {
+	if e.finder == nil {
+		return
+	}
+	if e.finder.cancel != nil {
+		e.finder.cancel()
+	}
+	e.mode = e.finder.prevMode
+	if e.mode != ModeInsert {
+		e.mode = ModeNormal
+	}
+	e.finder = nil
+	e.pages.HidePage("finder")
+}
+
+// indexFiles lists the files of the finder's root in the background,
+// passing them to the finder in batches so the list fills in while a large
+// project is walked. Files listed from an earlier walk stay until the walk
+// ends and replaces them. The complete list is kept for the next time.
+func (e *Editor) indexFiles(f *finder) {
+	ctx, cancel := context.WithCancel(context.Background())
+	f.cancel, f.indexing = cancel, true
+	go func() {
+		var files, batch []string
+		last := time.Now()
+		flush := func(done bool) {
+			files = append(files, batch...)
+			all := files
+			batch = nil
+			last = time.Now()
+			e.queueUpdate(func() {
+				if e.finder != f || f.cached && !done {
+					return
+				}
+				if f.cached {
+					// A new list rather than more of the same one
+					f.cached, f.matched, f.matchedOf = false, nil, 0
+				}
+				f.files = all
+				f.indexing = !done
+				if done {
+					e.fileIndex, e.fileIndexRoot = all, f.root
+				}
+				e.rankFiles(true)
+			})
+		}
+		err := walkFiles(ctx, f.root, func(path string) bool {
+			rel, err := filepath.Rel(f.root, path)
+			if err != nil {
+				return true
+			}
+			batch = append(batch, filepath.ToSlash(rel))
+			if len(batch) >= finderBatch || time.Since(last) > finderBatchTime {
+				flush(false)
+			}
+			return true
+		})
+		if err == nil {
+			flush(true)
+		}
+	}()
+}
+
+// rankFiles matches the files against the query and lists the best ones.
+// With keep set, as when more files have been indexed, the same file stays
+// selected. When the query only grows, just the files that matched before
+// are matched again.
+func (e *Editor) rankFiles(keep bool) {
+	f := e.finder
+	if f == nil {
+		return
+	}
+	query := e.finderInput.GetText()
+	selected := ""
+	if f.sel < len(f.results) {
+		selected = f.results[f.sel].path
+	}
+
+	candidates := f.files
+	if f.matchedOf > 0 && strings.HasPrefix(query, f.lastQuery) {
+		candidates = append(f.matched[:len(f.matched):len(f.matched)], f.files[f.matchedOf:]...)
+	}
+	fq := newFuzzyQuery(query)
+	var matched []string
+	best := &resultHeap{}
+	for _, path := range candidates {
+		score, _, ok := fq.match(path, false)
+		if !ok {
+			continue
+		}
+		matched = append(matched, path)
+		r := fuzzyResult{path: path, score: score}
+		if best.Len() < finderMaxResults {
+			heap.Push(best, r)
+		} else if worse((*best)[0], r) {
+			(*best)[0] = r
+			heap.Fix(best, 0)
+		}
+	}
+	f.lastQuery, f.matched, f.matchedOf = query, matched, len(f.files)
+
+	f.results = make([]fuzzyResult, best.Len())
+	for i := len(f.results) - 1; i >= 0; i-- {
+		f.results[i] = heap.Pop(best).(fuzzyResult)
+		_, f.results[i].positions, _ = fq.match(f.results[i].path, true)
+	}
+	f.sel = 0
+	if keep {
+		for i, r := range f.results {
+			if r.path == selected {
+				f.sel = i
+			}
+		}
+	}
+	e.renderFinder()
+}
+
+// worse reports whether a ranks below b: it has a lower score, or a longer
+// path, or comes later in order.
+func worse(a, b fuzzyResult) bool {
+	if a.score != b.score {
+		return a.score < b.score
+	}
+	if len(a.path) != len(b.path) {
+		return len(a.path) > len(b.path)
+	}
+	return a.path > b.path
+}
+
+// resultHeap keeps the best results, with the worst of them first.
+type resultHeap []fuzzyResult
+
+func (h resultHeap) Len() int            { return len(h) }
+func (h resultHeap) Less(i, j int) bool  { return worse(h[i], h[j]) }
+func (h resultHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *resultHeap) Push(x interface{}) { *h = append(*h, x.(fuzzyResult)) }
+func (h *resultHeap) Pop() interface{} {
+	old := *h
+	r := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return r
+}
+
+// renderFinder shows the results, with the matched characters and the
+// selected file highlighted, and a preview of the selected file.
+func (e *Editor) renderFinder() {
+	f := e.finder
+	if f == nil {
+		return
+	}
+	title := fmt.Sprintf(" Files %d/%d ", len(f.results), len(f.files))
+	if f.indexing {
+		title = fmt.Sprintf(" Files %d/%d (indexing) ", len(f.results), len(f.files))
+	}
+	e.finderView.SetTitle(title)
+
+	var b strings.Builder
+	match, selected := e.colorTag("searchcolor"), e.colorTag("menucolor")
+	for i, r := range f.results {
+		tags := make([]string, len(r.path))
+		if i == f.sel {
+			for j := range tags {
+				tags[j] = selected
+			}
+		}
+		for _, p := range r.positions {
+			_, size := utf8.DecodeRuneInString(r.path[p:])
+			for j := p; j < p+size; j++ {
+				tags[j] = match
+			}
+		}
+		b.WriteString(colorRuns(r.path, tags) + "\n")
+	}
+	e.finderList.SetText(b.String())
+	_, _, _, height := e.finderList.GetInnerRect()
+	top := f.sel - height + 1
+	if top < 0 {
+		top = 0
+	}
+	e.finderList.ScrollTo(top, 0)
+
+	e.finderPreview.SetTitle("")
+	e.finderPreview.SetText("")
+	if f.sel < len(f.results) {
+		path := f.results[f.sel].path
+		e.finderPreview.SetTitle(" " + path + " ")
+		e.finderPreview.SetText(previewFile(filepath.Join(f.root, path))).ScrollToBeginning()
+	}
+}
+
+// colorRuns returns text for a dynamic-color text view with each byte in
+// the color of its tag, "" for the default colors.
+func colorRuns(text string, tags []string) string {
+	var b strings.Builder
+	for i := 0; i < len(text); {
+		j := i + 1
+		for j < len(text) && tags[j] == tags[i] {
+			j++
+		}
+		if tags[i] == "" {
+			b.WriteString(tview.Escape(text[i:j]))
+		} else {
+			b.WriteString(tags[i] + tview.Escape(text[i:j]) + "[-:-]")
+		}
+		i = j
+	}
+	return b.String()
+}
+
+// previewFile returns the start of a file for the preview.
+func previewFile(path string) string {
+	file, err := os.Open(path)
+	if err != nil {
+		return err.Error()
+	}
+	defer file.Close()
+	data, err := io.ReadAll(io.LimitReader(file, finderPreviewSize))
+	if err != nil {
+		return err.Error()
+	}
+	if isBinary(data) {
+		return "(binary file)"
+	}
+	return string(data)
+}
+
+// finderKey handles a key in the finder: Up and Down (or Ctrl-P and
+// Ctrl-N) select a file, Enter opens it and Esc closes the finder. Other
+// keys edit the query.
+func (e *Editor) finderKey(event *tcell.EventKey) {
+	f := e.finder
+	switch event.Key() {
+	case tcell.KeyEsc:
+		e.closeFinder()
+	case tcell.KeyEnter:
+		if f.sel >= len(f.results) {
+			e.closeFinder()
+			return
+		}
+		path := filepath.Join(f.root, f.results[f.sel].path)
+		if cwd, err := os.Getwd(); err == nil {
+			if rel, err := filepath.Rel(cwd, path); err == nil && !strings.HasPrefix(rel, "..") {
+				path = rel
+			}
+		}
+		e.closeFinder()
+		if err := e.editFile(path); err != nil {
+			e.statusMsg = err.Error()
+			return
+		}
+		e.statusMsg = fmt.Sprintf("'%s' %s", path, plural(len(e.buffer.Lines), "line", "lines"))
+	case tcell.KeyDown, tcell.KeyCtrlN:
+		if f.sel+1 < len(f.results) {
+			f.sel++
+			e.renderFinder()
+		}
+	case tcell.KeyUp, tcell.KeyCtrlP:
+		if f.sel > 0 {
+			f.sel--
+			e.renderFinder()
+		}
+	default:
+		e.finderInput.InputHandler()(event, func(p tview.Primitive) {})
+	}
+}
+
+// fuzzyMatch reports whether the characters of query appear in path in
+// order, and scores the match: characters at the start of a word or path
+// element, characters following each other and matches in the file name
+// score higher, and a match in the file name is taken over one in the
+// directories. It also returns the byte offsets of the matched characters.
+// The match ignores case unless query has a capital letter.
+func fuzzyMatch(query, path string) (score int, positions []int, ok bool) {
+	return newFuzzyQuery(query).match(path, true)
+}
+
+// fuzzyQuery is a finder query prepared for matching many paths.
+type fuzzyQuery struct {
+	runes      []rune
+	ignoreCase bool
+}
+
+func newFuzzyQuery(query string) *fuzzyQuery {
+	q := &fuzzyQuery{runes: []rune(query), ignoreCase: !hasUpper(query)}
+	for i, r := range q.runes {
+		q.runes[i] = q.fold(r)
+	}
+	return q
+}
+
+// fold returns r in lower case if the query ignores case.
+func (fq *fuzzyQuery) fold(r rune) rune {
+	switch {
+	case !fq.ignoreCase:
+		return r
+	case r < utf8.RuneSelf:
+		if 'A' <= r && r <= 'Z' {
+			r += 'a' - 'A'
+		}
+		return r
+	}
+	return unicode.ToLower(r)
+}
+
+// match matches path as fuzzyMatch does. The positions of the matched
+// characters are only returned if withPositions is set.
+func (fq *fuzzyQuery) match(path string, withPositions bool) (score int, positions []int, ok bool) {
+	q, fold := fq.runes, fq.fold
+	if len(q) == 0 {
+		return 0, nil, true
+	}
+
+	// window returns the start of the shortest match of the whole query
+	// in path[from:] that ends where the query first matches.
+	window := func(from int) (int, bool) {
+		end, qi := -1, 0
+		for i, r := range path[from:] {
+			if fold(r) == q[qi] {
+				qi++
+				if qi == len(q) {
+					end = from + i
+					break
+				}
+			}
+		}
+		if end < 0 {
+			return 0, false
+		}
+		start := end + 1
+		for qi = len(q) - 1; qi >= 0; qi-- {
+			for start > from {
+				r, size := utf8.DecodeLastRuneInString(path[:start])
+				start -= size
+				if fold(r) == q[qi] {
+					break
+				}
+			}
+		}
+		return start, true
+	}
+	// Matches in the file name are preferred
+	base := strings.LastIndexByte(path, '/') + 1
+	start, ok := window(base)
+	if !ok {
+		if start, ok = window(0); !ok {
+			return 0, nil, false
+		}
+	}
+
+	qi := 0
+	prev := -2
+	for i, r := range path[start:] {
+		i += start
+		if qi == len(q) || fold(r) != q[qi] {
+			continue
+		}
+		score += 16
+		switch {
+		case i == base:
+			score += 12
+		case i == 0 || strings.IndexByte("/_-. ", path[i-1]) >= 0:
+			score += 8
+		case unicode.IsUpper(r) && unicode.IsLower(lastRune(path[:i])):
+			score += 7
+		}
+		if i >= base {
+			score += 2
+		}
+		if prev >= 0 {
+			if i == prev+utf8.RuneLen(lastRune(path[:i])) {
+				score += 8
+			} else {
+				score -= 3
+			}
+		}
+		if withPositions {
+			positions = append(positions, i)
+		}
+		prev = i
+		qi++
+	}
+	return score - len(path)/8, positions, true
+}
+
+// lastRune returns the last character of s.
+func lastRune(s string) rune {
+	r, _ := utf8.DecodeLastRuneInString(s)
+	return r
+}
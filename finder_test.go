@@ -0,0 +1,107 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFuzzyMatch(t *testing.T) {
+	_, positions, ok := fuzzyMatch("edgo", "editor.go")
+	if !ok || !reflect.DeepEqual(positions, []int{0, 1, 7, 8}) {
+		t.Errorf("edgo in editor.go: ok %v positions %v", ok, positions)
+	}
+	if _, _, ok := fuzzyMatch("xyz", "editor.go"); ok {
+		t.Errorf("xyz should not match editor.go")
+	}
+	if _, _, ok := fuzzyMatch("Ed", "editor.go"); ok {
+		t.Errorf("a query with capitals should match case")
+	}
+	_, positions, _ = fuzzyMatch("main", "cmd/domain/main.go")
+	if !reflect.DeepEqual(positions, []int{11, 12, 13, 14}) {
+		t.Errorf("main should match the file name, got %v", positions)
+	}
+
+	e := newTestEditor("")
+	e.finder = &finder{files: []string{"README.md", "internal/exec/dialog.go", "cmd/edit/main.go", "editor.go", "xeditor.go"}}
+	rank := func(query string) []string {
+		e.finderInput.SetText(query)
+		e.rankFiles(false)
+		var paths []string
+		for _, r := range e.finder.results {
+			paths = append(paths, r.path)
+		}
+		return paths
+	}
+	if got := rank("edgo"); len(got) != 4 || got[0] != "editor.go" {
+		t.Errorf("edgo: got %q", got)
+	}
+	if got := rank("main"); !reflect.DeepEqual(got, []string{"cmd/edit/main.go"}) {
+		t.Errorf("main: got %q", got)
+	}
+	if got := rank(""); len(got) != 5 || got[0] != "README.md" || got[4] != "internal/exec/dialog.go" {
+		t.Errorf("an empty query should list every file, shortest first: got %q", got)
+	}
+}
+
+func TestFinder(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, ".git", "HEAD"), "")
+	writeFile(t, filepath.Join(dir, ".gitignore"), "*.log\n")
+	writeFile(t, filepath.Join(dir, "a.go"), "package a\n")
+	writeFile(t, filepath.Join(dir, "sub", "bar.go"), "package sub\n")
+	writeFile(t, filepath.Join(dir, "sub", "bar.log"), "")
+	wd, _ := os.Getwd()
+	if err := os.Chdir(filepath.Join(dir, "sub")); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	e := newTestEditor("")
+	updates := make(chan func(), 100)
+	e.update = func(f func()) { updates <- f }
+	indexed := func() {
+		for e.finder != nil && e.finder.indexing {
+			(<-updates)()
+		}
+	}
+
+	feedKeys(e, "<C-p>")
+	if e.mode != ModeFinder {
+		t.Fatalf("Ctrl-P should open the finder, mode %s", e.mode)
+	}
+	indexed()
+	if want := []string{".gitignore", "a.go", "sub/bar.go"}; len(e.finder.files) != 3 {
+		t.Fatalf("files %q, want %q", e.finder.files, want)
+	}
+	typeKeys(e, "bar")
+	if r := e.finder.results; len(r) != 1 || r[0].path != "sub/bar.go" {
+		t.Fatalf("results %v", r)
+	}
+	if text := e.finderPreview.GetText(false); !strings.Contains(text, "package sub") {
+		t.Fatalf("preview %q", text)
+	}
+	typeKeys(e, "<CR>")
+	if e.mode != ModeNormal || e.finder != nil || e.buffer.FilePath != "bar.go" || e.buffer.Lines[0] != "package sub" {
+		t.Fatalf("Enter: mode %s file %q", e.mode, e.buffer.FilePath)
+	}
+
+	if err := os.Remove(filepath.Join(dir, "a.go")); err != nil {
+		t.Fatal(err)
+	}
+	e.exec("find a")
+	if r := e.finder.results; len(r) == 0 || r[0].path != "a.go" {
+		t.Fatalf("the files found before should be listed at once, got %v", r)
+	}
+	indexed()
+	typeKeys(e, ".g")
+	if want := []string{".gitignore", "sub/bar.go"}; !reflect.DeepEqual(e.finder.files, want) || len(e.finder.results) != 1 {
+		t.Fatalf("after indexing again: files %q results %v", e.finder.files, e.finder.results)
+	}
+	typeKeys(e, "<Esc>")
+	if e.mode != ModeNormal || e.finder != nil || e.buffer.FilePath != "bar.go" {
+		t.Fatalf("Esc: mode %s file %q", e.mode, e.buffer.FilePath)
+	}
+}
@@ -0,0 +1,375 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+// --- Grep and the Quickfix List ---
+
+// qfEntry is an entry of the quickfix list: a place in a file and the text
+// of its line.
+type qfEntry struct {
+	file      string
+	line, col int
+	text      string
+}
+
+// grepWorkers is the number of files the built-in :grep searches at once.
+var grepWorkers = runtime.NumCPU()
+
+// binaryCheckLen is how much of a file is looked at for a NUL byte, which
+// marks it as binary.
+const binaryCheckLen = 8000
+
+// grepLineRe matches a line of grepprg output: file:line:text, or
+// file:line:column:text.
+var grepLineRe = regexp.MustCompile(`^(.+?):(\d+):(?:(\d+):)?(.*)$`)
+
+func init() {
+	registerOption(&option{name: "grepprg", short: "gp", kind: stringOption, def: "", secure: true,
+		help: "Program :grep runs, with $* for its arguments; empty for the built-in search"})
+
+	registerEx(&exCommand{name: "grep", abbrev: "gr", bang: true, complete: completeFiles, run: exGrep})
+	registerEx(&exCommand{name: "cnext", abbrev: "cn", run: func(e *Editor, c *exCall) error {
+		return e.quickfixStep(1)
+	}})
+	registerEx(&exCommand{name: "cprevious", abbrev: "cp", run: func(e *Editor, c *exCall) error {
+		return e.quickfixStep(-1)
+	}})
+	registerEx(&exCommand{name: "cfirst", abbrev: "cfir", run: func(e *Editor, c *exCall) error {
+		return e.quickfixGo(0)
+	}})
+	registerEx(&exCommand{name: "clast", abbrev: "cla", run: func(e *Editor, c *exCall) error {
+		return e.quickfixGo(len(e.quickfix) - 1)
+	}})
+	registerEx(&exCommand{name: "cc", abbrev: "cc", run: func(e *Editor, c *exCall) error {
+		if c.arg == "" {
+			return e.quickfixGo(e.qfIdx)
+		}
+		n, err := strconv.Atoi(c.arg)
+		if err != nil || n < 1 || n > len(e.quickfix) {
+			return fmt.Errorf("Invalid entry: %s", c.arg)
+		}
+		return e.quickfixGo(n - 1)
+	}})
+	registerEx(&exCommand{name: "copen", abbrev: "cope", run: func(e *Editor, c *exCall) error {
+		if len(e.quickfix) == 0 {
+			return fmt.Errorf("No quickfix list")
+		}
+		e.showQuickfix()
+		e.mode = ModeQuickfix
+		return nil
+	}})
+	registerEx(&exCommand{name: "cclose", abbrev: "ccl", run: func(e *Editor, c *exCall) error {
+		e.closeQuickfix()
+		return nil
+	}})
+}
+
+// exGrep searches files for a pattern and puts the matches in the quickfix
+// list: :grep[!] pattern [path ...]. The built-in search looks through the
+// working tree; with grepprg set that program is run instead. Without ! the
+// first match is opened.
+func exGrep(e *Editor, c *exCall) error {
+	args, err := splitArgs(c.arg)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		return fmt.Errorf("Usage: grep pattern [path ...]")
+	}
+	title := "grep " + c.arg
+	jump := !c.bang
+
+	if prg := e.stringOpt("grepprg"); prg != "" {
+		if err := e.checkSecure(); err != nil {
+			return err
+		}
+		command := prg + " " + c.arg
+		if strings.Contains(prg, "$*") {
+			command = strings.ReplaceAll(prg, "$*", c.arg)
+		}
+		return e.startShell(command, nil, func(out []byte, err error) {
+			entries := parseGrepOutput(out)
+			if len(entries) == 0 && err != nil {
+				e.statusMsg = err.Error()
+				return
+			}
+			e.setQuickfix(title, entries, jump)
+		})
+	}
+
+	re, err := e.compilePattern(args[0])
+	if err != nil {
+		return err
+	}
+	paths := args[1:]
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+	return e.startJob("Searching for "+args[0], 0, func(ctx context.Context) func() {
+		entries, err := grepFiles(ctx, re, paths)
+		return func() {
+			switch {
+			case errors.Is(err, context.Canceled):
+				e.statusMsg = "Search cancelled"
+			case err != nil:
+				e.statusMsg = err.Error()
+			default:
+				e.setQuickfix(title, entries, jump)
+			}
+		}
+	})
+}
+
+// grepFiles searches the files under paths for re, several at a time, and
+// returns the matching lines sorted by file and line. Files ignored by git
+// and binary files are skipped.
+func grepFiles(ctx context.Context, re *regexp.Regexp, paths []string) ([]qfEntry, error) {
+	var mu sync.Mutex
+	var entries []qfEntry
+	err := forEachFile(ctx, paths, func(path string) {
+		if found := grepFile(re, path); len(found) > 0 {
+			mu.Lock()
+			entries = append(entries, found...)
+			mu.Unlock()
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].file != entries[j].file {
+			return entries[i].file < entries[j].file
+		}
+		return entries[i].line < entries[j].line
+	})
+	return entries, nil
+}
+
+// forEachFile calls fn with each file under paths that is not ignored by
+// git, from grepWorkers goroutines at once, until ctx is done.
+func forEachFile(ctx context.Context, paths []string, fn func(path string)) error {
+	files := make(chan string)
+	send := func(path string) bool {
+		select {
+		case files <- path:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+	var walkErr error
+	go func() {
+		defer close(files)
+		for _, p := range paths {
+			info, err := os.Stat(p)
+			if err != nil {
+				walkErr = fmt.Errorf("Cannot read %s", p)
+				return
+			}
+			if !info.IsDir() {
+				send(p)
+			} else if err := walkFiles(ctx, p, send); err != nil {
+				walkErr = err
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < grepWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for path := range files {
+				fn(path)
+			}
+		}()
+	}
+	wg.Wait()
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return walkErr
+}
+
+// isBinary reports whether data, the start of a file, looks binary: it has
+// a NUL byte in its first binaryCheckLen bytes.
+func isBinary(data []byte) bool {
+	if len(data) > binaryCheckLen {
+		data = data[:binaryCheckLen]
+	}
+	return bytes.IndexByte(data, 0) >= 0
+}
+
+// grepFile returns the lines of a file that match re. A binary file has
+// none.
+func grepFile(re *regexp.Regexp, path string) []qfEntry {
+	data, err := os.ReadFile(path)
+	if err != nil || isBinary(data) || !re.Match(data) {
+		return nil
+	}
+	var entries []qfEntry
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		if loc := re.FindStringIndex(line); loc != nil {
+			entries = append(entries, qfEntry{path, i, loc[0], line})
+		}
+	}
+	return entries
+}
+
+// parseGrepOutput returns the quickfix entries of grepprg output. Lines not
+// of the form file:line:[column:]text are skipped.
+func parseGrepOutput(out []byte) []qfEntry {
+	var entries []qfEntry
+	for _, line := range outputLines(out) {
+		m := grepLineRe.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		n, _ := strconv.Atoi(m[2])
+		col := 1
+		if m[3] != "" {
+			col, _ = strconv.Atoi(m[3])
+		}
+		entries = append(entries, qfEntry{m[1], n - 1, col - 1, m[4]})
+	}
+	return entries
+}
+
+// setQuickfix replaces the quickfix list and shows it, opening the first
+// entry if jump is set.
+func (e *Editor) setQuickfix(title string, entries []qfEntry, jump bool) {
+	e.quickfix, e.qfIdx, e.qfTitle = entries, 0, title
+	if len(entries) == 0 {
+		e.closeQuickfix()
+		e.statusMsg = "No match found"
+		return
+	}
+	e.showQuickfix()
+	if jump {
+		if err := e.quickfixGo(0); err != nil {
+			e.statusMsg = err.Error()
+		}
+		return
+	}
+	files := 0
+	for i, entry := range entries {
+		if i == 0 || entry.file != entries[i-1].file {
+			files++
+		}
+	}
+	e.statusMsg = fmt.Sprintf("%s in %s", plural(len(entries), "match", "matches"), plural(files, "file", "files"))
+}
+
+// quickfixStep opens the entry n places after the current one, or before
+// it if n is negative.
+func (e *Editor) quickfixStep(n int) error {
+	i := e.qfIdx + n
+	if len(e.quickfix) > 0 && (i < 0 || i >= len(e.quickfix)) {
+		return fmt.Errorf("No more items")
+	}
+	return e.quickfixGo(i)
+}
+
+// quickfixGo opens the file of entry i of the quickfix list at its place.
+func (e *Editor) quickfixGo(i int) error {
+	if len(e.quickfix) == 0 {
+		return fmt.Errorf("No quickfix list")
+	}
+	entry := e.quickfix[i]
+	prev := e.buffer
+	if err := e.editFile(entry.file); err != nil {
+		return err
+	}
+	if e.buffer == prev {
+		e.setJump()
+	}
+	e.cy = clamp(entry.line, 0, len(e.buffer.Lines)-1)
+	e.cx = clamp(entry.col, 0, len(e.buffer.Lines[e.cy]))
+	e.clampCursor()
+	e.updateWantCol()
+	e.qfIdx = i
+	e.statusMsg = fmt.Sprintf("(%d of %d): %s", i+1, len(e.quickfix), strings.TrimSpace(entry.text))
+	e.renderQuickfix()
+	return nil
+}
+
+// showQuickfix opens the quickfix pane below the text.
+func (e *Editor) showQuickfix() {
+	e.renderQuickfix()
+	if !e.qfVisible {
+		e.qfVisible = true
+		e.rebuildLayout()
+	}
+}
+
+// closeQuickfix closes the quickfix pane.
+func (e *Editor) closeQuickfix() {
+	if e.mode == ModeQuickfix {
+		e.mode = ModeNormal
+	}
+	if e.qfVisible {
+		e.qfVisible = false
+		e.rebuildLayout()
+	}
+}
+
+// renderQuickfix fills the quickfix pane, with the current entry
+// highlighted and scrolled into view.
+func (e *Editor) renderQuickfix() {
+	var b strings.Builder
+	for i, entry := range e.quickfix {
+		line := tview.Escape(fmt.Sprintf("%s:%d:%d: %s", entry.file, entry.line+1, entry.col+1, entry.text))
+		if i == e.qfIdx {
+			line = e.colorTag("menucolor") + line + "[-:-]"
+		}
+		b.WriteString(line + "\n")
+	}
+	e.quickfixView.SetTitle(fmt.Sprintf(" Quickfix: %s ", e.qfTitle))
+	e.quickfixView.SetText(b.String())
+	_, _, _, height := e.quickfixView.GetInnerRect()
+	top := e.qfIdx - height/2
+	if top < 0 {
+		top = 0
+	}
+	e.quickfixView.ScrollTo(top, 0)
+}
+
+// quickfixKey handles a key in the quickfix pane: j and k (or the arrow
+// keys) select an entry, Enter opens it, Esc goes back to the text and q
+// closes the pane.
+func (e *Editor) quickfixKey(event *tcell.EventKey) {
+	switch {
+	case event.Key() == tcell.KeyDown || event.Key() == tcell.KeyRune && event.Rune() == 'j':
+		e.qfIdx = clamp(e.qfIdx+1, 0, len(e.quickfix)-1)
+		e.renderQuickfix()
+	case event.Key() == tcell.KeyUp || event.Key() == tcell.KeyRune && event.Rune() == 'k':
+		e.qfIdx = clamp(e.qfIdx-1, 0, len(e.quickfix)-1)
+		e.renderQuickfix()
+	case event.Key() == tcell.KeyEnter:
+		e.mode = ModeNormal
+		if err := e.quickfixGo(e.qfIdx); err != nil {
+			e.statusMsg = err.Error()
+		}
+	case event.Key() == tcell.KeyEsc:
+		e.mode = ModeNormal
+	case event.Key() == tcell.KeyRune && event.Rune() == 'q':
+		e.closeQuickfix()
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestGrep(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "a.go"), "package a\n\nfunc Foo() {}\n")
+	writeFile(t, filepath.Join(dir, "b.go"), "package b\n\nvar x = a.Foo()\nvar y = a.Foo\n")
+	writeFile(t, filepath.Join(dir, "data.bin"), "Foo\x00")
+	writeFile(t, filepath.Join(dir, "gen/c.go"), "Foo\n")
+	writeFile(t, filepath.Join(dir, ".gitignore"), "gen/\n")
+	a, b := filepath.Join(dir, "a.go"), filepath.Join(dir, "b.go")
+
+	e := newTestEditor("")
+	runBackground(t, e, "grep Foo "+dir)
+	want := []qfEntry{{a, 2, 5, "func Foo() {}"}, {b, 2, 10, "var x = a.Foo()"}, {b, 3, 10, "var y = a.Foo"}}
+	if !reflect.DeepEqual(e.quickfix, want) {
+		t.Fatalf("quickfix list %v", e.quickfix)
+	}
+	if e.buffer.FilePath != a || e.cy != 2 || e.cx != 5 || e.statusMsg != "(1 of 3): func Foo() {}" || !e.qfVisible {
+		t.Fatalf("first match: %s %d,%d status %q", e.buffer.FilePath, e.cy, e.cx, e.statusMsg)
+	}
+
+	e.exec("cn")
+	e.exec("cn")
+	if e.buffer.FilePath != b || e.cy != 3 || e.cx != 10 {
+		t.Fatalf(":cn: %s %d,%d", e.buffer.FilePath, e.cy, e.cx)
+	}
+	e.exec("cn")
+	if e.statusMsg != "No more items" {
+		t.Fatalf("status %q", e.statusMsg)
+	}
+	e.exec("cp")
+	if e.cy != 2 || e.qfIdx != 1 {
+		t.Fatalf(":cp: cy=%d entry %d", e.cy, e.qfIdx)
+	}
+	typeKeys(e, "<C-o>")
+	if e.cy != 3 {
+		t.Fatalf("moving between entries should set jumps, cy=%d", e.cy)
+	}
+	e.exec("cc 1")
+	if e.buffer.FilePath != a || e.cy != 2 {
+		t.Fatalf(":cc 1: %s %d", e.buffer.FilePath, e.cy)
+	}
+
+	e.exec("copen")
+	typeKeys(e, "jj<CR>")
+	if e.mode != ModeNormal || e.buffer.FilePath != b || e.cy != 3 {
+		t.Fatalf("Enter in the pane: mode %s %s %d", e.mode, e.buffer.FilePath, e.cy)
+	}
+	e.exec("copen")
+	typeKeys(e, "q")
+	if e.mode != ModeNormal || e.qfVisible {
+		t.Fatalf("q should close the pane: mode %s", e.mode)
+	}
+
+	e = newTestEditor("")
+	runBackground(t, e, "grep! 'var [xy]' "+b)
+	if len(e.quickfix) != 2 || e.buffer.FilePath != "" || e.statusMsg != "2 matches in 1 file" {
+		t.Fatalf("grep!: %d entries, status %q", len(e.quickfix), e.statusMsg)
+	}
+	runBackground(t, e, "grep nothing "+dir)
+	if e.statusMsg != "No match found" || e.qfVisible {
+		t.Fatalf("status %q", e.statusMsg)
+	}
+}
+
+func TestGrepPrg(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "a.txt"), "one\ntwo\n")
+	e := newTestEditor("")
+	e.exec(`set grepprg="grep -n $* /dev/null"`)
+	runBackground(t, e, "grep two "+filepath.Join(dir, "a.txt"))
+	want := []qfEntry{{filepath.Join(dir, "a.txt"), 1, 0, "two"}}
+	if !reflect.DeepEqual(e.quickfix, want) || e.cy != 1 {
+		t.Fatalf("quickfix list %v, status %q", e.quickfix, e.statusMsg)
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// --- Walking the Working Tree ---
+
+// ignoreRule is one pattern of a .gitignore file.
+type ignoreRule struct {
+	dir      string // Directory of the .gitignore, relative to the repository root
+	pattern  string
+	negate   bool // A ! pattern, which includes a file again
+	dirOnly  bool // A pattern ending in /, which only matches directories
+	anchored bool // A pattern with a / before its end, which matches the whole path
+}
+
+// parseIgnore returns the rules of a .gitignore file in dir.
+func parseIgnore(dir string, data string) []ignoreRule {
+	var rules []ignoreRule
+	for _, line := range strings.Split(data, "\n") {
+		line = strings.TrimRight(line, " \r")
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		r := ignoreRule{dir: dir}
+		if line[0] == '!' {
+			r.negate, line = true, line[1:]
+		}
+		if strings.HasPrefix(line, `\`) {
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			r.dirOnly, line = true, strings.TrimRight(line, "/")
+		}
+		if strings.Contains(line, "/") {
+			r.anchored, line = true, strings.TrimPrefix(line, "/")
+		}
+		if line == "" {
+			continue
+		}
+		r.pattern = line
+		rules = append(rules, r)
+	}
+	return rules
+}
+
+// matches reports whether the rule matches a path relative to the
+// repository root.
+func (r ignoreRule) matches(rel string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+	if r.dir != "" {
+		if !strings.HasPrefix(rel, r.dir+"/") {
+			return false
+		}
+		rel = rel[len(r.dir)+1:]
+	}
+	if !r.anchored {
+		return globMatch(r.pattern, path.Base(rel))
+	}
+	return globMatch(r.pattern, rel)
+}
+
+// globMatch matches a slash-separated path against a gitignore pattern, in
+// which ** matches any number of directories.
+func globMatch(pattern, name string) bool {
+	return matchParts(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchParts(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchParts(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// ignorer holds the .gitignore rules seen during a walk.
+type ignorer struct {
+	top   string // Root of the repository, or of the walk outside one
+	rules []ignoreRule
+}
+
+// newIgnorer returns an ignorer for a walk of root, with the rules of the
+// .gitignore files in the directories of the repository above it.
+func newIgnorer(root string) *ignorer {
+	abs, err := filepath.Abs(root)
+	if err != nil {
+		abs = root
+	}
+	ig := &ignorer{top: gitRoot(abs)}
+	if ig.top == "" {
+		ig.top = abs
+	}
+	if rel, err := filepath.Rel(ig.top, abs); err == nil && rel != "." {
+		dir := ig.top
+		ig.load(dir)
+		for _, part := range strings.Split(filepath.ToSlash(filepath.Dir(rel)), "/") {
+			if part != "." {
+				dir = filepath.Join(dir, part)
+				ig.load(dir)
+			}
+		}
+	}
+	return ig
+}
+
+// load adds the rules of the .gitignore file in dir, if there is one.
+func (ig *ignorer) load(dir string) {
+	data, err := os.ReadFile(filepath.Join(dir, ".gitignore"))
+	if err != nil {
+		return
+	}
+	rel, err := filepath.Rel(ig.top, dir)
+	if err != nil {
+		return
+	}
+	rel = filepath.ToSlash(rel)
+	if rel == "." {
+		rel = ""
+	}
+	ig.rules = append(ig.rules, parseIgnore(rel, string(data))...)
+}
+
+// ignored reports whether the file or directory at rel, a path relative to
+// the top of the ignorer, is ignored. The last rule that matches decides.
+func (ig *ignorer) ignored(rel string, isDir bool) bool {
+	if isDir && path.Base(rel) == ".git" {
+		return true
+	}
+	ignored := false
+	for _, r := range ig.rules {
+		if r.negate == ignored && r.matches(rel, isDir) {
+			ignored = !r.negate
+		}
+	}
+	return ignored
+}
+
+// errStopWalk ends a walk early.
+var errStopWalk = errors.New("stop walk")
+
+// walkFiles calls fn with the path of each file under root that is not
+// ignored by a .gitignore file, skipping .git directories, until ctx is
+// done or fn returns false. Paths start with root.
+func walkFiles(ctx context.Context, root string, fn func(path string) bool) error {
+	ig := newIgnorer(root)
+	base := ""
+	if abs, err := filepath.Abs(root); err == nil {
+		if rel, err := filepath.Rel(ig.top, abs); err == nil && rel != "." {
+			base = filepath.ToSlash(rel)
+		}
+	}
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if d != nil && d.IsDir() && p != root {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if p != root {
+			rel, _ := filepath.Rel(root, p)
+			if ig.ignored(path.Join(base, filepath.ToSlash(rel)), d.IsDir()) {
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+		}
+		if d.IsDir() {
+			ig.load(p)
+			return nil
+		}
+		if d.Type().IsRegular() && !fn(p) {
+			return errStopWalk
+		}
+		return nil
+	})
+	if err == errStopWalk {
+		return nil
+	}
+	return err
+}
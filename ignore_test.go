@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestWalkFilesIgnoresFiles(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		".gitignore":      "# build output\n*.log\nbuild/\n!keep.log\n/top.txt\ndocs/**/*.tmp\n",
+		".git/config":     "",
+		"a.go":            "",
+		"x.log":           "",
+		"keep.log":        "",
+		"top.txt":         "",
+		"build/out.go":    "",
+		"sub/top.txt":     "",
+		"sub/y.log":       "",
+		"sub/.gitignore":  "secret\n",
+		"sub/secret":      "",
+		"docs/a/b.tmp":    "",
+		"docs/a/b.md":     "",
+		"other/secret.go": "",
+	}
+	for name, text := range files {
+		writeFile(t, filepath.Join(dir, name), text)
+	}
+	walk := func(root string) []string {
+		var got []string
+		if err := walkFiles(context.Background(), root, func(p string) bool {
+			rel, _ := filepath.Rel(dir, p)
+			got = append(got, filepath.ToSlash(rel))
+			return true
+		}); err != nil {
+			t.Fatal(err)
+		}
+		sort.Strings(got)
+		return got
+	}
+
+	want := []string{".gitignore", "a.go", "docs/a/b.md", "keep.log", "other/secret.go", "sub/.gitignore", "sub/top.txt"}
+	if got := walk(dir); !reflect.DeepEqual(got, want) {
+		t.Errorf("walk of the repository: got %q, want %q", got, want)
+	}
+	if got, want := walk(filepath.Join(dir, "sub")), []string{"sub/.gitignore", "sub/top.txt"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("walk of a subdirectory should use the rules above it: got %q, want %q", got, want)
+	}
+}
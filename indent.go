@@ -0,0 +1,199 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// --- Indentation and Auto-Pairing ---
+
+// fileTypes maps file extensions to filetype names.
+var fileTypes = map[string]string{
+	".go": "go", ".c": "c", ".h": "c", ".cpp": "cpp", ".cc": "cpp", ".hpp": "cpp",
+	".java": "java", ".js": "javascript", ".ts": "typescript", ".rs": "rust",
+	".css": "css", ".json": "json", ".py": "python", ".sh": "sh", ".md": "markdown",
+}
+
+// braceFileTypes are the filetypes whose blocks are delimited by braces.
+var braceFileTypes = map[string]bool{
+	"go": true, "c": true, "cpp": true, "java": true, "javascript": true,
+	"typescript": true, "rust": true, "css": true, "json": true,
+}
+
+// autoPairs maps the characters that auto-pairing closes to their closers.
+var autoPairs = map[rune]rune{'(': ')', '[': ']', '{': '}', '"': '"', '\'': '\'', '`': '`'}
+
+// detectFileType returns the filetype for a path from its extension.
+func detectFileType(path string) string {
+	return fileTypes[strings.ToLower(filepath.Ext(path))]
+}
+
+// leadingWhitespace returns the indentation of line.
+func leadingWhitespace(line string) string {
+	return line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+}
+
+// smartIndenting reports whether smart indent applies to the current buffer.
+func (e *Editor) smartIndenting() bool {
+	ft := e.buffer.FileType
+	return e.boolOpt("smartindent") && (braceFileTypes[ft] || ft == "python")
+}
+
+// opensBlock reports whether a new line after line should be indented one
+// level deeper.
+func (e *Editor) opensBlock(line string) bool {
+	line = strings.TrimRightFunc(line, unicode.IsSpace)
+	if line == "" {
+		return false
+	}
+	last := line[len(line)-1]
+	if e.buffer.FileType == "python" {
+		return last == ':'
+	}
+	return last == '{' || last == '(' || last == '['
+}
+
+// newLineIndent returns the indentation for a line opened after prev.
+func (e *Editor) newLineIndent(prev string) string {
+	if !e.boolOpt("autoindent") {
+		return ""
+	}
+	indent := leadingWhitespace(prev)
+	if e.smartIndenting() && e.opensBlock(prev) {
+		indent += e.indentUnit()
+	}
+	return indent
+}
+
+// clearAutoIndent removes indentation that was added automatically to the
+// cursor line if nothing was typed after it.
+func (e *Editor) clearAutoIndent() {
+	if !e.autoIndented {
+		return
+	}
+	e.autoIndented = false
+	if line := e.buffer.Lines[e.cy]; line != "" && strings.TrimSpace(line) == "" {
+		e.buffer.SetLine(e.cy, "")
+		e.cx = 0
+	}
+}
+
+// blockIndent returns the indentation of the line holding the unmatched {
+// before line y, or false if there is none.
+func (e *Editor) blockIndent(y int) (string, bool) {
+	depth := 0
+	for y--; y >= 0; y-- {
+		line := e.buffer.Lines[y]
+		for i := len(line) - 1; i >= 0; i-- {
+			switch line[i] {
+			case '}':
+				depth++
+			case '{':
+				if depth == 0 {
+					return leadingWhitespace(line), true
+				}
+				depth--
+			}
+		}
+	}
+	return "", false
+}
+
+// setIndent replaces the indentation of the cursor line, keeping the cursor
+// on the same text.
+func (e *Editor) setIndent(indent string) {
+	line := e.buffer.Lines[e.cy]
+	old := leadingWhitespace(line)
+	e.buffer.SetLine(e.cy, indent+line[len(old):])
+	e.cx = clamp(e.cx+len(indent)-len(old), len(indent), len(e.buffer.Lines[e.cy]))
+}
+
+// shiftInsertLine changes the indentation of the cursor line by one level
+// while inserting (Ctrl-T, Ctrl-D).
+func (e *Editor) shiftInsertLine(dir int) {
+	e.pushUndo()
+	line := e.buffer.Lines[e.cy]
+	var indent string
+	if dir > 0 {
+		indent = e.indentUnit() + leadingWhitespace(line)
+	} else {
+		indent = leadingWhitespace(e.shiftedLine(line, -1))
+	}
+	old := leadingWhitespace(line)
+	e.buffer.SetLine(e.cy, indent+line[len(old):])
+	e.cx = clamp(e.cx+len(indent)-len(old), 0, len(e.buffer.Lines[e.cy]))
+}
+
+// typeRune inserts a typed character, applying smart indent and auto-pairing.
+func (e *Editor) typeRune(r rune) {
+	line := e.buffer.Lines[e.cy]
+	if e.cx > len(line) {
+		e.cx = len(line)
+	}
+	var next rune
+	if e.cx < len(line) {
+		next, _ = utf8.DecodeRuneInString(line[e.cx:])
+	}
+
+	if e.boolOpt("autopairs") {
+		// Typing a closer that is already there steps over it.
+		if next == r && strings.ContainsRune(")]}\"'`", r) {
+			e.cx += utf8.RuneLen(r)
+			return
+		}
+		if closer, ok := autoPairs[r]; ok && e.canPair(r, line, next) {
+			e.insertRune(r)
+			e.insertText(pos{e.cy, e.cx}, string(closer))
+			return
+		}
+	}
+
+	// A closing brace typed as the first character of a line lines up with
+	// the line that opened the block.
+	if r == '}' && e.smartIndenting() && strings.TrimSpace(line[:e.cx]) == "" {
+		if indent, ok := e.blockIndent(e.cy); ok {
+			e.pushUndo()
+			e.setIndent(indent)
+		} else if line != "" {
+			e.pushUndo()
+			e.setIndent(leadingWhitespace(e.shiftedLine(line, -1)))
+		}
+	}
+	e.insertRune(r)
+}
+
+// canPair reports whether typing opener should also insert its closer: only
+// before whitespace, a closer or the end of the line, and for quotes not
+// right after a word character.
+func (e *Editor) canPair(opener rune, line string, next rune) bool {
+	if next != 0 && !unicode.IsSpace(next) && !strings.ContainsRune(")]}", next) {
+		return false
+	}
+	if autoPairs[opener] == opener && e.cx > 0 {
+		prev, _ := utf8.DecodeLastRuneInString(line[:e.cx])
+		if prev == '_' || unicode.IsLetter(prev) || unicode.IsDigit(prev) {
+			return false
+		}
+	}
+	return true
+}
+
+// deletePair deletes an empty auto-paired opener and closer around the
+// cursor, reporting whether it did.
+func (e *Editor) deletePair() bool {
+	line := e.buffer.Lines[e.cy]
+	if e.cx == 0 || e.cx >= len(line) {
+		return false
+	}
+	prev, size := utf8.DecodeLastRuneInString(line[:e.cx])
+	next, nsize := utf8.DecodeRuneInString(line[e.cx:])
+	if closer, ok := autoPairs[prev]; !ok || closer != next {
+		return false
+	}
+	e.pushUndo()
+	e.buffer.SetLine(e.cy, line[:e.cx-size]+line[e.cx+nsize:])
+	e.cx -= size
+	return true
+}
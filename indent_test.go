@@ -0,0 +1,52 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSmartIndent(t *testing.T) {
+	tests := []struct {
+		lines []string
+		keys  string
+		want  []string
+	}{
+		{[]string{"\tfoo"}, "A<CR>bar<Esc>", []string{"\tfoo", "\tbar"}},
+		{[]string{"func f() {"}, "A<CR>x<CR>}<Esc>", []string{"func f() {", "\tx", "}"}},
+		{[]string{"if x {"}, "ox<Esc>", []string{"if x {", "\tx"}},
+		{[]string{"if x {}"}, "$i<CR>y<Esc>", []string{"if x {", "\ty", "}"}},
+		{[]string{"\tfoo"}, "A<CR><CR>x<Esc>", []string{"\tfoo", "", "\tx"}},
+		{[]string{"\tfoo"}, "o<Esc>", []string{"\tfoo", ""}},
+		{[]string{"foo"}, "A<C-t><Esc>", []string{"\tfoo"}},
+		{[]string{"\t\tfoo"}, "A<C-d><Esc>", []string{"\tfoo"}},
+	}
+	for _, tt := range tests {
+		e := newTestEditor(tt.lines...)
+		e.buffer.FileType = "go"
+		typeKeys(e, tt.keys)
+		if !reflect.DeepEqual(e.buffer.Lines, tt.want) {
+			t.Errorf("%q on %q: got %q, want %q", tt.keys, tt.lines, e.buffer.Lines, tt.want)
+		}
+	}
+}
+
+func TestAutoPairs(t *testing.T) {
+	tests := []struct {
+		keys string
+		want string
+	}{
+		{"if(x)<Esc>", "if(x)"},
+		{"a[<BS>b<Esc>", "ab"},
+		{"s := \"hi\"<Esc>", "s := \"hi\""},
+		{"don't<Esc>", "don't"},
+		{"f({<Esc>", "f({})"},
+	}
+	for _, tt := range tests {
+		e := newTestEditor("")
+		e.exec("set autopairs")
+		typeKeys(e, "i"+tt.keys)
+		if got := e.buffer.Lines[0]; got != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.keys, got, tt.want)
+		}
+	}
+}
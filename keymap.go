@@ -0,0 +1,409 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// --- Key Mappings ---
+
+// keymap maps a key sequence typed in one mode to other keys.
+type keymap struct {
+	lhs     []string // Names of the mapped keys, as given by keyString
+	rhs     string   // Keys the mapping types, in the notation of parseKeys
+	noremap bool     // The keys of rhs are not mapped again
+}
+
+// keyInput is a key waiting to be mapped and run. An entry holding an ex
+// command, from <Cmd>...<CR> in a mapping, runs the command instead.
+type keyInput struct {
+	ev      *tcell.EventKey
+	cmd     string
+	noremap bool // Run the key as it is, without looking up mappings
+}
+
+// mapModes are the modes mappings can be made for: normal, insert (and
+// replace) and the command line (and search).
+const mapModes = "nic"
+
+// maxMapDepth is the number of mappings a key may expand through before it
+// is taken to be a recursive mapping.
+const maxMapDepth = 1000
+
+// defaultKeymaps are the built-in bindings that work in every mode. They are
+// ordinary mappings, so they can be changed or removed with :map and :unmap.
+var defaultKeymaps = []struct{ lhs, rhs string }{
+	{"<C-a>", "<Cmd>chat<CR>"},
+	{"<F2>", "<Cmd>chat<CR>"},
+	{"<C-g>", "<Cmd>chat<CR>"},
+	{"<C-s>", "<Cmd>write<CR>"},
+	{"<C-z>", "<Cmd>undo<CR>"},
+	{"<C-y>", "<Cmd>redo<CR>"},
+	{"<C-c>", "<Cmd>copy<CR>"},
+	{"<C-p>", "<Cmd>find<CR>"},
+}
+
+func init() {
+	registerOption(
+		&option{name: "mapleader", kind: stringOption, def: "\\",
+			help: "Keys that <leader> stands for in mappings"},
+		&option{name: "timeout", short: "to", kind: boolOption, def: true,
+			help: "Stop waiting for the rest of a mapping after timeoutlen"},
+		&option{name: "timeoutlen", short: "tm", kind: numberOption, def: 1000,
+			help: "Milliseconds to wait for the next key of a mapping"},
+	)
+
+	// With ! :map, :noremap and :unmap apply to insert and command-line mode.
+	bangModes := func(modes string, c *exCall) string {
+		if c.bang {
+			return "ic"
+		}
+		return modes
+	}
+	mapCommand := func(modes string, noremap bool) func(e *Editor, c *exCall) error {
+		return func(e *Editor, c *exCall) error {
+			return e.mapKeys(bangModes(modes, c), c.arg, noremap)
+		}
+	}
+	unmapCommand := func(modes string) func(e *Editor, c *exCall) error {
+		return func(e *Editor, c *exCall) error {
+			return e.unmapKeys(bangModes(modes, c), c.arg)
+		}
+	}
+	registerEx(
+		&exCommand{name: "map", bang: true, run: mapCommand("n", false)},
+		&exCommand{name: "noremap", abbrev: "no", bang: true, run: mapCommand("n", true)},
+		&exCommand{name: "nmap", abbrev: "nm", run: mapCommand("n", false)},
+		&exCommand{name: "nnoremap", abbrev: "nn", run: mapCommand("n", true)},
+		&exCommand{name: "imap", abbrev: "im", run: mapCommand("i", false)},
+		&exCommand{name: "inoremap", abbrev: "ino", run: mapCommand("i", true)},
+		&exCommand{name: "cmap", abbrev: "cm", run: mapCommand("c", false)},
+		&exCommand{name: "cnoremap", abbrev: "cno", run: mapCommand("c", true)},
+		&exCommand{name: "unmap", abbrev: "unm", bang: true, run: unmapCommand("n")},
+		&exCommand{name: "nunmap", abbrev: "nun", run: unmapCommand("n")},
+		&exCommand{name: "iunmap", abbrev: "iu", run: unmapCommand("i")},
+		&exCommand{name: "cunmap", abbrev: "cu", run: unmapCommand("c")},
+		&exCommand{name: "mapclear", abbrev: "mapc", bang: true, run: func(e *Editor, c *exCall) error {
+			if strings.TrimSpace(c.arg) != "" {
+				return fmt.Errorf("Trailing characters: %s", c.arg)
+			}
+			modes := bangModes("n", c)
+			for i := range modes {
+				delete(e.keymaps, modes[i])
+			}
+			return nil
+		}},
+	)
+}
+
+// setDefaultKeymaps installs the built-in bindings.
+func (e *Editor) setDefaultKeymaps() {
+	e.keymaps = make(map[byte]map[string]*keymap)
+	for _, m := range defaultKeymaps {
+		for i := range mapModes {
+			e.setKeymap(mapModes[i], m.lhs, m.rhs, true)
+		}
+	}
+}
+
+// mapMode returns the mode whose mappings apply to the next key, or 0 if
+// keys are not mapped: while answering a question, or when the key is the
+// character argument of a normal-mode command such as f or r.
+func (e *Editor) mapMode() byte {
+	switch e.mode {
+	case ModeNormal:
+		if !e.pendingChar {
+			return 'n'
+		}
+	case ModeInsert, ModeReplace:
+		return 'i'
+	case ModeCommand, ModeSearch:
+		return 'c'
+	}
+	return 0
+}
+
+// expandLeader replaces <leader> in a key sequence with the mapleader option.
+func (e *Editor) expandLeader(keys string) string {
+	var b strings.Builder
+	for _, k := range splitKeys(keys) {
+		if strings.EqualFold(k, "<leader>") {
+			k = e.stringOpt("mapleader")
+		}
+		b.WriteString(k)
+	}
+	return b.String()
+}
+
+// keyNames returns the names of the keys of a key sequence.
+func keyNames(keys string) []string {
+	var names []string
+	for _, ev := range parseKeys(keys) {
+		names = append(names, keyString(ev))
+	}
+	return names
+}
+
+// setKeymap maps lhs to rhs in a mode.
+func (e *Editor) setKeymap(mode byte, lhs, rhs string, noremap bool) {
+	names := keyNames(lhs)
+	if e.keymaps[mode] == nil {
+		e.keymaps[mode] = make(map[string]*keymap)
+	}
+	e.keymaps[mode][strings.Join(names, "")] = &keymap{lhs: names, rhs: rhs, noremap: noremap}
+}
+
+// mapKeys handles the arguments of the :map commands: "lhs rhs" maps, a lone
+// lhs lists the mappings starting with it, and no argument lists them all.
+func (e *Editor) mapKeys(modes, arg string, noremap bool) error {
+	arg = strings.TrimLeft(arg, " \t")
+	lhs, rhs := arg, ""
+	if i := strings.IndexAny(arg, " \t"); i >= 0 {
+		lhs, rhs = arg[:i], strings.TrimLeft(arg[i:], " \t")
+	}
+	if rhs == "" {
+		e.listKeymaps(modes, lhs)
+		return nil
+	}
+	// The keys of a mapping run after the config, outside its checks
+	if err := e.checkSecure(); err != nil {
+		return err
+	}
+	lhs, rhs = e.expandLeader(lhs), e.expandLeader(rhs)
+	for i := range modes {
+		e.setKeymap(modes[i], lhs, rhs, noremap)
+	}
+	return nil
+}
+
+// unmapKeys removes the mapping of lhs from modes.
+func (e *Editor) unmapKeys(modes, arg string) error {
+	lhs := strings.TrimSpace(arg)
+	if lhs == "" {
+		return fmt.Errorf("Argument required")
+	}
+	key := strings.Join(keyNames(e.expandLeader(lhs)), "")
+	found := false
+	for i := range modes {
+		if _, ok := e.keymaps[modes[i]][key]; ok {
+			delete(e.keymaps[modes[i]], key)
+			found = true
+		}
+	}
+	if !found {
+		return fmt.Errorf("No such mapping: %s", lhs)
+	}
+	return nil
+}
+
+// listKeymaps shows the mappings of modes whose keys start with prefix in
+// the pane. A * marks mappings whose keys are not mapped again.
+func (e *Editor) listKeymaps(modes, prefix string) {
+	start := strings.Join(keyNames(e.expandLeader(prefix)), "")
+	var lines []string
+	for i := range modes {
+		for lhs, m := range e.keymaps[modes[i]] {
+			if !strings.HasPrefix(lhs, start) {
+				continue
+			}
+			flag := " "
+			if m.noremap {
+				flag = "*"
+			}
+			lines = append(lines, fmt.Sprintf("%c  %-16s %s %s", modes[i], lhs, flag, m.rhs))
+		}
+	}
+	if len(lines) == 0 {
+		e.statusMsg = "No mapping found"
+		return
+	}
+	sort.Strings(lines)
+	e.showPane("Mappings", strings.Join(lines, "\n")+"\n")
+}
+
+// rhsInputs converts the keys of a mapping to typeahead entries. <Nop>
+// stands for no keys.
+func rhsInputs(rhs string, noremap bool) []keyInput {
+	var inputs []keyInput
+	for rhs != "" {
+		i := strings.Index(strings.ToLower(rhs), "<cmd>")
+		if i < 0 {
+			i = len(rhs)
+		}
+		for _, k := range splitKeys(rhs[:i]) {
+			if strings.EqualFold(k, "<Nop>") {
+				continue
+			}
+			for _, ev := range parseKeys(k) {
+				inputs = append(inputs, keyInput{ev: ev, noremap: noremap})
+			}
+		}
+		if i == len(rhs) {
+			break
+		}
+		rest := rhs[i+len("<cmd>"):]
+		end := strings.Index(strings.ToLower(rest), "<cr>")
+		if end < 0 {
+			end = len(rest)
+		}
+		if cmd := rest[:end]; cmd != "" {
+			inputs = append(inputs, keyInput{cmd: cmd})
+		}
+		if end < len(rest) {
+			end += len("<cr>")
+		}
+		rhs = rest[end:]
+	}
+	return inputs
+}
+
+// feedKey takes a typed key, running it once it is known not to start a
+// longer mapping.
+func (e *Editor) feedKey(event *tcell.EventKey) {
+	e.typeahead = append(e.typeahead, keyInput{ev: event})
+	e.processKeys(false)
+}
+
+// runKeys runs keys as if typed, apart from any keys already waiting. With
+// remap set mappings apply, and a mapping cut short at the end is used as if
+// the timeout had passed.
+func (e *Editor) runKeys(keys []*tcell.EventKey, remap bool) {
+	saved, savedDepth, savedUndo := e.typeahead, e.mapDepth, e.mapUndo
+	e.typeahead, e.mapDepth, e.mapUndo = nil, 0, false
+	defer func() { e.typeahead, e.mapDepth, e.mapUndo = saved, savedDepth, savedUndo }()
+	for _, ev := range keys {
+		e.typeahead = append(e.typeahead, keyInput{ev: ev, noremap: !remap})
+	}
+	e.processKeys(true)
+}
+
+// processKeys maps and runs the keys waiting in the typeahead. Keys that
+// could still become a longer mapping are left waiting for the next key, or
+// with timedOut set are resolved with the mappings they complete.
+func (e *Editor) processKeys(timedOut bool) {
+	for len(e.typeahead) > 0 {
+		in := e.typeahead[0]
+		mode := e.mapMode()
+		if in.cmd != "" || in.noremap || len(e.keymaps[mode]) == 0 {
+			e.typeahead = e.typeahead[1:]
+			e.runInput(in)
+			continue
+		}
+
+		var names []string
+		var match *keymap
+		longer := false
+		for _, k := range e.typeahead {
+			if k.cmd != "" || k.noremap {
+				break
+			}
+			names = append(names, keyString(k.ev))
+			m, prefix := e.lookupKeymap(mode, names)
+			if m != nil {
+				match = m
+			}
+			if !prefix {
+				break
+			}
+			longer = len(names) == len(e.typeahead)
+		}
+		if longer && !timedOut {
+			e.startKeyTimer()
+			return
+		}
+		if match == nil {
+			e.typeahead = e.typeahead[1:]
+			e.runInput(in)
+			continue
+		}
+
+		// The keys a mapping runs undo as one step
+		if !e.mapUndo {
+			e.mapUndo = true
+			e.beginUndoGroup()
+		}
+		e.mapDepth++
+		if e.mapDepth > maxMapDepth {
+			e.typeahead, e.mapDepth = nil, 0
+			e.endMapUndo()
+			e.statusMsg = "Recursive mapping: " + strings.Join(match.lhs, "")
+			return
+		}
+		inputs := rhsInputs(match.rhs, match.noremap)
+		if !match.noremap && len(inputs) >= len(match.lhs) {
+			// Keys of the mapping at the start of its own rhs are not mapped again.
+			same := true
+			for i, name := range match.lhs {
+				same = same && inputs[i].ev != nil && keyString(inputs[i].ev) == name
+			}
+			for i := 0; same && i < len(match.lhs); i++ {
+				inputs[i].noremap = true
+			}
+		}
+		e.typeahead = append(inputs, e.typeahead[len(match.lhs):]...)
+	}
+	e.mapDepth = 0
+	e.endMapUndo()
+}
+
+// endMapUndo ends the undo group of the mappings run, if any.
+func (e *Editor) endMapUndo() {
+	if e.mapUndo {
+		e.mapUndo = false
+		e.endUndoGroup()
+	}
+}
+
+// lookupKeymap returns the mapping of the keys, if any, and whether they
+// are the start of a longer mapping.
+func (e *Editor) lookupKeymap(mode byte, names []string) (*keymap, bool) {
+	var match *keymap
+	prefix := false
+	for _, m := range e.keymaps[mode] {
+		if len(m.lhs) < len(names) {
+			continue
+		}
+		same := true
+		for i, n := range names {
+			same = same && m.lhs[i] == n
+		}
+		switch {
+		case !same:
+		case len(m.lhs) == len(names):
+			match = m
+		default:
+			prefix = true
+		}
+	}
+	return match, prefix
+}
+
+// runInput runs one typeahead entry.
+func (e *Editor) runInput(in keyInput) {
+	if in.cmd != "" {
+		e.exec(in.cmd)
+		return
+	}
+	e.dispatchKey(in.ev)
+}
+
+// startKeyTimer resolves the waiting keys after timeoutlen, unless more keys
+// come first.
+func (e *Editor) startKeyTimer() {
+	if !e.boolOpt("timeout") {
+		return
+	}
+	e.keyTimerGen++
+	gen := e.keyTimerGen
+	time.AfterFunc(time.Duration(e.intOpt("timeoutlen"))*time.Millisecond, func() {
+		e.queueUpdate(func() {
+			if gen == e.keyTimerGen {
+				e.processKeys(true)
+			}
+		})
+	})
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// feedKeys types keys through the key mappings, as globalInput does.
+func feedKeys(e *Editor, keys string) {
+	for _, ev := range parseKeys(keys) {
+		e.feedKey(ev)
+	}
+}
+
+func TestKeymaps(t *testing.T) {
+	e := newTestEditor("one", "two", "three", "four")
+	e.exec("set mapleader=,")
+	e.exec("nnoremap <leader>d dd")
+	e.exec("nnoremap X x")
+	e.exec("nmap Y X")
+	e.exec("nnoremap Z X")
+	feedKeys(e, ",d")
+	if want := []string{"two", "three", "four"}; !reflect.DeepEqual(e.buffer.Lines, want) {
+		t.Fatalf("<leader>d: got %q", e.buffer.Lines)
+	}
+	feedKeys(e, "Y")
+	if e.buffer.Lines[0] != "wo" {
+		t.Fatalf("a recursive mapping should map its keys again, got %q", e.buffer.Lines[0])
+	}
+	feedKeys(e, "Z")
+	if e.buffer.Lines[0] != "wo" {
+		t.Fatalf("a non-recursive mapping should run the built-in X, got %q", e.buffer.Lines[0])
+	}
+
+	e.exec("nmap j j")
+	feedKeys(e, "j")
+	if e.cy != 1 || e.statusMsg != "" {
+		t.Fatalf("a mapping starting with its own keys should not recurse: cy=%d status %q", e.cy, e.statusMsg)
+	}
+	e.exec("nmap a b")
+	e.exec("nmap b a")
+	feedKeys(e, "a")
+	if e.statusMsg != "Recursive mapping: a" && e.statusMsg != "Recursive mapping: b" {
+		t.Fatalf("status %q", e.statusMsg)
+	}
+
+	e.exec("inoremap jk <Esc>")
+	feedKeys(e, "Ahijk")
+	if e.mode != ModeNormal || e.buffer.Lines[1] != "threehi" {
+		t.Fatalf("jk should leave insert mode: mode %s line %q", e.mode, e.buffer.Lines[1])
+	}
+	feedKeys(e, "Aj")
+	if len(e.typeahead) != 1 || e.buffer.Lines[1] != "threehi" {
+		t.Fatalf("j should wait for the rest of jk, typeahead %d", len(e.typeahead))
+	}
+	e.processKeys(true)
+	feedKeys(e, "<Esc>")
+	if e.buffer.Lines[1] != "threehij" {
+		t.Fatalf("after the timeout j should be typed, got %q", e.buffer.Lines[1])
+	}
+
+	e.exec("iunmap jk")
+	e.exec("iunmap jk")
+	if e.statusMsg != "No such mapping: jk" {
+		t.Fatalf("status %q", e.statusMsg)
+	}
+}
+
+func TestKeymapCommandsAndDefaults(t *testing.T) {
+	e := newTestEditor("a a", "a")
+	e.exec(`nnoremap \s <Cmd>s/a/b/<CR>j`)
+	feedKeys(e, `\s`)
+	if e.buffer.Lines[0] != "b a" || e.cy != 1 || e.mode != ModeNormal {
+		t.Fatalf("<Cmd> mapping: lines %q cy=%d mode %s", e.buffer.Lines, e.cy, e.mode)
+	}
+	e.exec("nnoremap ; :%s/a/c/g<CR>")
+	feedKeys(e, ";")
+	if !reflect.DeepEqual(e.buffer.Lines, []string{"b c", "c"}) || e.mode != ModeNormal {
+		t.Fatalf(": mapping: lines %q mode %s", e.buffer.Lines, e.mode)
+	}
+
+	feedKeys(e, "<C-z>")
+	if !reflect.DeepEqual(e.buffer.Lines, []string{"b a", "a"}) {
+		t.Fatalf("Ctrl-Z should undo by default, got %q", e.buffer.Lines)
+	}
+	e.exec("nnoremap <C-z> x")
+	feedKeys(e, "j<C-z>")
+	if e.buffer.Lines[e.cy] != "" {
+		t.Fatalf("a mapping should override Ctrl-Z, got %q", e.buffer.Lines)
+	}
+
+	e.exec("nnoremap <C-z> <Nop>")
+	feedKeys(e, "u<C-z>")
+	if e.buffer.Lines[e.cy] != "a" {
+		t.Fatalf("<Nop> should disable Ctrl-Z, got %q", e.buffer.Lines)
+	}
+
+	e.exec("nmap")
+	if text := e.outputView.GetText(false); !strings.Contains(text, "n  <C-a>") || !strings.Contains(text, "n  ;                * :%s/a/c/g<CR>") {
+		t.Fatalf(":nmap listing:\n%s", text)
+	}
+	e.exec("mapclear")
+	e.exec("nmap")
+	if e.statusMsg != "No mapping found" {
+		t.Fatalf("after :mapclear: status %q", e.statusMsg)
+	}
+}
+
+func TestNormalUsesMappings(t *testing.T) {
+	e := newTestEditor("abc", "abc")
+	e.exec("nnoremap x dd")
+	e.exec("1normal x")
+	e.exec("normal! x")
+	if want := []string{"bc"}; !reflect.DeepEqual(e.buffer.Lines, want) {
+		t.Fatalf("got %q", e.buffer.Lines)
+	}
+}
+
+func TestKeymapTimeout(t *testing.T) {
+	e := newTestEditor("abc", "def")
+	done := make(chan func(), 1)
+	e.update = func(f func()) { done <- f }
+	e.exec("set timeoutlen=10")
+	e.exec("nnoremap xx dd")
+	feedKeys(e, "x")
+	(<-done)()
+	if !reflect.DeepEqual(e.buffer.Lines, []string{"bc", "def"}) || len(e.typeahead) != 0 {
+		t.Fatalf("after the timeout x should run alone, got %q", e.buffer.Lines)
+	}
+}
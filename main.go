@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
 func showHelp() {
@@ -13,7 +14,7 @@ func showHelp() {
 	if err != nil {
 		// Fallback to a simple help message
 		fmt.Println("AIR Editor - AI-Integrated Text Editor")
-		fmt.Println("Usage: ./air [filename]")
+		fmt.Println("Usage: ./air [--clean] [filename]")
 		fmt.Println("Press Ctrl+A to toggle AI chat, :q to quit")
 		fmt.Println("For full documentation, see README.md")
 		return
@@ -23,24 +24,39 @@ func showHelp() {
 }
 
 func main() {
-	// Check for help flag
-	if len(os.Args) > 1 && (os.Args[1] == "--help" || os.Args[1] == "-h") {
-		showHelp()
-		return
+	var initialFile string
+	clean := false
+	for _, arg := range os.Args[1:] {
+		switch {
+		case arg == "--help" || arg == "-h":
+			showHelp()
+			return
+		case arg == "--clean":
+			// Start without config files or saved history
+			clean = true
+		case strings.HasPrefix(arg, "-") && arg != "-":
+			fmt.Fprintf(os.Stderr, "Unknown option: %s\n", arg)
+			os.Exit(2)
+		case initialFile == "":
+			initialFile = arg
+		}
 	}
 
 	editor := NewEditor()
-	var initialFile string
-	if len(os.Args) > 1 {
-		initialFile = os.Args[1]
-	}
 
 	buffer, err := NewBuffer(initialFile)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
 	}
-	editor.buffer = buffer
+	editor.switchBuffer(buffer)
+	if !clean {
+		editor.loadConfig()
+		editor.historyPath = historyFile()
+		editor.loadHistory()
+		editor.undoDir = undoFilesDir()
+		editor.loadUndo()
+	}
 
 	if err := editor.Run(); err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
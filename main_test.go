@@ -23,3 +23,18 @@ func TestNewBuffer(t *testing.T) {
 		t.Errorf("Expected unnamed buffer to have BaseName '[No Name]', got: %s", buffer.BaseName())
 	}
 }
+
+// newTestEditor returns an editor on an unnamed buffer holding lines.
+func newTestEditor(lines ...string) *Editor {
+	e := NewEditor()
+	e.buffer = &Buffer{Lines: append([]string(nil), lines...)}
+	return e
+}
+
+// typeKeys feeds keys to the editor as if typed. Special keys use the
+// notation of keyString, e.g. "ifoo<Esc>" or "<C-o>".
+func typeKeys(e *Editor, keys string) {
+	for _, ev := range parseKeys(keys) {
+		e.dispatchKey(ev)
+	}
+}
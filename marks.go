@@ -0,0 +1,242 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// --- Marks and Jump List ---
+
+// mark is a buffer position that moves with line insertions and deletions.
+type mark struct {
+	line, col int
+	deleted   bool // Set when the line holding the mark was deleted
+}
+
+// jump is an entry in the jump list.
+type jump struct {
+	buf *Buffer
+	pos *mark
+}
+
+// maxJumps is the number of entries kept in the jump list.
+const maxJumps = 100
+
+// anchor starts tracking a position so it stays on the same text as lines
+// are inserted and deleted above it.
+func (b *Buffer) anchor(line, col int) *mark {
+	m := &mark{line: line, col: col}
+	b.anchors = append(b.anchors, m)
+	return m
+}
+
+// release stops tracking an anchored position.
+func (b *Buffer) release(m *mark) {
+	for i, a := range b.anchors {
+		if a == m {
+			b.anchors = append(b.anchors[:i], b.anchors[i+1:]...)
+			return
+		}
+	}
+}
+
+// adjustMarks updates tracked positions after lines [start, end) were
+// replaced by added lines. Positions on removed lines are marked deleted,
+// and kept with the undo step to come back when it is undone.
+func (b *Buffer) adjustMarks(start, end, added int) {
+	delta := added - (end - start)
+	for _, m := range b.anchors {
+		switch {
+		case m.line >= end:
+			m.line += delta
+		case m.line >= start && end > start:
+			if !m.deleted {
+				b.saveMark(m)
+			}
+			m.deleted = true
+			m.line = start
+			m.col = 0
+		}
+	}
+}
+
+// setMark sets a named mark, reusing its anchor if it is already tracked.
+func (b *Buffer) setMark(r rune, line, col int) {
+	if b.marks == nil {
+		b.marks = make(map[rune]*mark)
+	}
+	if m, ok := b.marks[r]; ok {
+		m.line, m.col, m.deleted = line, col, false
+		return
+	}
+	b.marks[r] = b.anchor(line, col)
+}
+
+// getMark returns a named mark, or nil if it is not set or its line was deleted.
+func (b *Buffer) getMark(r rune) *mark {
+	m, ok := b.marks[r]
+	if !ok || m.deleted {
+		return nil
+	}
+	return m
+}
+
+// setMarkCommand handles m{a-zA-Z<>}. The < and > marks delimit the '<,'>
+// line range of ex commands.
+func (e *Editor) setMarkCommand(r rune) {
+	switch {
+	case r >= 'a' && r <= 'z', r == '<', r == '>':
+		e.buffer.setMark(r, e.cy, e.cx)
+	case r >= 'A' && r <= 'Z':
+		if b, ok := e.globalMarks[r]; ok && b != e.buffer {
+			delete(b.marks, r)
+		}
+		if e.globalMarks == nil {
+			e.globalMarks = make(map[rune]*Buffer)
+		}
+		e.buffer.setMark(r, e.cy, e.cx)
+		e.globalMarks[r] = e.buffer
+	default:
+		e.statusMsg = "Invalid mark name"
+	}
+}
+
+// markPosition resolves a mark name to its buffer and position. The jump
+// marks ' and ` are the same mark.
+func (e *Editor) markPosition(r rune) (*Buffer, *mark, error) {
+	if r == '`' {
+		r = '\''
+	}
+	b := e.buffer
+	if r >= 'A' && r <= 'Z' {
+		b = e.globalMarks[r]
+	}
+	if b == nil {
+		return nil, nil, fmt.Errorf("Mark not set: %c", r)
+	}
+	m := b.getMark(r)
+	if m == nil {
+		return nil, nil, fmt.Errorf("Mark not set: %c", r)
+	}
+	return b, m, nil
+}
+
+// gotoMark moves to a mark, switching buffers for file marks. With linewise
+// set it goes to the first non-blank of the mark's line instead of its column.
+func (e *Editor) gotoMark(r rune, linewise bool) {
+	b, m, err := e.markPosition(r)
+	if err != nil {
+		e.statusMsg = err.Error()
+		return
+	}
+	line, col := m.line, m.col
+	e.setJump()
+	if b != e.buffer {
+		e.switchBuffer(b)
+	}
+	e.cy = clamp(line, 0, len(e.buffer.Lines)-1)
+	if linewise {
+		e.cx = firstNonBlank(e.buffer.Lines[e.cy])
+	} else {
+		e.cx = clamp(col, 0, len(e.buffer.Lines[e.cy]))
+	}
+}
+
+// setJump records the cursor position in the jump list and in the ' mark,
+// before a command moves the cursor somewhere else.
+func (e *Editor) setJump() {
+	e.buffer.setMark('\'', e.cy, e.cx)
+	e.pushJump()
+}
+
+// pushJump appends the cursor position to the jump list.
+func (e *Editor) pushJump() {
+	// Keep only one entry per line, the most recent.
+	kept := e.jumps[:0]
+	for _, j := range e.jumps {
+		if j.buf == e.buffer && (j.pos.line == e.cy || j.pos.deleted) {
+			j.buf.release(j.pos)
+			continue
+		}
+		kept = append(kept, j)
+	}
+	e.jumps = append(kept, jump{buf: e.buffer, pos: e.buffer.anchor(e.cy, e.cx)})
+	if len(e.jumps) > maxJumps {
+		e.jumps[0].buf.release(e.jumps[0].pos)
+		e.jumps = e.jumps[1:]
+	}
+	e.jumpIdx = len(e.jumps)
+}
+
+// jumpOlder moves count entries back in the jump list (Ctrl-O).
+func (e *Editor) jumpOlder(count int) {
+	if e.jumpIdx >= len(e.jumps) {
+		// Remember where we are so Ctrl-I can come back here.
+		e.pushJump()
+		e.jumpIdx = len(e.jumps) - 1
+	}
+	e.jumpTo(e.jumpIdx - count)
+}
+
+// jumpNewer moves count entries forward in the jump list (Ctrl-I).
+func (e *Editor) jumpNewer(count int) {
+	e.jumpTo(e.jumpIdx + count)
+}
+
+func (e *Editor) jumpTo(idx int) {
+	if idx < 0 || idx >= len(e.jumps) {
+		return
+	}
+	e.jumpIdx = idx
+	j := e.jumps[idx]
+	if j.buf != e.buffer {
+		e.switchBuffer(j.buf)
+	}
+	e.cy = clamp(j.pos.line, 0, len(e.buffer.Lines)-1)
+	e.cx = clamp(j.pos.col, 0, len(e.buffer.Lines[e.cy]))
+}
+
+// switchBuffer makes b the current buffer, remembering the cursor position
+// in the buffer being left in its " mark.
+func (e *Editor) switchBuffer(b *Buffer) {
+	if e.buffer != nil {
+		e.buffer.setMark('"', e.cy, e.cx)
+	}
+	found := false
+	for _, ob := range e.buffers {
+		if ob == b {
+			found = true
+			break
+		}
+	}
+	if !found {
+		e.buffers = append(e.buffers, b)
+	}
+	e.buffer = b
+	e.cy, e.cx = 0, 0
+	if m := b.getMark('"'); m != nil {
+		e.cy = clamp(m.line, 0, len(b.Lines)-1)
+		e.cx = clamp(m.col, 0, len(b.Lines[e.cy]))
+	}
+	e.rowOffset, e.colOffset = 0, 0
+}
+
+// firstNonBlank returns the index of the first non-whitespace character of line.
+func firstNonBlank(line string) int {
+	i := strings.IndexFunc(line, func(r rune) bool { return !unicode.IsSpace(r) })
+	if i < 0 {
+		return len(line)
+	}
+	return i
+}
+
+func clamp(v, lo, hi int) int {
+	if v > hi {
+		v = hi
+	}
+	if v < lo {
+		v = lo
+	}
+	return v
+}
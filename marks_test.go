@@ -0,0 +1,76 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestMarksFollowLineEdits(t *testing.T) {
+	e := newTestEditor("one", "two", "three")
+	typeKeys(e, "jjma")
+	e.buffer.InsertLines(0, "zero", "half")
+	if m := e.buffer.getMark('a'); m == nil || m.line != 4 {
+		t.Fatalf("mark a after insert: %+v", m)
+	}
+	e.buffer.DeleteLines(0, 3)
+	if m := e.buffer.getMark('a'); m == nil || m.line != 1 {
+		t.Fatalf("mark a after delete: %+v", m)
+	}
+	e.buffer.DeleteLines(1, 2)
+	if m := e.buffer.getMark('a'); m != nil {
+		t.Fatalf("mark a should be gone with its line, got %+v", m)
+	}
+}
+
+func TestUndoBringsBackMarks(t *testing.T) {
+	e := newTestEditor("one", "two", "three")
+	typeKeys(e, "jlmaggOzero<Esc>jjdj")
+	if m := e.buffer.getMark('a'); m != nil {
+		t.Fatalf("mark a should be gone with its line, got %+v", m)
+	}
+	e.undo()
+	if m := e.buffer.getMark('a'); m == nil || m.line != 2 || m.col != 1 {
+		t.Fatalf("mark a after undo: %+v", m)
+	}
+	e.redo()
+	if m := e.buffer.getMark('a'); m != nil {
+		t.Fatalf("mark a should be gone again after redo, got %+v", m)
+	}
+	e.undo()
+	e.undo()
+	if m := e.buffer.getMark('a'); m == nil || m.line != 1 || m.col != 1 {
+		t.Fatalf("mark a after undoing both: %+v", m)
+	}
+}
+
+func TestJumpListAcrossBuffers(t *testing.T) {
+	e := newTestEditor("a", "b", "c", "d")
+	first := e.buffer
+	typeKeys(e, "jmAG")
+	if e.cy != 3 {
+		t.Fatalf("G: cy = %d", e.cy)
+	}
+
+	second := &Buffer{Lines: []string{"x", "y"}}
+	e.switchBuffer(second)
+	typeKeys(e, "j'A")
+	if e.buffer != first || e.cy != 1 {
+		t.Fatalf("'A: buffer switched=%v cy=%d", e.buffer == first, e.cy)
+	}
+
+	typeKeys(e, "<C-o>")
+	if e.buffer != second || e.cy != 1 {
+		t.Fatalf("Ctrl-O: buffer second=%v cy=%d", e.buffer == second, e.cy)
+	}
+	typeKeys(e, "<Tab>")
+	if e.buffer != first || e.cy != 1 {
+		t.Fatalf("Ctrl-I: buffer first=%v cy=%d", e.buffer == first, e.cy)
+	}
+	typeKeys(e, "G''")
+	if e.cy != 1 {
+		t.Fatalf("'': cy=%d", e.cy)
+	}
+	typeKeys(e, "''")
+	if e.cy != 3 {
+		t.Fatalf("'' back: cy=%d", e.cy)
+	}
+}
@@ -0,0 +1,544 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// --- Motions ---
+
+// pos is a position in the current buffer.
+type pos struct {
+	line, col int
+}
+
+func (p pos) before(q pos) bool {
+	return p.line < q.line || (p.line == q.line && p.col < q.col)
+}
+
+// motionKind says how an operator treats the text between the cursor and a
+// motion's target.
+type motionKind int
+
+const (
+	exclusive motionKind = iota // Up to, but not including, the target
+	inclusive                   // Up to and including the target character
+	linewise                    // Whole lines from cursor to target
+)
+
+// motion is a cursor movement that can be used on its own or after an operator.
+type motion struct {
+	fn       func(e *Editor, a normalArgs) (pos, bool)
+	kind     motionKind
+	kindFn   func(e *Editor) motionKind // Overrides kind, e.g. for the direction of ;
+	jump     bool                       // Records the start position in the jump list
+	needChar bool                       // Takes the next key as a character argument
+}
+
+// endOfLine is the desired column used after $, so j and k stay at line ends.
+const endOfLine = int(^uint(0) >> 1)
+
+// findState remembers the last f, F, t or T search for ; and ,.
+type findState struct {
+	cmd  rune
+	char rune
+}
+
+var motions map[string]motion
+
+func init() {
+	motions = map[string]motion{
+		"h": {fn: func(e *Editor, a normalArgs) (pos, bool) {
+			return pos{e.cy, clamp(e.cx-a.times(), 0, e.cx)}, e.cx > 0
+		}},
+		"l": {fn: func(e *Editor, a normalArgs) (pos, bool) {
+			n := len(e.buffer.Lines[e.cy])
+			return pos{e.cy, clamp(e.cx+a.times(), 0, n)}, e.cx < n
+		}},
+		"j": {fn: func(e *Editor, a normalArgs) (pos, bool) {
+			return e.verticalTarget(a.times())
+		}, kind: linewise},
+		"k": {fn: func(e *Editor, a normalArgs) (pos, bool) {
+			return e.verticalTarget(-a.times())
+		}, kind: linewise},
+		"0": {fn: func(e *Editor, a normalArgs) (pos, bool) {
+			return pos{e.cy, 0}, true
+		}},
+		"^": {fn: func(e *Editor, a normalArgs) (pos, bool) {
+			return pos{e.cy, firstNonBlank(e.buffer.Lines[e.cy])}, true
+		}},
+		"$": {fn: func(e *Editor, a normalArgs) (pos, bool) {
+			y := clamp(e.cy+a.times()-1, 0, len(e.buffer.Lines)-1)
+			e.wantCol, e.keepWantCol = endOfLine, true
+			return pos{y, lastCol(e.buffer.Lines[y])}, true
+		}, kind: inclusive},
+		"w":  {fn: wordMotion(wordForward, false)},
+		"W":  {fn: wordMotion(wordForward, true)},
+		"b":  {fn: wordMotion(wordBackward, false)},
+		"B":  {fn: wordMotion(wordBackward, true)},
+		"e":  {fn: wordMotion(wordEnd, false), kind: inclusive},
+		"E":  {fn: wordMotion(wordEnd, true), kind: inclusive},
+		"ge": {fn: wordMotion(wordEndBackward, false), kind: inclusive},
+		"gE": {fn: wordMotion(wordEndBackward, true), kind: inclusive},
+		"f":  {fn: findMotion('f'), kind: inclusive, needChar: true},
+		"F":  {fn: findMotion('F'), needChar: true},
+		"t":  {fn: findMotion('t'), kind: inclusive, needChar: true},
+		"T":  {fn: findMotion('T'), needChar: true},
+		";":  {fn: repeatFind(false), kindFn: repeatFindKind(false)},
+		",":  {fn: repeatFind(true), kindFn: repeatFindKind(true)},
+		"n":  {fn: searchMotion(false), jump: true},
+		"N":  {fn: searchMotion(true), jump: true},
+		"%": {fn: func(e *Editor, a normalArgs) (pos, bool) {
+			return e.matchBracket()
+		}, kind: inclusive, jump: true},
+		"}": {fn: func(e *Editor, a normalArgs) (pos, bool) {
+			return e.paragraph(a.times())
+		}, jump: true},
+		"{": {fn: func(e *Editor, a normalArgs) (pos, bool) {
+			return e.paragraph(-a.times())
+		}, jump: true},
+		"H": {fn: func(e *Editor, a normalArgs) (pos, bool) {
+			top, bottom := e.visibleLines()
+			return e.lineStart(clamp(top+a.times()-1, top, bottom)), true
+		}, kind: linewise, jump: true},
+		"M": {fn: func(e *Editor, a normalArgs) (pos, bool) {
+			top, bottom := e.visibleLines()
+			return e.lineStart((top + bottom) / 2), true
+		}, kind: linewise, jump: true},
+		"L": {fn: func(e *Editor, a normalArgs) (pos, bool) {
+			top, bottom := e.visibleLines()
+			return e.lineStart(clamp(bottom-a.times()+1, top, bottom)), true
+		}, kind: linewise, jump: true},
+		"gg": {fn: func(e *Editor, a normalArgs) (pos, bool) {
+			return e.lineStart(clamp(a.times()-1, 0, len(e.buffer.Lines)-1)), true
+		}, kind: linewise, jump: true},
+		"G": {fn: func(e *Editor, a normalArgs) (pos, bool) {
+			if a.count == 0 {
+				return e.lineStart(len(e.buffer.Lines) - 1), true
+			}
+			return e.lineStart(clamp(a.count-1, 0, len(e.buffer.Lines)-1)), true
+		}, kind: linewise, jump: true},
+	}
+}
+
+// moveTo applies a motion to the cursor.
+func (e *Editor) moveTo(m motion, a normalArgs) {
+	p, ok := m.fn(e, a)
+	if !ok {
+		return
+	}
+	if m.jump {
+		e.setJump()
+	}
+	e.cy, e.cx = p.line, p.col
+	e.clampCursor()
+}
+
+// clampCursor keeps the cursor on a character of its line, as normal mode
+// has no position past the end of a line.
+func (e *Editor) clampCursor() {
+	e.cy = clamp(e.cy, 0, len(e.buffer.Lines)-1)
+	if e.mode == ModeNormal {
+		e.cx = clamp(e.cx, 0, lastCol(e.buffer.Lines[e.cy]))
+	}
+}
+
+// updateWantCol remembers the cursor's screen column for j and k, unless the
+// last command was itself a vertical move.
+func (e *Editor) updateWantCol() {
+	if !e.keepWantCol {
+		e.wantCol = displayCol(e.buffer.Lines[e.cy], e.cx, e.intOpt("tabstop"))
+	}
+	e.keepWantCol = false
+}
+
+// lineStart returns the first non-blank position of line y.
+func (e *Editor) lineStart(y int) pos {
+	return pos{y, firstNonBlank(e.buffer.Lines[y])}
+}
+
+// lastCol returns the column of the last character of line, or 0 if it is empty.
+func lastCol(line string) int {
+	if line == "" {
+		return 0
+	}
+	_, size := utf8.DecodeLastRuneInString(line)
+	return len(line) - size
+}
+
+// verticalTarget returns the position delta lines away at the desired column.
+func (e *Editor) verticalTarget(delta int) (pos, bool) {
+	y := clamp(e.cy+delta, 0, len(e.buffer.Lines)-1)
+	if y == e.cy {
+		return pos{}, false
+	}
+	e.keepWantCol = true
+	line := e.buffer.Lines[y]
+	col := colForDisplay(line, e.wantCol, e.intOpt("tabstop"))
+	if e.mode != ModeInsert && col > lastCol(line) {
+		col = lastCol(line)
+	}
+	return pos{y, col}, true
+}
+
+// visibleLines returns the first and last buffer lines shown in the main view.
+func (e *Editor) visibleLines() (int, int) {
+	bottom := e.rowOffset + e.viewHeight() - 1
+	return e.rowOffset, clamp(bottom, e.rowOffset, len(e.buffer.Lines)-1)
+}
+
+// viewHeight returns the number of text rows in the main view.
+func (e *Editor) viewHeight() int {
+	_, _, _, height := e.mainView.GetInnerRect()
+	if height < 1 {
+		return 1
+	}
+	return height
+}
+
+// --- Word Motions ---
+
+// charClass groups characters the way word motions see them: blanks, word
+// characters and punctuation. With big set every non-blank is the same class.
+func charClass(r rune, big bool) int {
+	switch {
+	case unicode.IsSpace(r):
+		return 0
+	case big:
+		return 1
+	case r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r):
+		return 2
+	}
+	return 1
+}
+
+// charAt returns the character at p, or '\n' at the end of a line.
+func (e *Editor) charAt(p pos) rune {
+	line := e.buffer.Lines[p.line]
+	if p.col >= len(line) {
+		return '\n'
+	}
+	r, _ := utf8.DecodeRuneInString(line[p.col:])
+	return r
+}
+
+// nextPos steps one character forward, visiting the end of each line.
+func (e *Editor) nextPos(p pos) (pos, bool) {
+	line := e.buffer.Lines[p.line]
+	if p.col < len(line) {
+		_, size := utf8.DecodeRuneInString(line[p.col:])
+		return pos{p.line, p.col + size}, true
+	}
+	if p.line+1 >= len(e.buffer.Lines) {
+		return p, false
+	}
+	return pos{p.line + 1, 0}, true
+}
+
+// prevPos steps one character backward, visiting the end of each line.
+func (e *Editor) prevPos(p pos) (pos, bool) {
+	if p.col > 0 {
+		line := e.buffer.Lines[p.line]
+		if p.col > len(line) {
+			return pos{p.line, len(line)}, true
+		}
+		_, size := utf8.DecodeLastRuneInString(line[:p.col])
+		return pos{p.line, p.col - size}, true
+	}
+	if p.line == 0 {
+		return p, false
+	}
+	return pos{p.line - 1, len(e.buffer.Lines[p.line-1])}, true
+}
+
+// isEmptyLine reports whether p is on an empty line, which word motions treat as a word.
+func (e *Editor) isEmptyLine(p pos) bool {
+	return p.col == 0 && e.buffer.Lines[p.line] == ""
+}
+
+type wordFunc func(e *Editor, p pos, big bool) pos
+
+func wordMotion(f wordFunc, big bool) func(e *Editor, a normalArgs) (pos, bool) {
+	return func(e *Editor, a normalArgs) (pos, bool) {
+		start := pos{e.cy, e.cx}
+		p := start
+		for i := 0; i < a.times(); i++ {
+			p = f(e, p, big)
+		}
+		return p, p != start
+	}
+}
+
+// wordForward returns the start of the next word (w, W).
+func wordForward(e *Editor, p pos, big bool) pos {
+	cls := charClass(e.charAt(p), big)
+	q, ok := e.nextPos(p)
+	if cls != 0 {
+		for ok && charClass(e.charAt(q), big) == cls {
+			q, ok = e.nextPos(q)
+		}
+	}
+	for ok && charClass(e.charAt(q), big) == 0 && !e.isEmptyLine(q) {
+		q, ok = e.nextPos(q)
+	}
+	// At the end of the buffer this is the end of the last line, so an
+	// operator includes the last character.
+	return q
+}
+
+// wordEnd returns the end of the current or next word (e, E).
+func wordEnd(e *Editor, p pos, big bool) pos {
+	q, ok := e.nextPos(p)
+	for ok && charClass(e.charAt(q), big) == 0 {
+		q, ok = e.nextPos(q)
+	}
+	if !ok {
+		return p
+	}
+	cls := charClass(e.charAt(q), big)
+	for {
+		n, ok := e.nextPos(q)
+		if !ok || charClass(e.charAt(n), big) != cls {
+			return q
+		}
+		q = n
+	}
+}
+
+// wordBackward returns the start of the current or previous word (b, B).
+func wordBackward(e *Editor, p pos, big bool) pos {
+	q, ok := e.prevPos(p)
+	for ok && charClass(e.charAt(q), big) == 0 && !e.isEmptyLine(q) {
+		q, ok = e.prevPos(q)
+	}
+	if !ok {
+		return q
+	}
+	cls := charClass(e.charAt(q), big)
+	if cls == 0 {
+		return q
+	}
+	for {
+		n, ok := e.prevPos(q)
+		if !ok || charClass(e.charAt(n), big) != cls {
+			return q
+		}
+		q = n
+	}
+}
+
+// wordEndBackward returns the end of the previous word (ge, gE).
+func wordEndBackward(e *Editor, p pos, big bool) pos {
+	cls := charClass(e.charAt(p), big)
+	q, ok := e.prevPos(p)
+	if cls != 0 {
+		for ok && charClass(e.charAt(q), big) == cls {
+			q, ok = e.prevPos(q)
+		}
+	}
+	for ok && charClass(e.charAt(q), big) == 0 && !e.isEmptyLine(q) {
+		q, ok = e.prevPos(q)
+	}
+	return q
+}
+
+// --- Character Search ---
+
+func findMotion(cmd rune) func(e *Editor, a normalArgs) (pos, bool) {
+	return func(e *Editor, a normalArgs) (pos, bool) {
+		e.lastFind = findState{cmd: cmd, char: a.char}
+		return e.findChar(cmd, a.char, a.times(), false)
+	}
+}
+
+func repeatFind(reverse bool) func(e *Editor, a normalArgs) (pos, bool) {
+	return func(e *Editor, a normalArgs) (pos, bool) {
+		cmd := e.repeatFindCmd(reverse)
+		if cmd == 0 {
+			return pos{}, false
+		}
+		return e.findChar(cmd, e.lastFind.char, a.times(), true)
+	}
+}
+
+// repeatFindKind returns the kind of ; or , for the way it searches:
+// inclusive forward like f and t, exclusive backward like F and T.
+func repeatFindKind(reverse bool) func(e *Editor) motionKind {
+	return func(e *Editor) motionKind {
+		if cmd := e.repeatFindCmd(reverse); cmd == 'F' || cmd == 'T' {
+			return exclusive
+		}
+		return inclusive
+	}
+}
+
+// repeatFindCmd returns the search ; repeats, or , if reverse is set: the
+// last f, F, t or T, turned around for ,. It is 0 if there was none.
+func (e *Editor) repeatFindCmd(reverse bool) rune {
+	cmd := e.lastFind.cmd
+	if reverse {
+		cmd = map[rune]rune{'f': 'F', 'F': 'f', 't': 'T', 'T': 't'}[cmd]
+	}
+	return cmd
+}
+
+// findChar searches the cursor line for the count'th occurrence of char.
+// f and F land on it, t and T stop next to it. When repeating a t or T
+// that already stopped next to a match, that match is skipped.
+func (e *Editor) findChar(cmd, char rune, count int, repeat bool) (pos, bool) {
+	line := e.buffer.Lines[e.cy]
+	forward := cmd == 'f' || cmd == 't'
+	col := e.cx
+	if repeat && (cmd == 't' || cmd == 'T') {
+		if forward {
+			col++
+		} else {
+			col--
+		}
+	}
+	needle := string(char)
+	for i := 0; i < count; i++ {
+		var idx int
+		if forward {
+			if col+1 > len(line) {
+				return pos{}, false
+			}
+			idx = strings.Index(line[col+1:], needle)
+			if idx >= 0 {
+				idx += col + 1
+			}
+		} else {
+			if col <= 0 {
+				return pos{}, false
+			}
+			idx = strings.LastIndex(line[:clamp(col, 0, len(line))], needle)
+		}
+		if idx < 0 {
+			return pos{}, false
+		}
+		col = idx
+	}
+	switch cmd {
+	case 't':
+		_, size := utf8.DecodeLastRuneInString(line[:col])
+		col -= size
+	case 'T':
+		col += len(needle)
+	}
+	return pos{e.cy, col}, true
+}
+
+// --- Brackets and Paragraphs ---
+
+var bracketPairs = map[byte]byte{'(': ')', '[': ']', '{': '}', ')': '(', ']': '[', '}': '{'}
+
+// matchBracket finds the bracket matching the one under or after the cursor (%).
+func (e *Editor) matchBracket() (pos, bool) {
+	line := e.buffer.Lines[e.cy]
+	col := e.cx
+	for col < len(line) && bracketPairs[line[col]] == 0 {
+		col++
+	}
+	if col >= len(line) {
+		return pos{}, false
+	}
+	open := line[col]
+	close := bracketPairs[open]
+	forward := strings.IndexByte("([{", open) >= 0
+
+	depth := 0
+	p, ok := pos{e.cy, col}, true
+	for ok {
+		if p.col < len(e.buffer.Lines[p.line]) {
+			switch e.buffer.Lines[p.line][p.col] {
+			case open:
+				depth++
+			case close:
+				depth--
+				if depth == 0 {
+					return p, true
+				}
+			}
+		}
+		if forward {
+			p, ok = e.nextPos(p)
+		} else {
+			p, ok = e.prevPos(p)
+		}
+	}
+	return pos{}, false
+}
+
+// paragraph moves count paragraphs forward (positive) or backward to the
+// next empty line, or to the end of the buffer.
+func (e *Editor) paragraph(count int) (pos, bool) {
+	last := len(e.buffer.Lines) - 1
+	y := e.cy
+	step := 1
+	if count < 0 {
+		step, count = -1, -count
+	}
+	empty := func(y int) bool { return e.buffer.Lines[y] == "" }
+	inside := func(y int) bool { return y >= 0 && y <= last }
+	for i := 0; i < count; i++ {
+		// Move off any empty lines, then through the paragraph to the
+		// empty line after it.
+		for inside(y+step) && empty(y) {
+			y += step
+		}
+		for inside(y+step) && !empty(y) {
+			y += step
+		}
+	}
+	if y == e.cy {
+		return pos{}, false
+	}
+	if y == last && !empty(y) && step > 0 {
+		return pos{y, lastCol(e.buffer.Lines[y])}, true
+	}
+	return pos{y, 0}, true
+}
+
+// --- Desired Column ---
+
+// displayCol returns the screen column of byte offset col in line, expanding
+// tabs to the next multiple of ts. Other characters take one column each,
+// as renderLine draws them.
+func displayCol(line string, col, ts int) int {
+	rx := 0
+	for i := 0; i < col; {
+		if i >= len(line) {
+			rx++
+			i++
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(line[i:])
+		if r == '\t' {
+			rx += ts - (rx % ts)
+		} else {
+			rx++
+		}
+		i += size
+	}
+	return rx
+}
+
+// colForDisplay returns the byte offset in line closest to screen column rx
+// without passing it.
+func colForDisplay(line string, rx, ts int) int {
+	cur := 0
+	for col := 0; col < len(line); {
+		r, size := utf8.DecodeRuneInString(line[col:])
+		next := cur + 1
+		if r == '\t' {
+			next = cur + ts - (cur % ts)
+		}
+		if next > rx {
+			return col
+		}
+		cur = next
+		col += size
+	}
+	return len(line)
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestMotions(t *testing.T) {
+	lines := []string{
+		"func foo(a, b int) {",
+		"\treturn a+b",
+		"}",
+		"",
+		"x.y := z",
+	}
+	tests := []struct {
+		keys     string
+		cy, cx   int
+		startPos pos
+	}{
+		{"w", 0, 5, pos{0, 0}},
+		{"3w", 0, 9, pos{0, 0}},
+		{"W", 0, 5, pos{0, 0}},
+		{"e", 0, 3, pos{0, 0}},
+		{"b", 0, 5, pos{0, 8}},
+		{"ge", 0, 3, pos{0, 5}},
+		{"$", 0, 19, pos{0, 0}},
+		{"^", 1, 1, pos{1, 5}},
+		{"0", 1, 0, pos{1, 5}},
+		{"fb", 0, 12, pos{0, 0}},
+		{"ta", 0, 8, pos{0, 0}},
+		{"f,;", 0, 10, pos{0, 0}},
+		{"F(", 0, 8, pos{0, 15}},
+		{"%", 0, 17, pos{0, 0}},
+		{"%", 2, 0, pos{0, 19}},
+		{"%", 0, 19, pos{2, 0}},
+		{"}", 3, 0, pos{0, 0}},
+		{"}}", 4, 7, pos{0, 0}},
+		{"{", 3, 0, pos{4, 3}},
+		{"w", 3, 0, pos{2, 0}},
+		{"W", 4, 4, pos{4, 0}},
+		{"2G", 1, 1, pos{0, 0}},
+		{"G", 4, 0, pos{0, 3}},
+	}
+	for _, tt := range tests {
+		e := newTestEditor(lines...)
+		e.cy, e.cx = tt.startPos.line, tt.startPos.col
+		typeKeys(e, tt.keys)
+		if e.cy != tt.cy || e.cx != tt.cx {
+			t.Errorf("%q from %v: got %d:%d, want %d:%d", tt.keys, tt.startPos, e.cy, e.cx, tt.cy, tt.cx)
+		}
+	}
+}
+
+func TestDesiredColumn(t *testing.T) {
+	e := newTestEditor("abcdefgh", "ab", "abcdefgh")
+	typeKeys(e, "5ljj")
+	if e.cy != 2 || e.cx != 5 {
+		t.Fatalf("got %d:%d, want 2:5", e.cy, e.cx)
+	}
+	typeKeys(e, "$k")
+	if e.cy != 1 || e.cx != 1 {
+		t.Fatalf("got %d:%d, want 1:1", e.cy, e.cx)
+	}
+
+	// Columns count characters, not bytes
+	e = newTestEditor("abc", "héllo")
+	typeKeys(e, "lljx")
+	if e.buffer.Lines[1] != "hélo" {
+		t.Fatalf("got %q, want %q", e.buffer.Lines[1], "hélo")
+	}
+	typeKeys(e, "lk")
+	if e.cy != 0 || e.cx != 2 {
+		t.Fatalf("got %d:%d, want 0:2", e.cy, e.cx)
+	}
+}
+
+func TestOperators(t *testing.T) {
+	tests := []struct {
+		lines []string
+		keys  string
+		want  []string
+	}{
+		{[]string{"one two three"}, "dw", []string{"two three"}},
+		{[]string{"one two three"}, "2dw", []string{"three"}},
+		{[]string{"one two three"}, "wd$", []string{"one "}},
+		{[]string{"one two three"}, "cwuno<Esc>", []string{"uno two three"}},
+		{[]string{"a(b, c)d"}, "fbdt)", []string{"a()d"}},
+		{[]string{"a,b,c,d"}, "f,d;", []string{"ac,d"}},
+		{[]string{"a,b,c,d"}, "$F,d;", []string{"a,b,d"}},
+		{[]string{"a,b,c,d"}, "f,;d,", []string{"a,c,d"}},
+		{[]string{"a,b,c,d"}, "$F,;d,", []string{"a,bd"}},
+		{[]string{"a", "b", "c"}, "jdd", []string{"a", "c"}},
+		{[]string{"a", "b", "c"}, "2dd", []string{"c"}},
+		{[]string{"a", "b", "c"}, "dj", []string{"c"}},
+		{[]string{"a", "b", "c"}, "Gdk", []string{"a"}},
+		{[]string{"one", "two"}, "wdw", []string{"one", ""}},
+		{[]string{"one two"}, "wD", []string{"one "}},
+		{[]string{"one two", "x"}, "ccnew<Esc>", []string{"new", "x"}},
+		{[]string{"one two three"}, "dw.", []string{"three"}},
+	}
+	for _, tt := range tests {
+		e := newTestEditor(tt.lines...)
+		typeKeys(e, tt.keys)
+		if len(e.buffer.Lines) != len(tt.want) {
+			t.Errorf("%q: got %q, want %q", tt.keys, e.buffer.Lines, tt.want)
+			continue
+		}
+		for i := range tt.want {
+			if e.buffer.Lines[i] != tt.want[i] {
+				t.Errorf("%q: got %q, want %q", tt.keys, e.buffer.Lines, tt.want)
+				break
+			}
+		}
+	}
+}
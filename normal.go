@@ -0,0 +1,430 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// --- Normal Mode Commands ---
+
+// normalArgs carries the count and character argument of a normal-mode command.
+type normalArgs struct {
+	count int  // 0 when no count was typed
+	char  rune // Argument of commands such as m and '
+}
+
+// times returns the count, defaulting to 1.
+func (a normalArgs) times() int {
+	if a.count == 0 {
+		return 1
+	}
+	return a.count
+}
+
+// normalCommand is a built-in normal-mode command bound to a key sequence.
+type normalCommand struct {
+	fn       func(e *Editor, a normalArgs)
+	noDot    bool // Never recorded as the last change (undo, redo and "." itself)
+	needChar bool // Takes the next key as a character argument
+}
+
+var (
+	normalCommands map[string]normalCommand
+	normalPrefixes map[string]bool // Proper prefixes of multi-key commands
+)
+
+func init() {
+	normalCommands = map[string]normalCommand{
+		"i": {fn: func(e *Editor, a normalArgs) { e.startInsert(a.count) }},
+		"a": {fn: func(e *Editor, a normalArgs) {
+			if e.buffer.Lines[e.cy] != "" {
+				e.cx, _ = e.nextCol(e.cx)
+			}
+			e.startInsert(a.count)
+		}},
+		"A": {fn: func(e *Editor, a normalArgs) {
+			e.cx = len(e.buffer.Lines[e.cy])
+			e.startInsert(a.count)
+		}},
+		"I": {fn: func(e *Editor, a normalArgs) {
+			e.cx = firstNonBlank(e.buffer.Lines[e.cy])
+			e.startInsert(a.count)
+		}},
+		"o":  {fn: func(e *Editor, a normalArgs) { e.openLine(true, a.count) }},
+		"O":  {fn: func(e *Editor, a normalArgs) { e.openLine(false, a.count) }},
+		"R":  {fn: func(e *Editor, a normalArgs) { e.startReplace(a.count) }},
+		"J":  {fn: func(e *Editor, a normalArgs) { e.joinLines(a.count, true) }},
+		"gJ": {fn: func(e *Editor, a normalArgs) { e.joinLines(a.count, false) }},
+		"r":  {fn: func(e *Editor, a normalArgs) { e.replaceChars(a.char, a.times()) }, needChar: true},
+		"~":  {fn: func(e *Editor, a normalArgs) { e.toggleCase(a.times()) }},
+		"p":  {fn: func(e *Editor, a normalArgs) { e.put(true, a.times()) }},
+		"P":  {fn: func(e *Editor, a normalArgs) { e.put(false, a.times()) }},
+		":": {fn: func(e *Editor, a normalArgs) {
+			e.mode = ModeCommand
+			e.commandInput.SetText(":")
+			e.app.SetFocus(e.commandInput)
+		}},
+		"/": {fn: func(e *Editor, a normalArgs) { e.startSearch("/") }},
+		"?": {fn: func(e *Editor, a normalArgs) { e.startSearch("?") }},
+		"u": {fn: func(e *Editor, a normalArgs) {
+			for i := 0; i < a.times(); i++ {
+				e.undo()
+			}
+		}, noDot: true},
+		"g-":    {fn: func(e *Editor, a normalArgs) { e.undoChrono(-a.times()) }, noDot: true},
+		"g+":    {fn: func(e *Editor, a normalArgs) { e.undoChrono(a.times()) }, noDot: true},
+		"x":     {fn: func(e *Editor, a normalArgs) { e.applyOperator("d", "l", a) }},
+		".":     {fn: func(e *Editor, a normalArgs) { e.repeatLastChange(a.count) }, noDot: true},
+		"m":     {fn: func(e *Editor, a normalArgs) { e.setMarkCommand(a.char) }, needChar: true},
+		"'":     {fn: func(e *Editor, a normalArgs) { e.gotoMark(a.char, true) }, needChar: true},
+		"`":     {fn: func(e *Editor, a normalArgs) { e.gotoMark(a.char, false) }, needChar: true},
+		"<C-o>": {fn: func(e *Editor, a normalArgs) { e.jumpOlder(a.times()) }},
+		"<Tab>": {fn: func(e *Editor, a normalArgs) { e.jumpNewer(a.times()) }},
+		"D":     {fn: func(e *Editor, a normalArgs) { e.applyOperator("d", "$", a) }},
+		"C":     {fn: func(e *Editor, a normalArgs) { e.applyOperator("c", "$", a) }},
+		"Y":     {fn: func(e *Editor, a normalArgs) { opYank(e, e.lineRange(a.times())) }},
+		"<C-d>": {fn: func(e *Editor, a normalArgs) { e.scrollHalfPage(1, a.count) }},
+		"<C-u>": {fn: func(e *Editor, a normalArgs) { e.scrollHalfPage(-1, a.count) }},
+		"<C-f>": {fn: func(e *Editor, a normalArgs) { e.scroll(a.times() * e.pageSize()) }},
+		"<C-b>": {fn: func(e *Editor, a normalArgs) { e.scroll(-a.times() * e.pageSize()) }},
+	}
+
+	normalPrefixes = make(map[string]bool)
+	addPrefixes := func(seq string) {
+		keys := splitKeys(seq)
+		for i := 1; i < len(keys); i++ {
+			normalPrefixes[strings.Join(keys[:i], "")] = true
+		}
+	}
+	for seq := range normalCommands {
+		addPrefixes(seq)
+	}
+	for seq := range operators {
+		addPrefixes(seq)
+	}
+	for seq := range motions {
+		addPrefixes(seq)
+	}
+}
+
+// splitKeys splits a key sequence into its keys, treating a <...> name as one key.
+func splitKeys(seq string) []string {
+	var keys []string
+	for len(seq) > 0 {
+		if seq[0] == '<' {
+			if end := strings.IndexByte(seq, '>'); end > 1 {
+				keys = append(keys, seq[:end+1])
+				seq = seq[end+1:]
+				continue
+			}
+		}
+		_, size := utf8.DecodeRuneInString(seq)
+		keys = append(keys, seq[:size])
+		seq = seq[size:]
+	}
+	return keys
+}
+
+// keyString returns the name used for a key in normal-mode key sequences,
+// using Vim's notation for special keys (<C-o>, <Tab>, <CR>).
+func keyString(ev *tcell.EventKey) string {
+	switch k := ev.Key(); {
+	case k == tcell.KeyRune:
+		return string(ev.Rune())
+	case k == tcell.KeyTab:
+		return "<Tab>"
+	case k == tcell.KeyEnter:
+		return "<CR>"
+	case k == tcell.KeyEsc:
+		return "<Esc>"
+	case k == tcell.KeyBackspace || k == tcell.KeyBackspace2:
+		return "<BS>"
+	case k >= tcell.KeyCtrlA && k <= tcell.KeyCtrlZ:
+		return fmt.Sprintf("<C-%c>", 'a'+rune(k-tcell.KeyCtrlA))
+	}
+	return "<" + tcell.KeyNames[ev.Key()] + ">"
+}
+
+// parseKeys converts a key sequence in the notation of keyString to key
+// events. A <...> group that is not a key name stands for its characters.
+func parseKeys(seq string) []*tcell.EventKey {
+	var events []*tcell.EventKey
+	for _, k := range splitKeys(seq) {
+		if ev := namedKey(k); ev != nil {
+			events = append(events, ev)
+			continue
+		}
+		for _, r := range k {
+			events = append(events, tcell.NewEventKey(tcell.KeyRune, r, tcell.ModNone))
+		}
+	}
+	return events
+}
+
+// namedKey returns the event for a key name such as <Esc> or <C-w>, or nil.
+func namedKey(k string) *tcell.EventKey {
+	if len(k) < 3 || k[0] != '<' || k[len(k)-1] != '>' {
+		return nil
+	}
+	name := k[1 : len(k)-1]
+	switch strings.ToLower(name) {
+	case "esc":
+		return tcell.NewEventKey(tcell.KeyEsc, 0, tcell.ModNone)
+	case "cr", "enter", "return":
+		return tcell.NewEventKey(tcell.KeyEnter, 0, tcell.ModNone)
+	case "tab":
+		return tcell.NewEventKey(tcell.KeyTab, 0, tcell.ModNone)
+	case "s-tab":
+		return tcell.NewEventKey(tcell.KeyBacktab, 0, tcell.ModShift)
+	case "bs":
+		return tcell.NewEventKey(tcell.KeyBackspace2, 0, tcell.ModNone)
+	case "space":
+		return tcell.NewEventKey(tcell.KeyRune, ' ', tcell.ModNone)
+	case "lt":
+		return tcell.NewEventKey(tcell.KeyRune, '<', tcell.ModNone)
+	case "bar":
+		return tcell.NewEventKey(tcell.KeyRune, '|', tcell.ModNone)
+	}
+	if len(name) == 3 && (name[:2] == "C-" || name[:2] == "c-") {
+		if r := unicode.ToLower(rune(name[2])); r >= 'a' && r <= 'z' {
+			return tcell.NewEventKey(tcell.KeyCtrlA+tcell.Key(r-'a'), 0, tcell.ModCtrl)
+		}
+	}
+	for key, n := range tcell.KeyNames {
+		if strings.EqualFold(n, name) {
+			return tcell.NewEventKey(key, 0, tcell.ModNone)
+		}
+	}
+	return nil
+}
+
+// feedNormal processes one key of a normal-mode command, running the
+// command once its key sequence is complete. A command is a built-in
+// command, a motion, or an operator followed by a motion.
+func (e *Editor) feedNormal(event *tcell.EventKey) {
+	if event.Key() == tcell.KeyEsc {
+		e.resetNormal()
+		e.closePane()
+		return
+	}
+
+	// Digits build up a count, also between an operator and its motion;
+	// "0" alone is a motion.
+	if e.pending == "" && !e.pendingChar && event.Key() == tcell.KeyRune {
+		if r := event.Rune(); r >= '1' && r <= '9' || (r == '0' && e.count > 0) {
+			e.count = e.count*10 + int(r-'0')
+			return
+		}
+	}
+
+	if len(e.cmdKeys) == 0 {
+		e.cmdTick = e.buffer.changedTick
+		e.beginCommandUndo()
+	}
+	e.cmdKeys = append(e.cmdKeys, event)
+
+	var seq string
+	var char rune
+	if e.pendingChar {
+		// The key is the character argument of the pending command.
+		if event.Key() != tcell.KeyRune {
+			e.resetNormal()
+			return
+		}
+		seq, char = e.pending, event.Rune()
+		e.pendingChar = false
+	} else {
+		seq = e.pending + keyString(event)
+	}
+
+	if e.operator != "" {
+		e.feedOperator(seq, char)
+		return
+	}
+
+	if cmd, ok := normalCommands[seq]; ok {
+		if cmd.needChar && char == 0 {
+			e.pending, e.pendingChar = seq, true
+			return
+		}
+		args := normalArgs{count: e.count, char: char}
+		e.cmdCount = e.count
+		e.pending, e.count = "", 0
+		cmd.fn(e, args)
+		e.completeCommand(!cmd.noDot)
+		return
+	}
+	if _, ok := operators[seq]; ok {
+		e.operator, e.opCount = seq, e.count
+		e.pending, e.count = "", 0
+		return
+	}
+	if m, ok := motions[seq]; ok {
+		if m.needChar && char == 0 {
+			e.pending, e.pendingChar = seq, true
+			return
+		}
+		args := normalArgs{count: e.count, char: char}
+		e.pending, e.count = "", 0
+		e.moveTo(m, args)
+		e.completeCommand(false)
+		return
+	}
+	if normalPrefixes[seq] {
+		e.pending = seq
+		return
+	}
+	e.resetNormal()
+}
+
+// feedOperator resolves the motion of a pending operator. Repeating the
+// operator's key, as in dd, acts on whole lines.
+func (e *Editor) feedOperator(seq string, char rune) {
+	count := e.count
+	if e.opCount > 0 {
+		count = e.opCount * e.times(e.count)
+	}
+	op := e.operator
+
+	if seq == op {
+		e.cmdCount = count
+		e.pending, e.count, e.operator = "", 0, ""
+		operators[op](e, e.lineRange(normalArgs{count: count}.times()))
+		e.completeCommand(true)
+		return
+	}
+	m, ok := motions[seq]
+	if !ok {
+		if normalPrefixes[seq] {
+			e.pending = seq
+		} else {
+			e.resetNormal()
+		}
+		return
+	}
+	if m.needChar && char == 0 {
+		e.pending, e.pendingChar = seq, true
+		return
+	}
+	e.cmdCount = count
+	e.pending, e.count, e.operator = "", 0, ""
+	e.applyOperator(op, seq, normalArgs{count: count, char: char})
+	e.completeCommand(true)
+}
+
+// applyOperator applies an operator to the text covered by the motion bound to seq.
+func (e *Editor) applyOperator(op, seq string, a normalArgs) {
+	// cw on a word changes to the end of the word, like ce.
+	if op == "c" && (seq == "w" || seq == "W") && charClass(e.charAt(pos{e.cy, e.cx}), false) != 0 {
+		seq = map[string]string{"w": "e", "W": "E"}[seq]
+	}
+	r, ok := e.motionRange(motions[seq], a)
+	if !ok {
+		return
+	}
+	operators[op](e, r)
+}
+
+// completeCommand finishes a normal-mode command. A command that started an
+// insert session completes when the session ends.
+func (e *Editor) completeCommand(record bool) {
+	if e.mode == ModeInsert || e.mode == ModeReplace {
+		return
+	}
+	if e.mode == ModeNormal {
+		e.clampCursor()
+	}
+	e.updateWantCol()
+	e.finishChange(record)
+}
+
+// times returns n, or 1 when no count was given.
+func (e *Editor) times(n int) int {
+	return normalArgs{count: n}.times()
+}
+
+// resetNormal discards any partially typed normal-mode command.
+func (e *Editor) resetNormal() {
+	e.pending = ""
+	e.pendingChar = false
+	e.operator = ""
+	e.opCount = 0
+	e.count = 0
+	e.cmdKeys = nil
+	e.endCommandUndo()
+}
+
+// scroll moves the view and the cursor by delta lines (Ctrl-F, Ctrl-B).
+func (e *Editor) scroll(delta int) {
+	last := len(e.buffer.Lines) - 1
+	if (delta > 0 && e.cy == last) || (delta < 0 && e.cy == 0) {
+		return
+	}
+	e.rowOffset = clamp(e.rowOffset+delta, 0, last)
+	e.cy = clamp(e.cy+delta, 0, last)
+	e.cx = firstNonBlank(e.buffer.Lines[e.cy])
+}
+
+// scrollHalfPage scrolls half a screen in direction dir (Ctrl-D, Ctrl-U).
+// A count sets the scroll option, the number of lines to scroll for later
+// uses as well.
+func (e *Editor) scrollHalfPage(dir, count int) {
+	if count > 0 {
+		e.setOptionValue(options["scroll"], count, true)
+	}
+	n := e.intOpt("scroll")
+	if n == 0 {
+		n = (e.viewHeight() + 1) / 2
+	}
+	e.scroll(dir * n)
+}
+
+// pageSize returns the number of lines Ctrl-F and Ctrl-B scroll, keeping two
+// lines of context.
+func (e *Editor) pageSize() int {
+	if h := e.viewHeight(); h > 2 {
+		return h - 2
+	}
+	return 1
+}
+
+// startInsert enters insert mode. With a count, the text typed during the
+// session is inserted that many times when the session ends.
+func (e *Editor) startInsert(count int) {
+	e.mode = ModeInsert
+	e.insertCount = count
+	e.insertFrom = len(e.cmdKeys)
+	e.insertAgain = nil
+}
+
+// stopInsert leaves insert mode, applying the count of the session and
+// completing the command that started it.
+func (e *Editor) stopInsert() {
+	if e.insertCount > 1 && e.insertFrom <= len(e.cmdKeys)-1 {
+		// The last recorded key is the Esc that ended the session.
+		keys := e.cmdKeys[e.insertFrom : len(e.cmdKeys)-1]
+		for i := 1; i < e.insertCount; i++ {
+			if e.insertAgain != nil {
+				e.insertAgain()
+			}
+			for _, k := range keys {
+				e.sessionKey(k)
+			}
+		}
+	}
+	e.insertCount = 0
+	e.insertAgain = nil
+	e.replaced = nil
+	e.clearAutoIndent()
+	e.mode = ModeNormal
+	e.buffer.setMark('^', e.cy, e.cx)
+	// Leaving insert mode puts the cursor back on the last inserted character.
+	if p, ok := e.prevPos(pos{e.cy, e.cx}); ok && p.line == e.cy {
+		e.cx = p.col
+	}
+	e.completeCommand(true)
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// --- Operators ---
+
+// textRange is the text an operator acts on. For a charwise range end is
+// exclusive; for a linewise range start and end are the first and last lines.
+type textRange struct {
+	start, end pos
+	linewise   bool
+}
+
+// operatorFunc applies an operator such as d, c or y to a range.
+type operatorFunc func(e *Editor, r textRange)
+
+var operators map[string]operatorFunc
+
+func init() {
+	operators = map[string]operatorFunc{
+		"d": opDelete,
+		"c": opChange,
+		"y": opYank,
+		">": func(e *Editor, r textRange) { e.shiftLines(r.start.line, r.end.line, 1) },
+		"<": func(e *Editor, r textRange) { e.shiftLines(r.start.line, r.end.line, -1) },
+	}
+}
+
+// motionRange returns the range an operator covers when m is applied from the cursor.
+func (e *Editor) motionRange(m motion, a normalArgs) (textRange, bool) {
+	start := pos{e.cy, e.cx}
+	target, ok := m.fn(e, a)
+	if !ok {
+		return textRange{}, false
+	}
+	kind := m.kind
+	if m.kindFn != nil {
+		kind = m.kindFn(e)
+	}
+	r := textRange{start: start, end: target, linewise: kind == linewise}
+	if target.before(start) {
+		r.start, r.end = target, start
+	}
+	switch {
+	case r.linewise:
+		r.start.col, r.end.col = 0, 0
+	case kind == inclusive:
+		if r.end.col < len(e.buffer.Lines[r.end.line]) {
+			r.end, _ = e.nextPos(r.end)
+		}
+	case r.end.col == 0 && r.end.line > r.start.line:
+		// An exclusive motion that ends at the start of a line stops at the
+		// end of the previous one, and covers whole lines if it started
+		// before the first non-blank.
+		r.end = pos{r.end.line - 1, len(e.buffer.Lines[r.end.line-1])}
+		if r.start.col <= firstNonBlank(e.buffer.Lines[r.start.line]) {
+			r.linewise = true
+			r.start.col, r.end.col = 0, 0
+		}
+	}
+	return r, true
+}
+
+// lineRange returns the linewise range of count lines from the cursor, as
+// used by a doubled operator such as dd.
+func (e *Editor) lineRange(count int) textRange {
+	last := clamp(e.cy+count-1, e.cy, len(e.buffer.Lines)-1)
+	return textRange{start: pos{e.cy, 0}, end: pos{last, 0}, linewise: true}
+}
+
+// rangeText returns the text of a range. Linewise text ends with a newline.
+func (e *Editor) rangeText(r textRange) string {
+	lines := e.buffer.Lines
+	if r.linewise {
+		return strings.Join(lines[r.start.line:r.end.line+1], "\n") + "\n"
+	}
+	if r.start.line == r.end.line {
+		return lines[r.start.line][r.start.col:r.end.col]
+	}
+	parts := []string{lines[r.start.line][r.start.col:]}
+	parts = append(parts, lines[r.start.line+1:r.end.line]...)
+	parts = append(parts, lines[r.end.line][:r.end.col])
+	return strings.Join(parts, "\n")
+}
+
+// deleteRange removes the text of a range from the buffer.
+func (e *Editor) deleteRange(r textRange) {
+	if r.linewise {
+		e.buffer.DeleteLines(r.start.line, r.end.line+1)
+		return
+	}
+	lines := e.buffer.Lines
+	e.buffer.SetLine(r.start.line, lines[r.start.line][:r.start.col]+lines[r.end.line][r.end.col:])
+	e.buffer.DeleteLines(r.start.line+1, r.end.line+1)
+}
+
+// setClipboard stores text for pasting, remembering whether it is whole lines.
+func (e *Editor) setClipboard(text string, linewise bool) {
+	e.clipboard = text
+	e.clipboardLinewise = linewise
+}
+
+func opDelete(e *Editor, r textRange) {
+	e.pushUndo()
+	e.setClipboard(e.rangeText(r), r.linewise)
+	e.deleteRange(r)
+	if r.linewise {
+		e.cy = clamp(r.start.line, 0, len(e.buffer.Lines)-1)
+		e.cx = firstNonBlank(e.buffer.Lines[e.cy])
+	} else {
+		e.cy, e.cx = r.start.line, r.start.col
+	}
+	e.clampCursor()
+}
+
+func opChange(e *Editor, r textRange) {
+	e.pushUndo()
+	e.setClipboard(e.rangeText(r), r.linewise)
+	if r.linewise {
+		// Keep one empty line to type the replacement on.
+		e.buffer.DeleteLines(r.start.line+1, r.end.line+1)
+		e.buffer.SetLine(r.start.line, "")
+		e.cy, e.cx = r.start.line, 0
+	} else {
+		e.deleteRange(r)
+		e.cy, e.cx = r.start.line, r.start.col
+	}
+	e.startInsert(0)
+}
+
+func opYank(e *Editor, r textRange) {
+	e.setClipboard(e.rangeText(r), r.linewise)
+	if n := r.end.line - r.start.line + 1; r.linewise && n > 2 {
+		e.statusMsg = fmt.Sprintf("%d lines yanked", n)
+	}
+	e.cy = r.start.line
+	if !r.linewise {
+		e.cx = r.start.col
+	}
+}
@@ -0,0 +1,391 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// --- Options ---
+
+// optionKind is the type of an option's value.
+type optionKind int
+
+const (
+	boolOption   optionKind = iota // bool
+	numberOption                   // int
+	stringOption                   // string
+	enumOption                     // string, one of the option's values
+)
+
+// optionScope says where an option's value is kept.
+type optionScope int
+
+const (
+	globalScope optionScope = iota // One value for the whole editor
+	bufferScope                    // Each buffer can have its own value
+	windowScope                    // The text window can have its own value
+)
+
+// option describes a setting changed with :set.
+type option struct {
+	name   string
+	short  string // Abbreviation, e.g. "ts" for tabstop
+	kind   optionKind
+	scope  optionScope
+	def    interface{} // Default value, of the type given by kind
+	values []string    // Allowed values of an enum option
+	min    int         // Smallest value of a number option
+	help   string
+	secure bool                      // Cannot be set by a project config
+	check  func(v interface{}) error // Rejects invalid values
+	onSet  func(e *Editor)           // Applies a change
+}
+
+var options = map[string]*option{}
+
+// fileTypeOptions holds the buffer option defaults for each filetype. They
+// take precedence over global values but not over values set in a buffer.
+var fileTypeOptions = map[string]map[string]interface{}{
+	"python":     {"expandtab": true, "shiftwidth": 4},
+	"rust":       {"expandtab": true, "shiftwidth": 4},
+	"javascript": {"expandtab": true, "shiftwidth": 2},
+	"typescript": {"expandtab": true, "shiftwidth": 2},
+	"json":       {"expandtab": true, "shiftwidth": 2},
+	"css":        {"expandtab": true, "shiftwidth": 2},
+	"markdown":   {"expandtab": true, "shiftwidth": 2},
+}
+
+// registerOption adds options to the registry.
+func registerOption(opts ...*option) {
+	for _, o := range opts {
+		options[o.name] = o
+	}
+}
+
+// lookupOption finds an option by its name or abbreviation.
+func lookupOption(name string) *option {
+	if o, ok := options[name]; ok {
+		return o
+	}
+	for _, o := range options {
+		if o.short != "" && o.short == name {
+			return o
+		}
+	}
+	return nil
+}
+
+func init() {
+	registerOption(
+		&option{name: "autoindent", short: "ai", kind: boolOption, scope: bufferScope, def: true,
+			help: "New lines copy the indentation of the previous line"},
+		&option{name: "smartindent", short: "si", kind: boolOption, scope: bufferScope, def: true,
+			help: "Indent after an opening brace and line up closing braces"},
+		&option{name: "autopairs", short: "ap", kind: boolOption, def: false,
+			help: "Typing an opening bracket or quote also inserts its closer"},
+		&option{name: "tabstop", short: "ts", kind: numberOption, scope: bufferScope, def: 4, min: 1,
+			help: "Number of columns a tab takes up"},
+		&option{name: "shiftwidth", short: "sw", kind: numberOption, scope: bufferScope, def: 0,
+			help: "Columns of one indentation level; 0 uses tabstop"},
+		&option{name: "expandtab", short: "et", kind: boolOption, scope: bufferScope, def: false,
+			help: "Indent with spaces instead of tabs"},
+		&option{name: "filetype", short: "ft", kind: stringOption, scope: bufferScope, def: "",
+			help: "Language of the buffer, detected from the file name"},
+		&option{name: "scroll", short: "scr", kind: numberOption, scope: windowScope, def: 0,
+			help: "Lines scrolled by Ctrl-D and Ctrl-U; 0 for half a screen"},
+		&option{name: "undolevels", short: "ul", kind: numberOption, def: 1000,
+			help: "Number of changes that can be undone"},
+		&option{name: "undomemory", short: "um", kind: numberOption, def: 16384,
+			help: "Kilobytes of undo history kept for each buffer (0 for no limit)"},
+		&option{name: "chatwidth", short: "cw", kind: numberOption, def: 40, min: 10,
+			help: "Width of the AI chat panel", onSet: (*Editor).rebuildLayout},
+		&option{name: "chatposition", kind: enumOption, def: "right", values: []string{"right", "left"},
+			help: "Side of the screen the AI chat panel opens on", onSet: (*Editor).rebuildLayout},
+		&option{name: "redrawtick", kind: numberOption, def: 100, min: 10,
+			help: "Milliseconds between redraws for background updates", onSet: func(e *Editor) {
+				if e.ticker != nil {
+					e.ticker.Reset(time.Duration(e.intOpt("redrawtick")) * time.Millisecond)
+				}
+			}},
+		&option{name: "shelltimeout", kind: numberOption, def: 30, min: 1,
+			help: "Seconds a shell command may run before it is stopped"},
+		&option{name: "keywordcolor", kind: stringOption, def: "blue", check: checkColor,
+			help: "Color of keywords"},
+		&option{name: "cursorcolor", kind: stringOption, def: "white:black", check: checkColor,
+			help: "Colors of the cursor, as foreground:background"},
+		&option{name: "statuscolor", kind: stringOption, def: "black:white", check: checkColor,
+			help: "Colors of the mode shown in the status bar"},
+		&option{name: "menucolor", kind: stringOption, def: "black:yellow", check: checkColor,
+			help: "Colors of the selected completion"},
+		&option{name: "searchcolor", kind: stringOption, def: "black:yellow", check: checkColor,
+			help: "Colors of search matches"},
+		&option{name: "incsearchcolor", kind: stringOption, def: "black:aqua", check: checkColor,
+			help: "Colors of the match found while typing a search"},
+	)
+
+	complete := func(e *Editor, arg string) []string {
+		var names []string
+		for name := range options {
+			for _, n := range []string{name, "no" + name} {
+				if strings.HasPrefix(n, arg) && (n == name || options[name].kind == boolOption) {
+					names = append(names, n)
+				}
+			}
+		}
+		sort.Strings(names)
+		return names
+	}
+	registerEx(
+		&exCommand{name: "set", abbrev: "se", complete: complete, run: func(e *Editor, c *exCall) error {
+			return e.setOptions(c.arg, false)
+		}},
+		&exCommand{name: "setlocal", abbrev: "setl", complete: complete, run: func(e *Editor, c *exCall) error {
+			return e.setOptions(c.arg, true)
+		}},
+	)
+}
+
+// optionValue returns the value of an option for the current buffer and window.
+func (e *Editor) optionValue(o *option) interface{} {
+	if o.name == "filetype" {
+		return e.buffer.FileType
+	}
+	switch o.scope {
+	case bufferScope:
+		if v, ok := e.buffer.options[o.name]; ok {
+			return v
+		}
+		if v, ok := fileTypeOptions[e.buffer.FileType][o.name]; ok {
+			return v
+		}
+	case windowScope:
+		if v, ok := e.winOptions[o.name]; ok {
+			return v
+		}
+	}
+	if v, ok := e.options[o.name]; ok {
+		return v
+	}
+	return o.def
+}
+
+func (e *Editor) boolOpt(name string) bool     { return e.optionValue(options[name]).(bool) }
+func (e *Editor) intOpt(name string) int       { return e.optionValue(options[name]).(int) }
+func (e *Editor) stringOpt(name string) string { return e.optionValue(options[name]).(string) }
+
+// setOptionValue changes an option. The local value of a buffer or window
+// option is always set; the global value, which buffers and windows without
+// their own value use, only if local is false.
+func (e *Editor) setOptionValue(o *option, v interface{}, local bool) {
+	switch {
+	case o.name == "filetype":
+		e.buffer.FileType = v.(string)
+	case o.scope == bufferScope:
+		if e.buffer.options == nil {
+			e.buffer.options = make(map[string]interface{})
+		}
+		e.buffer.options[o.name] = v
+	case o.scope == windowScope:
+		e.winOptions[o.name] = v
+	}
+	if !local || o.scope == globalScope {
+		e.options[o.name] = v
+	}
+	if o.onSet != nil {
+		o.onSet(e)
+	}
+}
+
+// formatOption returns how :set shows an option: "name" or "noname" for a
+// boolean and "name=value" otherwise.
+func (e *Editor) formatOption(o *option) string {
+	v := e.optionValue(o)
+	if o.kind == boolOption {
+		if v.(bool) {
+			return o.name
+		}
+		return "no" + o.name
+	}
+	return fmt.Sprintf("%s=%v", o.name, v)
+}
+
+// setOptions handles the arguments of :set and :setlocal. Without arguments
+// it lists the options that differ from their defaults; "all" lists every option.
+func (e *Editor) setOptions(arg string, local bool) error {
+	args, err := splitArgs(arg)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 || len(args) == 1 && args[0] == "all" {
+		e.listOptions(len(args) == 1)
+		return nil
+	}
+	var shown []string
+	for _, a := range args {
+		msg, err := e.setOption(a, local)
+		if err != nil {
+			return err
+		}
+		if msg != "" {
+			shown = append(shown, msg)
+		}
+	}
+	if len(shown) > 0 {
+		e.statusMsg = strings.Join(shown, "  ")
+	}
+	return nil
+}
+
+// setOption applies one :set argument: name, noname, invname, name!, name?,
+// name&, name=value, name+=value or name-=value. It returns the text to show
+// for a query.
+func (e *Editor) setOption(arg string, local bool) (string, error) {
+	name, op, value := arg, "", ""
+	if i := strings.IndexAny(arg, "=:"); i > 0 {
+		name, op, value = arg[:i], "=", arg[i+1:]
+		if strings.HasSuffix(name, "+") || strings.HasSuffix(name, "-") {
+			name, op = name[:len(name)-1], name[len(name)-1:]+"="
+		}
+	} else if strings.HasSuffix(arg, "?") || strings.HasSuffix(arg, "&") || strings.HasSuffix(arg, "!") {
+		name, op = arg[:len(arg)-1], arg[len(arg)-1:]
+	}
+
+	o := lookupOption(name)
+	if o == nil && op == "" {
+		for _, prefix := range []string{"no", "inv"} {
+			if b := lookupOption(strings.TrimPrefix(name, prefix)); strings.HasPrefix(name, prefix) && b != nil && b.kind == boolOption {
+				o, op = b, prefix
+				break
+			}
+		}
+	}
+	if o == nil {
+		return "", fmt.Errorf("Unknown option: %s", name)
+	}
+	if o.secure && op != "?" && (op != "" || o.kind == boolOption) {
+		if err := e.checkSecure(); err != nil {
+			return "", err
+		}
+	}
+
+	switch op {
+	case "?":
+		return e.formatOption(o), nil
+	case "&":
+		e.setOptionValue(o, o.def, local)
+		return "", nil
+	case "":
+		if o.kind != boolOption {
+			return e.formatOption(o), nil
+		}
+		e.setOptionValue(o, true, local)
+		return "", nil
+	case "no", "inv", "!":
+		if o.kind != boolOption {
+			return "", fmt.Errorf("Invalid argument: %s", arg)
+		}
+		e.setOptionValue(o, op != "no" && !e.boolOpt(o.name), local)
+		return "", nil
+	}
+
+	var v interface{}
+	switch o.kind {
+	case boolOption:
+		return "", fmt.Errorf("Invalid argument: %s", arg)
+	case numberOption:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return "", fmt.Errorf("Number required after =: %s", arg)
+		}
+		switch op {
+		case "+=":
+			n = e.intOpt(o.name) + n
+		case "-=":
+			n = e.intOpt(o.name) - n
+		}
+		if n < o.min {
+			return "", fmt.Errorf("%s must be at least %d", o.name, o.min)
+		}
+		v = n
+	case stringOption:
+		switch op {
+		case "+=":
+			value = e.stringOpt(o.name) + value
+		case "-=":
+			value = strings.Replace(e.stringOpt(o.name), value, "", 1)
+		}
+		v = value
+	case enumOption:
+		valid := false
+		for _, allowed := range o.values {
+			valid = valid || allowed == value
+		}
+		if !valid || op != "=" {
+			return "", fmt.Errorf("Invalid argument: %s (one of %s)", arg, strings.Join(o.values, ", "))
+		}
+		v = value
+	}
+	if o.check != nil {
+		if err := o.check(v); err != nil {
+			return "", err
+		}
+	}
+	e.setOptionValue(o, v, local)
+	return "", nil
+}
+
+// checkColor accepts colors for the text, or foreground:background: names
+// such as "blue", #rrggbb values, and "-" or "" to keep the default.
+func checkColor(v interface{}) error {
+	for _, c := range strings.Split(v.(string), ":") {
+		if c == "" || c == "-" {
+			continue
+		}
+		if _, ok := tcell.ColorNames[strings.ToLower(c)]; ok {
+			continue
+		}
+		if len(c) == 7 && c[0] == '#' {
+			if _, err := strconv.ParseUint(c[1:], 16, 32); err == nil {
+				continue
+			}
+		}
+		return fmt.Errorf("Unknown color: %s", c)
+	}
+	if strings.Count(v.(string), ":") > 1 {
+		return fmt.Errorf("Invalid colors: %s (use foreground:background)", v)
+	}
+	return nil
+}
+
+// colorTag returns the tview tag setting the colors of a color option.
+func (e *Editor) colorTag(name string) string {
+	return "[" + e.stringOpt(name) + "]"
+}
+
+// listOptions shows options in the pane: all of them, or those that differ
+// from their defaults.
+func (e *Editor) listOptions(all bool) {
+	var names []string
+	for name := range options {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	for _, name := range names {
+		o := options[name]
+		if !all && e.optionValue(o) == o.def {
+			continue
+		}
+		fmt.Fprintf(&b, "%-20s %s\n", e.formatOption(o), o.help)
+	}
+	if b.Len() == 0 {
+		e.statusMsg = "All options have their default values"
+		return
+	}
+	e.showPane("Options", b.String())
+}
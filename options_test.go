@@ -0,0 +1,87 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestSetOptions(t *testing.T) {
+	e := newTestEditor("x")
+	steps := []struct {
+		cmd    string
+		query  string
+		status string
+	}{
+		{"set ts=8", "set ts?", "tabstop=8"},
+		{"set tabstop+=2", "set tabstop?", "tabstop=10"},
+		{"set tabstop&", "set ts?", "tabstop=4"},
+		{"set noai", "set ai?", "noautoindent"},
+		{"set invautoindent", "set autoindent?", "autoindent"},
+		{"set ai!", "set ai?", "noautoindent"},
+		{"set et sw=2", "set et? sw?", "expandtab  shiftwidth=2"},
+		{"set chatposition=left", "set chatposition", "chatposition=left"},
+	}
+	for _, s := range steps {
+		e.exec(s.cmd)
+		e.statusMsg = ""
+		e.exec(s.query)
+		if e.statusMsg != s.status {
+			t.Errorf("%q then %q: got %q, want %q", s.cmd, s.query, e.statusMsg, s.status)
+		}
+	}
+
+	for cmd, want := range map[string]string{
+		"set bogus":             "Unknown option: bogus",
+		"set ts=x":              "Number required after =: ts=x",
+		"set ts=0":              "tabstop must be at least 1",
+		"set ai=3":              "Invalid argument: ai=3",
+		"set notabstop":         "Unknown option: notabstop",
+		"set chatposition=down": "Invalid argument: chatposition=down (one of right, left)",
+	} {
+		e.exec(cmd)
+		if e.statusMsg != want {
+			t.Errorf("%q: got %q, want %q", cmd, e.statusMsg, want)
+		}
+	}
+}
+
+func TestOptionScopes(t *testing.T) {
+	e := newTestEditor("a")
+	first := e.buffer
+	e.exec("setlocal ts=2")
+	e.exec("set sw=3")
+
+	second := &Buffer{Lines: []string{"b"}, FileType: "python"}
+	e.switchBuffer(second)
+	if ts := e.intOpt("tabstop"); ts != 4 {
+		t.Errorf(":setlocal should not change other buffers, tabstop=%d", ts)
+	}
+	if sw := e.intOpt("shiftwidth"); sw != 4 {
+		t.Errorf("filetype default should win over the global value, shiftwidth=%d", sw)
+	}
+	if !e.boolOpt("expandtab") || e.indentUnit() != "    " {
+		t.Errorf("python should indent with spaces, got %q", e.indentUnit())
+	}
+
+	e.exec("setlocal sw=8")
+	e.switchBuffer(first)
+	if ts, sw := e.intOpt("tabstop"), e.intOpt("shiftwidth"); ts != 2 || sw != 3 {
+		t.Errorf("first buffer: tabstop=%d shiftwidth=%d", ts, sw)
+	}
+	if e.indentUnit() != "\t " {
+		t.Errorf("shiftwidth 3 with tabstop 2 should be a tab and a space, got %q", e.indentUnit())
+	}
+
+	e.exec("set ft=python")
+	if e.buffer.FileType != "python" || !e.boolOpt("expandtab") {
+		t.Errorf("setting filetype: %q, expandtab %v", e.buffer.FileType, e.boolOpt("expandtab"))
+	}
+}
+
+func TestTabstopMovesCursorColumns(t *testing.T) {
+	e := newTestEditor("\tab", "abcdefghij")
+	e.exec("set ts=8")
+	typeKeys(e, "$j")
+	if e.cx != 9 {
+		t.Fatalf("j from display column 9 with tabstop 8: cx=%d", e.cx)
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+// --- Dot Repeat ---
+
+// changeRecord is the replayable record of the last buffer-modifying
+// command: the keys that made it, including any text typed in the insert
+// session it started, and the count it was given.
+type changeRecord struct {
+	keys  []*tcell.EventKey // Command keys without the count prefix
+	count int
+}
+
+// finishChange completes the command in progress. If it modified the buffer
+// and record is set, it becomes the last change for ".".
+func (e *Editor) finishChange(record bool) {
+	if record && !e.replaying && e.buffer.changedTick != e.cmdTick {
+		e.lastChange = changeRecord{keys: e.cmdKeys, count: e.cmdCount}
+	}
+	e.cmdKeys = nil
+	e.endCommandUndo()
+}
+
+// repeatLastChange replays the last change. A non-zero count replaces the
+// count the change was originally made with.
+func (e *Editor) repeatLastChange(count int) {
+	if len(e.lastChange.keys) == 0 {
+		e.statusMsg = "No previous change to repeat"
+		return
+	}
+	if count > 0 {
+		e.lastChange.count = count
+	}
+
+	rec := e.lastChange
+	e.replaying = true
+	defer func() { e.replaying = false }()
+
+	e.cmdKeys = nil
+	e.count = rec.count
+	for _, k := range rec.keys {
+		e.dispatchKey(k)
+	}
+	if e.mode == ModeInsert || e.mode == ModeReplace {
+		e.stopInsert()
+	}
+}
+
+// dispatchKey routes a key to the handler for the current mode without redrawing.
+func (e *Editor) dispatchKey(event *tcell.EventKey) {
+	switch e.mode {
+	case ModeNormal:
+		e.feedNormal(event)
+	case ModeInsert, ModeReplace:
+		e.insertModeKey(event)
+	case ModeConfirm:
+		e.confirmKey(event)
+	case ModeQuickfix:
+		e.quickfixKey(event)
+	case ModeFinder:
+		e.finderKey(event)
+	case ModeReplaceAll:
+		e.replaceAllKey(event)
+	case ModeUndoTree:
+		e.undoTreeKey(event)
+	case ModeCommand, ModeSearch:
+		e.commandInput.InputHandler()(event, func(p tview.Primitive) { e.app.SetFocus(p) })
+	}
+}
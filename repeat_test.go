@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestDotRepeatsInsertAndCount(t *testing.T) {
+	e := newTestEditor("abcdef")
+	typeKeys(e, "2ix<Esc>")
+	if got := e.buffer.Lines[0]; got != "xxabcdef" {
+		t.Fatalf("after 2ix: got %q", got)
+	}
+	typeKeys(e, ".")
+	if got := e.buffer.Lines[0]; got != "xxxxabcdef" {
+		t.Fatalf("after .: got %q", got)
+	}
+	typeKeys(e, "3.")
+	if got := e.buffer.Lines[0]; got != "xxxxxxxabcdef" {
+		t.Fatalf("after 3.: got %q", got)
+	}
+
+	e = newTestEditor("abcdef")
+	typeKeys(e, "2xl.")
+	if got := e.buffer.Lines[0]; got != "cf" {
+		t.Fatalf("after 2xl.: got %q", got)
+	}
+}
+
+func TestDotIgnoresMotionsAndUndo(t *testing.T) {
+	e := newTestEditor("abc")
+	typeKeys(e, "xul.")
+	if got := e.buffer.Lines[0]; got != "ac" {
+		t.Fatalf("got %q", got)
+	}
+	if len(e.lastChange.keys) != 1 || keyString(e.lastChange.keys[0]) != "x" {
+		t.Fatalf("last change should be x, got %d keys", len(e.lastChange.keys))
+	}
+}
@@ -0,0 +1,403 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+// --- Project-wide Replace ---
+
+// replaceAll is a :replaceall whose changes are being reviewed.
+type replaceAll struct {
+	pattern string
+	files   []*replaceFile
+	changes []*replaceChange // The changes of all files, in order
+	sel     int              // Selected change
+}
+
+// replaceFile is a file with lines :replaceall changes. A file open in a
+// buffer is changed in the buffer instead of on disk.
+type replaceFile struct {
+	path    string
+	buffer  *Buffer
+	data    string // Contents of the file the changes were found in
+	changes []*replaceChange
+}
+
+// replaceChange is the replacement of a line.
+type replaceChange struct {
+	file     *replaceFile
+	line     int
+	old, new string
+	on       bool // The change is to be made
+}
+
+func init() {
+	registerOption(
+		&option{name: "diffaddcolor", kind: stringOption, def: "green", check: checkColor,
+			help: "Colors of added lines in the :replaceall preview"},
+		&option{name: "diffdeletecolor", kind: stringOption, def: "red", check: checkColor,
+			help: "Colors of removed lines in the :replaceall preview"},
+	)
+	registerEx(&exCommand{name: "replaceall", abbrev: "repl", complete: completeFiles, run: exReplaceAll})
+}
+
+// exReplaceAll finds the matches of a pattern in the files under the
+// current directory, or those matching a glob, and shows the lines their
+// replacement would change for review: :replaceall pattern replacement
+// [glob]. The glob matches the file name, or the whole path if it has a /.
+func exReplaceAll(e *Editor, c *exCall) error {
+	args, err := splitArgs(c.arg)
+	if err != nil {
+		return err
+	}
+	if len(args) < 2 || len(args) > 3 {
+		return fmt.Errorf("Usage: replaceall pattern replacement [glob]")
+	}
+	pat, rep, glob := args[0], args[1], ""
+	if len(args) == 3 {
+		glob = args[2]
+		if _, err := path.Match(glob, ""); err != nil {
+			return fmt.Errorf("Invalid glob: %s", glob)
+		}
+	}
+	re, err := e.compilePattern(pat)
+	if err != nil {
+		return err
+	}
+
+	// Open buffers are searched instead of their files
+	buffers := make(map[string]*Buffer)
+	contents := make(map[string]string)
+	for _, b := range e.buffers {
+		if b.FilePath == "" {
+			continue
+		}
+		if abs, err := filepath.Abs(b.FilePath); err == nil {
+			buffers[abs] = b
+			contents[abs] = strings.Join(b.Lines, "\n")
+		}
+	}
+	return e.startJob("Searching for "+pat, 0, func(ctx context.Context) func() {
+		files, err := findReplacements(ctx, re, rep, glob, contents)
+		return func() {
+			switch {
+			case errors.Is(err, context.Canceled):
+				e.statusMsg = "Search cancelled"
+			case err != nil:
+				e.statusMsg = err.Error()
+			case len(files) == 0:
+				e.statusMsg = "Pattern not found: " + pat
+			default:
+				for _, f := range files {
+					if abs, err := filepath.Abs(f.path); err == nil {
+						f.buffer = buffers[abs]
+					}
+				}
+				e.reviewReplaceAll(pat, files)
+			}
+		}
+	})
+}
+
+// findReplacements returns the files under the current directory matching
+// glob, or all of them if it is "", with the lines replacing every match of
+// re with rep changes, sorted by path. contents holds the text of files open
+// in buffers by their absolute paths, which is used instead of the files.
+func findReplacements(ctx context.Context, re *regexp.Regexp, rep, glob string, contents map[string]string) ([]*replaceFile, error) {
+	var mu sync.Mutex
+	var files []*replaceFile
+	err := forEachFile(ctx, []string{"."}, func(p string) {
+		rel := filepath.ToSlash(p)
+		if glob != "" && !globMatch(glob, rel) && (strings.Contains(glob, "/") || !globMatch(glob, path.Base(rel))) {
+			return
+		}
+		abs, err := filepath.Abs(p)
+		if err != nil {
+			return
+		}
+		data, open := contents[abs]
+		if !open {
+			raw, err := os.ReadFile(p)
+			if err != nil || isBinary(raw) {
+				return
+			}
+			data = string(raw)
+		}
+		if !re.MatchString(data) {
+			return
+		}
+		f := &replaceFile{path: p, data: data}
+		for i, line := range strings.Split(data, "\n") {
+			text := strings.TrimSuffix(line, "\r")
+			if changed := replaceLine(re, rep, text); changed != text {
+				f.changes = append(f.changes, &replaceChange{file: f, line: i, old: line, new: changed + line[len(text):], on: true})
+			}
+		}
+		if len(f.changes) > 0 {
+			mu.Lock()
+			files = append(files, f)
+			mu.Unlock()
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].path < files[j].path })
+	return files, nil
+}
+
+// replaceLine returns line with every match of re replaced by rep, expanded
+// as in :s.
+func replaceLine(re *regexp.Regexp, rep, line string) string {
+	var b strings.Builder
+	done := 0
+	for _, m := range re.FindAllStringSubmatchIndex(line, -1) {
+		b.WriteString(line[done:m[0]])
+		b.WriteString(expandReplacement(re, rep, line, m))
+		done = m[1]
+	}
+	b.WriteString(line[done:])
+	return b.String()
+}
+
+// reviewReplaceAll shows the changes of a :replaceall in the pane below
+// the text, where they can be turned off before they are made.
+func (e *Editor) reviewReplaceAll(pattern string, files []*replaceFile) {
+	r := &replaceAll{pattern: pattern, files: files}
+	for _, f := range files {
+		r.changes = append(r.changes, f.changes...)
+	}
+	e.replaceAll = r
+	e.mode = ModeReplaceAll
+	e.rebuildLayout()
+	e.renderReplaceAll()
+	e.statusMsg = fmt.Sprintf("%s in %s: Space toggles, Enter replaces, Esc cancels",
+		plural(len(r.changes), "line", "lines"), plural(len(files), "file", "files"))
+}
+
+// closeReplaceAll closes the review of a :replaceall.
+func (e *Editor) closeReplaceAll() {
+	e.replaceAll = nil
+	e.mode = ModeNormal
+	e.rebuildLayout()
+}
+
+// renderReplaceAll fills the review pane: each line to change with its old
+// and new text, [x] marking the changes that will be made.
+func (e *Editor) renderReplaceAll() {
+	r := e.replaceAll
+	del, add := e.colorTag("diffdeletecolor"), e.colorTag("diffaddcolor")
+	var b strings.Builder
+	row, selRow := 0, 0
+	on := 0
+	for i, c := range r.changes {
+		box := "[ ]"
+		if c.on {
+			box = "[x]"
+			on++
+		}
+		head := tview.Escape(fmt.Sprintf("%s %s:%d", box, c.file.path, c.line+1))
+		if i == r.sel {
+			head = e.colorTag("menucolor") + head + "[-:-]"
+			selRow = row
+		}
+		b.WriteString(head + "\n")
+		b.WriteString(del + tview.Escape("    - "+c.old) + "[-:-]\n")
+		row += 2
+		for _, line := range strings.Split(c.new, "\n") {
+			b.WriteString(add + tview.Escape("    + "+line) + "[-:-]\n")
+			row++
+		}
+	}
+	e.replaceView.SetTitle(fmt.Sprintf(" Replace %s: %d of %d ", r.pattern, on, len(r.changes)))
+	e.replaceView.SetText(b.String())
+	_, _, _, height := e.replaceView.GetInnerRect()
+	top := selRow - height/2
+	if top < 0 {
+		top = 0
+	}
+	e.replaceView.ScrollTo(top, 0)
+}
+
+// replaceAllKey handles a key while reviewing a :replaceall: j and k (or
+// the arrow keys) select a change, Space turns it on or off, a turns all of
+// them on or off, Enter makes the changes that are on and Esc or q cancels.
+func (e *Editor) replaceAllKey(event *tcell.EventKey) {
+	r := e.replaceAll
+	key, ch := event.Key(), rune(0)
+	if key == tcell.KeyRune {
+		ch = event.Rune()
+	}
+	switch {
+	case key == tcell.KeyDown || ch == 'j':
+		r.sel = clamp(r.sel+1, 0, len(r.changes)-1)
+	case key == tcell.KeyUp || ch == 'k':
+		r.sel = clamp(r.sel-1, 0, len(r.changes)-1)
+	case ch == ' ':
+		r.changes[r.sel].on = !r.changes[r.sel].on
+	case ch == 'a':
+		all := true
+		for _, c := range r.changes {
+			all = all && c.on
+		}
+		for _, c := range r.changes {
+			c.on = !all
+		}
+	case key == tcell.KeyEnter:
+		e.closeReplaceAll()
+		if err := e.applyReplaceAll(r); err != nil {
+			e.statusMsg = err.Error()
+		}
+		return
+	case key == tcell.KeyEsc || ch == 'q':
+		e.closeReplaceAll()
+		e.statusMsg = "Replace cancelled"
+		return
+	}
+	e.renderReplaceAll()
+}
+
+// applyReplaceAll makes the changes that are on. Files that changed since
+// they were searched make it fail before anything is changed. The other
+// files are written to temporary files first and then renamed over the
+// originals; if a rename fails, the files already renamed over get their
+// old text back, so a failed write leaves them all as they were. Open
+// buffers are changed in memory, as one undo step each, and left to be
+// saved.
+func (e *Editor) applyReplaceAll(r *replaceAll) error {
+	type write struct {
+		f    *replaceFile
+		tmp  string
+		mode os.FileMode
+	}
+	var writes []write
+	var buffers []*replaceFile
+	lines := 0
+	for _, f := range r.files {
+		var on []*replaceChange
+		for _, c := range f.changes {
+			if c.on {
+				on = append(on, c)
+			}
+		}
+		if len(on) == 0 {
+			continue
+		}
+		lines += len(on)
+		f.changes = on
+		if f.buffer != nil {
+			for _, c := range on {
+				if c.line >= len(f.buffer.Lines) || f.buffer.Lines[c.line] != c.old {
+					return fmt.Errorf("%s changed since the search; run :replaceall again", f.path)
+				}
+			}
+			buffers = append(buffers, f)
+			continue
+		}
+		info, err := os.Stat(f.path)
+		if err != nil {
+			return fmt.Errorf("Cannot read %s", f.path)
+		}
+		if data, err := os.ReadFile(f.path); err != nil || string(data) != f.data {
+			return fmt.Errorf("%s changed since the search; run :replaceall again", f.path)
+		}
+		writes = append(writes, write{f: f, mode: info.Mode().Perm()})
+	}
+	if lines == 0 {
+		return fmt.Errorf("No changes selected")
+	}
+
+	removeTemps := func() {
+		for _, w := range writes {
+			if w.tmp != "" {
+				os.Remove(w.tmp)
+			}
+		}
+	}
+	for i := range writes {
+		w := &writes[i]
+		split := strings.Split(w.f.data, "\n")
+		for _, c := range w.f.changes {
+			split[c.line] = c.new
+		}
+		tmp, err := writeTemp(w.f.path, strings.Join(split, "\n"), w.mode)
+		if err != nil {
+			removeTemps()
+			return fmt.Errorf("Error writing %s: %v", w.f.path, err)
+		}
+		w.tmp = tmp
+	}
+	for i, w := range writes {
+		if err := os.Rename(w.tmp, w.f.path); err != nil {
+			removeTemps()
+			// The files searched still hold the text they were read with
+			var kept []string
+			for _, done := range writes[:i] {
+				tmp, rerr := writeTemp(done.f.path, done.f.data, done.mode)
+				if rerr == nil {
+					if rerr = os.Rename(tmp, done.f.path); rerr != nil {
+						os.Remove(tmp)
+					}
+				}
+				if rerr != nil {
+					kept = append(kept, done.f.path)
+				}
+			}
+			if len(kept) > 0 {
+				return fmt.Errorf("Error writing %s: %v; could not undo the changes to %s", w.f.path, err, strings.Join(kept, ", "))
+			}
+			return fmt.Errorf("Error writing %s: %v; no files changed", w.f.path, err)
+		}
+		writes[i].tmp = ""
+	}
+
+	for _, f := range buffers {
+		b := f.buffer
+		e.pushUndoFor(b)
+		// From the bottom up, so line breaks in replacements keep the
+		// numbers of the lines still to change
+		for i := len(f.changes) - 1; i >= 0; i-- {
+			c := f.changes[i]
+			b.ReplaceLines(c.line, c.line+1, strings.Split(c.new, "\n")...)
+		}
+	}
+	e.clampCursor()
+	e.statusMsg = fmt.Sprintf("%s changed in %s", plural(lines, "line", "lines"), plural(len(writes)+len(buffers), "file", "files"))
+	if len(buffers) > 0 {
+		e.statusMsg += fmt.Sprintf(" (%s not written)", plural(len(buffers), "open buffer", "open buffers"))
+	}
+	return nil
+}
+
+// writeTemp writes data to a new temporary file next to path, with mode,
+// and returns its name.
+func writeTemp(path, data string, mode os.FileMode) (string, error) {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return "", err
+	}
+	_, err = tmp.WriteString(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), mode)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return tmp.Name(), nil
+}
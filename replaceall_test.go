@@ -0,0 +1,93 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestReplaceAll(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "a.go"), "foo := 1\nbar(foo)\n")
+	writeFile(t, filepath.Join(dir, "sub", "b.go"), "// foo\r\nx\r\n")
+	writeFile(t, filepath.Join(dir, "c.txt"), "foo\n")
+	writeFile(t, filepath.Join(dir, "open.go"), "one foo\nfoo two\n")
+	wd, _ := os.Getwd()
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	read := func(name string) string {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+
+	e := newTestEditor("")
+	e.exec("e open.go")
+	runBackground(t, e, `replaceall 'f(o+)' 'b\U$1' *.go`)
+	if e.mode != ModeReplaceAll || e.statusMsg != "5 lines in 3 files: Space toggles, Enter replaces, Esc cancels" {
+		t.Fatalf("mode %s status %q", e.mode, e.statusMsg)
+	}
+	var got []string
+	for _, c := range e.replaceAll.changes {
+		got = append(got, c.file.path+": "+c.new)
+	}
+	want := []string{"a.go: bOO := 1", "a.go: bar(bOO)", "open.go: one bOO", "open.go: bOO two", "sub/b.go: // bOO\r"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("changes %q", got)
+	}
+
+	typeKeys(e, "j<Space>jj<Space><CR>")
+	if e.mode != ModeNormal || e.replaceAll != nil || e.statusMsg != "3 lines changed in 3 files (1 open buffer not written)" {
+		t.Fatalf("mode %s status %q", e.mode, e.statusMsg)
+	}
+	if got := read("a.go"); got != "bOO := 1\nbar(foo)\n" {
+		t.Errorf("a.go: %q", got)
+	}
+	if got := read("sub/b.go"); got != "// bOO\r\nx\r\n" {
+		t.Errorf("sub/b.go: %q", got)
+	}
+	if got := read("c.txt"); got != "foo\n" {
+		t.Errorf("c.txt should not match the glob: %q", got)
+	}
+	if got := read("open.go"); got != "one foo\nfoo two\n" {
+		t.Errorf("the open buffer's file should be left alone: %q", got)
+	}
+	if want := []string{"one bOO", "foo two"}; !reflect.DeepEqual(e.buffer.Lines, want) || !e.buffer.Dirty {
+		t.Fatalf("buffer %q", e.buffer.Lines)
+	}
+	e.undo()
+	if want := []string{"one foo", "foo two"}; !reflect.DeepEqual(e.buffer.Lines, want) {
+		t.Fatalf("undo: buffer %q", e.buffer.Lines)
+	}
+
+	writeFile(t, filepath.Join(dir, "sub", "b.go"), "// foo\n")
+	runBackground(t, e, "replaceall foo baz sub/**")
+	writeFile(t, filepath.Join(dir, "sub", "b.go"), "// foo changed\n")
+	typeKeys(e, "<CR>")
+	if e.statusMsg != "sub/b.go changed since the search; run :replaceall again" || read("sub/b.go") != "// foo changed\n" {
+		t.Fatalf("status %q", e.statusMsg)
+	}
+
+	runBackground(t, e, "replaceall foo baz")
+	typeKeys(e, "a")
+	if e.replaceView.GetText(true) == "" || e.replaceAll.changes[0].on {
+		t.Fatal("a should turn every change off")
+	}
+	typeKeys(e, "<Esc>")
+	if e.mode != ModeNormal || e.statusMsg != "Replace cancelled" || read("c.txt") != "foo\n" {
+		t.Fatalf("Esc: mode %s status %q", e.mode, e.statusMsg)
+	}
+	runBackground(t, e, "replaceall nothing x")
+	if e.statusMsg != "Pattern not found: nothing" || e.mode != ModeNormal {
+		t.Fatalf("status %q", e.statusMsg)
+	}
+	e.exec("replaceall foo")
+	if e.statusMsg != "Usage: replaceall pattern replacement [glob]" {
+		t.Fatalf("status %q", e.statusMsg)
+	}
+}
@@ -0,0 +1,427 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"regexp/syntax"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// --- Search ---
+
+func init() {
+	registerOption(&option{name: "wrapscan", short: "ws", kind: boolOption, def: true,
+		help: "Searches wrap around the end of the buffer"})
+	registerOption(&option{name: "ignorecase", short: "ic", kind: boolOption, def: false,
+		help: "Patterns ignore case"})
+	registerOption(&option{name: "incsearch", short: "is", kind: boolOption, def: true,
+		help: "Show the first match while typing a search"})
+	registerOption(&option{name: "hlsearch", short: "hls", kind: boolOption, def: true,
+		help: "Highlight all matches of the last search", onSet: func(e *Editor) { e.hlHidden = false }})
+	registerOption(&option{name: "smartcase", short: "scs", kind: boolOption, def: false,
+		help: "Patterns with capitals match case, with ignorecase"})
+
+	registerEx(&exCommand{name: "nohlsearch", abbrev: "noh", run: func(e *Editor, c *exCall) error {
+		e.hlHidden = true
+		return nil
+	}})
+}
+
+// searchMatch is a match of the search pattern; end is just past it.
+type searchMatch struct {
+	start, end pos
+}
+
+// searchOffset is the offset typed after a search pattern, as in /foo/e+1:
+// lines down from the match, or characters from its start (s or b) or its
+// last character (e).
+type searchOffset struct {
+	text   string // As typed, for the status bar
+	anchor byte   // 's', 'e', or 0 for a line offset
+	n      int
+}
+
+// compilePattern compiles a pattern typed by the user. Patterns are Go
+// regular expressions in which ^ and $ match at line breaks. \c anywhere in
+// the pattern ignores case and \C matches it, overriding the ignorecase and
+// smartcase options, and \V makes the rest of the pattern literal text.
+func (e *Editor) compilePattern(pat string) (*regexp.Regexp, error) {
+	var b, lit strings.Builder
+	ignoreCase := e.boolOpt("ignorecase")
+	override, literal := false, false
+	for i := 0; i < len(pat); i++ {
+		c := pat[i]
+		if c == '\\' && i+1 < len(pat) {
+			switch next := pat[i+1]; {
+			case next == 'c' || next == 'C':
+				ignoreCase, override = next == 'c', true
+				i++
+				continue
+			case next == 'V':
+				literal = true
+				i++
+				continue
+			case literal && next == '\\':
+				i++
+			case !literal:
+				b.WriteString(pat[i : i+2])
+				i++
+				continue
+			}
+		}
+		if literal {
+			lit.WriteByte(c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	expr := b.String() + regexp.QuoteMeta(lit.String())
+	if ignoreCase && !override && e.boolOpt("smartcase") && hasUpper(pat) {
+		ignoreCase = false
+	}
+	flags := "(?m)"
+	if ignoreCase {
+		flags = "(?mi)"
+	}
+	re, err := regexp.Compile(flags + expr)
+	if err != nil {
+		if serr, ok := err.(*syntax.Error); ok {
+			return nil, fmt.Errorf("Invalid pattern %s: %s", pat, serr.Code)
+		}
+		return nil, fmt.Errorf("Invalid pattern %s", pat)
+	}
+	return re, nil
+}
+
+// hasUpper reports whether a pattern has a capital letter that is not part
+// of an escape such as \S.
+func hasUpper(pat string) bool {
+	for i := 0; i < len(pat); i++ {
+		if pat[i] == '\\' {
+			i++
+			continue
+		}
+		r, _ := utf8.DecodeRuneInString(pat[i:])
+		if unicode.IsUpper(r) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseSearchOffset parses the offset after a search pattern.
+func parseSearchOffset(s string) (searchOffset, error) {
+	off := searchOffset{text: s}
+	rest := s
+	if rest != "" && strings.IndexByte("seb", rest[0]) >= 0 {
+		off.anchor = rest[0]
+		if off.anchor == 'b' {
+			off.anchor = 's'
+		}
+		rest = rest[1:]
+	}
+	switch rest {
+	case "":
+	case "+", "-":
+		off.n = 1
+		if rest == "-" {
+			off.n = -1
+		}
+	default:
+		n, err := strconv.Atoi(rest)
+		if err != nil {
+			return searchOffset{}, fmt.Errorf("Invalid search offset: %s", s)
+		}
+		off.n = n
+	}
+	return off, nil
+}
+
+// startSearch opens the command line for a / (forward) or ? (backward) search.
+func (e *Editor) startSearch(prompt string) {
+	e.mode = ModeSearch
+	e.searchStart = &searchView{pos{e.cy, e.cx}, e.rowOffset, e.colOffset}
+	e.commandInput.SetText(prompt)
+	e.app.SetFocus(e.commandInput)
+}
+
+// search runs a search typed on the command line, pattern and an optional
+// offset after the delimiter, and moves to the first match. An empty
+// pattern repeats the last search in the new direction.
+func (e *Editor) search(query string, backward bool) {
+	delim := byte('/')
+	if backward {
+		delim = '?'
+	}
+	pat, rest, closed := splitPattern(query, delim)
+	offset := e.searchOffset
+	if closed || pat != "" {
+		var err error
+		if offset, err = parseSearchOffset(rest); err != nil {
+			e.statusMsg = err.Error()
+			return
+		}
+	}
+	if pat == "" {
+		pat = e.searchQuery
+	}
+	if pat == "" {
+		e.statusMsg = "No previous search pattern"
+		return
+	}
+	if _, err := e.compilePattern(pat); err != nil {
+		e.statusMsg = err.Error()
+		return
+	}
+	e.searchQuery, e.searchBackward, e.searchOffset = pat, backward, offset
+	if p, ok := e.searchNext(!backward, 1); ok {
+		e.setJump()
+		e.cy, e.cx = p.line, p.col
+		e.clampCursor()
+		e.updateWantCol()
+	}
+}
+
+// searchMotion returns the motion of n, which repeats the last search, or
+// with reverse set of N, which repeats it in the other direction.
+func searchMotion(reverse bool) func(e *Editor, a normalArgs) (pos, bool) {
+	return func(e *Editor, a normalArgs) (pos, bool) {
+		return e.searchNext(e.searchBackward == reverse, a.times())
+	}
+}
+
+// findMatches returns every match of re in the buffer, in order. The lines
+// are searched as one text, so a pattern can match a line break with \n.
+func (e *Editor) findMatches(re *regexp.Regexp) []searchMatch {
+	return matchesIn(re, e.buffer.Lines, 0)
+}
+
+// matchesIn returns the matches of re in lines, which start at line first
+// of the buffer.
+func matchesIn(re *regexp.Regexp, lines []string, first int) []searchMatch {
+	starts := make([]int, len(lines))
+	n := 0
+	for y, line := range lines {
+		starts[y] = n
+		n += len(line) + 1
+	}
+	toPos := func(off int) pos {
+		y := sort.Search(len(starts), func(i int) bool { return starts[i] > off }) - 1
+		return pos{first + y, off - starts[y]}
+	}
+
+	var matches []searchMatch
+	for _, m := range re.FindAllStringIndex(strings.Join(lines, "\n"), -1) {
+		matches = append(matches, searchMatch{toPos(m[0]), toPos(m[1])})
+	}
+	return matches
+}
+
+// offsetTarget returns where the cursor goes for match m with the last
+// search offset.
+func (e *Editor) offsetTarget(m searchMatch) pos {
+	off := e.searchOffset
+	switch off.anchor {
+	case 's':
+		return pos{m.start.line, moveChars(e.buffer.Lines[m.start.line], m.start.col, off.n)}
+	case 'e':
+		// The last character, which is the line break if the match ends
+		// with one
+		last := m.start
+		if m.end != m.start && m.end.col == 0 {
+			last = pos{m.end.line - 1, len(e.buffer.Lines[m.end.line-1])}
+		} else if m.end != m.start {
+			last = pos{m.end.line, moveChars(e.buffer.Lines[m.end.line], m.end.col, -1)}
+		}
+		return pos{last.line, moveChars(e.buffer.Lines[last.line], last.col, off.n)}
+	}
+	if off.n != 0 {
+		return pos{clamp(m.start.line+off.n, 0, len(e.buffer.Lines)-1), 0}
+	}
+	return m.start
+}
+
+// moveChars returns the column n characters after col (before it if n is
+// negative), staying within line.
+func moveChars(line string, col, n int) int {
+	for ; n > 0 && col < len(line); n-- {
+		_, size := utf8.DecodeRuneInString(line[col:])
+		col += size
+	}
+	for ; n < 0 && col > 0; n++ {
+		_, size := utf8.DecodeLastRuneInString(line[:col])
+		col -= size
+	}
+	return col
+}
+
+// searchNext finds the count'th match of the last search after (forward)
+// or before the cursor, wrapping around the ends of the buffer if wrapscan
+// is set, and returns where the search offset puts the cursor for it. It
+// fills searchResults and shows the match's number in the status bar, e.g.
+// "/foo [3/17]".
+func (e *Editor) searchNext(forward bool, count int) (pos, bool) {
+	if e.searchQuery == "" {
+		e.statusMsg = "No previous search pattern"
+		return pos{}, false
+	}
+	re, err := e.compilePattern(e.searchQuery)
+	if err != nil {
+		e.statusMsg = err.Error()
+		return pos{}, false
+	}
+	e.hlHidden = false
+	matches := e.findMatches(re)
+	e.searchResults = make([][2]int, len(matches))
+	targets := make([]pos, len(matches))
+	for i, m := range matches {
+		e.searchResults[i] = [2]int{m.start.line, m.start.col}
+		targets[i] = e.offsetTarget(m)
+	}
+	if len(matches) == 0 {
+		e.statusMsg = "Pattern not found: " + e.searchQuery
+		return pos{}, false
+	}
+
+	idx, wrapped, ok := nextTarget(targets, pos{e.cy, e.cx}, forward, count, e.boolOpt("wrapscan"))
+	if !ok {
+		end := "BOTTOM"
+		if !forward {
+			end = "TOP"
+		}
+		e.statusMsg = fmt.Sprintf("Search hit %s without match for: %s", end, e.searchQuery)
+		return pos{}, false
+	}
+
+	prompt := "/"
+	if e.searchBackward {
+		prompt = "?"
+	}
+	msg := prompt + e.searchQuery
+	if e.searchOffset.text != "" {
+		msg += prompt + e.searchOffset.text
+	}
+	switch {
+	case wrapped && forward:
+		msg = "search hit BOTTOM, continuing at TOP"
+	case wrapped:
+		msg = "search hit TOP, continuing at BOTTOM"
+	}
+	e.statusMsg = fmt.Sprintf("%s [%d/%d]", msg, idx+1, len(matches))
+	return targets[idx], true
+}
+
+// nextTarget returns the index of the count'th target after (forward) or
+// before from, wrapping around the ends of the list if wrap is set. wrapped
+// reports whether it did, and ok is false if there is no such target.
+func nextTarget(targets []pos, from pos, forward bool, count int, wrap bool) (idx int, wrapped, ok bool) {
+	if len(targets) == 0 {
+		return 0, false, false
+	}
+	for i := 0; i < count; i++ {
+		idx = -1
+		for j, t := range targets {
+			if forward && from.before(t) {
+				idx = j
+				break
+			}
+			if !forward && t.before(from) {
+				idx = j
+			}
+		}
+		if idx < 0 {
+			if !wrap {
+				return 0, false, false
+			}
+			idx = 0
+			if !forward {
+				idx = len(targets) - 1
+			}
+			wrapped = true
+		}
+		from = targets[idx]
+	}
+	return idx, wrapped, true
+}
+
+// searchView is the cursor and scroll position a search started from.
+type searchView struct {
+	cursor               pos
+	rowOffset, colOffset int
+}
+
+// incSearch moves the cursor to the first match of the pattern being typed
+// at the search prompt, from where the search started, if incsearch is set.
+// It is called when the text of the prompt changes.
+func (e *Editor) incSearch(text string) {
+	if e.mode != ModeSearch || e.searchStart == nil || !e.boolOpt("incsearch") {
+		return
+	}
+	start := e.searchStart
+	e.cy, e.cx = start.cursor.line, start.cursor.col
+	e.rowOffset, e.colOffset = start.rowOffset, start.colOffset
+	e.incPattern, e.incMatch = "", nil
+	if text == "" {
+		return
+	}
+	pat, _, _ := splitPattern(text[1:], text[0])
+	if pat == "" {
+		return
+	}
+	re, err := e.compilePattern(pat)
+	if err != nil {
+		return
+	}
+	e.incPattern = pat
+	matches := e.findMatches(re)
+	starts := make([]pos, len(matches))
+	for i, m := range matches {
+		starts[i] = m.start
+	}
+	idx, _, ok := nextTarget(starts, start.cursor, text[0] == '/', 1, e.boolOpt("wrapscan"))
+	if !ok {
+		return
+	}
+	e.incMatch = &matches[idx]
+	e.cy, e.cx = starts[idx].line, starts[idx].col
+}
+
+// endIncSearch puts the cursor back where the search started, when the
+// search prompt is closed.
+func (e *Editor) endIncSearch() {
+	if start := e.searchStart; start != nil {
+		e.cy, e.cx = start.cursor.line, start.cursor.col
+		e.rowOffset, e.colOffset = start.rowOffset, start.colOffset
+	}
+	e.searchStart, e.incPattern, e.incMatch = nil, "", nil
+}
+
+// highlightMatches returns the matches to highlight in lines first to last:
+// those of the pattern being typed at the search prompt, or with hlsearch
+// set, of the last search unless :nohlsearch hid them.
+func (e *Editor) highlightMatches(first, last int) []searchMatch {
+	var pat string
+	switch {
+	case e.incPattern != "" && e.boolOpt("hlsearch"):
+		pat = e.incPattern
+	case e.incPattern != "":
+		if e.incMatch != nil {
+			return []searchMatch{*e.incMatch}
+		}
+		return nil
+	case e.boolOpt("hlsearch") && !e.hlHidden:
+		pat = e.searchQuery
+	}
+	if pat == "" {
+		return nil
+	}
+	re, err := e.compilePattern(pat)
+	if err != nil {
+		return nil
+	}
+	return matchesIn(re, e.buffer.Lines[first:last+1], first)
+}
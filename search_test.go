@@ -0,0 +1,185 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSearch(t *testing.T) {
+	e := newTestEditor("foo bar", "baz foo", "", "x foo foo")
+	typeKeys(e, "/foo<CR>")
+	if e.cy != 1 || e.cx != 4 || e.statusMsg != "/foo [2/4]" {
+		t.Fatalf("/foo: cursor %d,%d status %q", e.cy, e.cx, e.statusMsg)
+	}
+	if want := [][2]int{{0, 0}, {1, 4}, {3, 2}, {3, 6}}; !reflect.DeepEqual(e.searchResults, want) {
+		t.Fatalf("searchResults %v", e.searchResults)
+	}
+	typeKeys(e, "2n")
+	if e.cy != 3 || e.cx != 6 || e.statusMsg != "/foo [4/4]" {
+		t.Fatalf("2n: cursor %d,%d status %q", e.cy, e.cx, e.statusMsg)
+	}
+	typeKeys(e, "n")
+	if e.cy != 0 || e.cx != 0 || e.statusMsg != "search hit BOTTOM, continuing at TOP [1/4]" {
+		t.Fatalf("n at the end: cursor %d,%d status %q", e.cy, e.cx, e.statusMsg)
+	}
+	typeKeys(e, "N")
+	if e.cy != 3 || e.cx != 6 || e.statusMsg != "search hit TOP, continuing at BOTTOM [4/4]" {
+		t.Fatalf("N at the start: cursor %d,%d status %q", e.cy, e.cx, e.statusMsg)
+	}
+	typeKeys(e, "<C-o>")
+	if e.cy != 0 || e.cx != 0 {
+		t.Fatalf("a search should set a jump: cursor %d,%d", e.cy, e.cx)
+	}
+
+	typeKeys(e, "G?ba<CR>")
+	if e.cy != 1 || e.cx != 0 || e.statusMsg != "?ba [2/2]" {
+		t.Fatalf("?ba: cursor %d,%d status %q", e.cy, e.cx, e.statusMsg)
+	}
+	typeKeys(e, "n")
+	if e.cy != 0 || e.cx != 4 {
+		t.Fatalf("n after ? should search backward: cursor %d,%d", e.cy, e.cx)
+	}
+	typeKeys(e, "/<CR>")
+	if e.cy != 1 || e.cx != 0 || e.searchQuery != "ba" {
+		t.Fatalf("/ should repeat the last pattern forward: cursor %d,%d", e.cy, e.cx)
+	}
+
+	e.exec("set nowrapscan")
+	typeKeys(e, "nn")
+	if e.cy != 1 || e.statusMsg != "Search hit BOTTOM without match for: ba" {
+		t.Fatalf("nowrapscan: cursor %d,%d status %q", e.cy, e.cx, e.statusMsg)
+	}
+	typeKeys(e, "/nothing<CR>")
+	if e.statusMsg != "Pattern not found: nothing" || len(e.searchResults) != 0 {
+		t.Fatalf("status %q", e.statusMsg)
+	}
+	typeKeys(e, "/a(<CR>")
+	if e.statusMsg != "Invalid pattern a(: missing closing )" {
+		t.Fatalf("status %q", e.statusMsg)
+	}
+}
+
+func TestSearchPatterns(t *testing.T) {
+	tests := []struct {
+		set, search string
+		cy, cx      int
+	}{
+		{"", "/FOO<CR>", 2, 0},
+		{"ignorecase", "/FOO<CR>", 1, 4},
+		{"ignorecase smartcase", "/FOO<CR>", 2, 0},
+		{"ignorecase smartcase", "/foo<CR>", 1, 4},
+		{"ignorecase smartcase", `/\cFOO<CR>`, 1, 4},
+		{"ignorecase", `/foo\C<CR>`, 1, 4},
+		{"", `/.o<CR>`, 1, 4},
+		{"", `/\V.o<CR>`, 3, 1},
+		{"", `/o\nF<CR>`, 1, 6},
+		{"", "/^F<CR>", 2, 0},
+		{"", "/bar/e<CR>", 1, 2},
+		{"", "/bar/e+1<CR>", 1, 3},
+		{"", "/foo/s-1<CR>", 1, 3},
+		{"", "/foo/b+2<CR>", 1, 6},
+		{"", "/foo/+2<CR>", 3, 0},
+		{"", `/o\nF/e<CR>`, 2, 0},
+		{"", `/a\/b<CR>`, 3, 8},
+	}
+	for _, tt := range tests {
+		e := newTestEditor("start", "bar foo", "FOO", "f.o fxo a/b")
+		if tt.set != "" {
+			e.exec("set " + tt.set)
+		}
+		typeKeys(e, tt.search)
+		if e.cy != tt.cy || e.cx != tt.cx {
+			t.Errorf("%s %s: cursor %d,%d, want %d,%d (status %q)", tt.set, tt.search, e.cy, e.cx, tt.cy, tt.cx, e.statusMsg)
+		}
+	}
+
+	e := newTestEditor("ab ab", "ab")
+	typeKeys(e, "/b/e<CR>")
+	typeKeys(e, "n")
+	if e.cy != 0 || e.cx != 4 || e.statusMsg != "/b/e [2/3]" {
+		t.Fatalf("n should keep the offset: cursor %d,%d status %q", e.cy, e.cx, e.statusMsg)
+	}
+	typeKeys(e, "/a/<CR>")
+	if e.cy != 1 || e.cx != 0 {
+		t.Fatalf("an empty offset should clear it: cursor %d,%d", e.cy, e.cx)
+	}
+	typeKeys(e, "/a/x<CR>")
+	if e.statusMsg != "Invalid search offset: x" {
+		t.Fatalf("status %q", e.statusMsg)
+	}
+
+	e = newTestEditor("Foo", "foo")
+	e.exec("set ignorecase")
+	e.exec("%s/foo/x/I")
+	e.exec("g/FOO/s/^/-/")
+	if want := []string{"-Foo", "x"}; !reflect.DeepEqual(e.buffer.Lines, want) {
+		t.Fatalf(":s and :g should use the case options: got %q", e.buffer.Lines)
+	}
+	e.exec("s/(/x/")
+	if e.statusMsg != "Invalid pattern (: missing closing )" {
+		t.Fatalf("status %q", e.statusMsg)
+	}
+}
+
+func TestSearchWithOperator(t *testing.T) {
+	e := newTestEditor("one two three")
+	typeKeys(e, "/three<CR>0dn")
+	if e.buffer.Lines[0] != "three" {
+		t.Fatalf("dn: got %q", e.buffer.Lines[0])
+	}
+}
+
+func TestIncSearch(t *testing.T) {
+	e := newTestEditor("abc", "foo bar", "bar")
+	typeKeys(e, "/ba")
+	if e.cy != 1 || e.cx != 4 || e.incMatch == nil || e.incMatch.end != (pos{1, 6}) {
+		t.Fatalf("/ba: cursor %d,%d match %v", e.cy, e.cx, e.incMatch)
+	}
+	typeKeys(e, "x")
+	if e.cy != 0 || e.cx != 0 || e.incMatch != nil {
+		t.Fatalf("/bax: cursor %d,%d", e.cy, e.cx)
+	}
+	typeKeys(e, "<BS><Esc>")
+	if e.cy != 0 || e.cx != 0 || e.mode != ModeNormal || e.searchQuery != "" {
+		t.Fatalf("Esc should go back: cursor %d,%d mode %s", e.cy, e.cx, e.mode)
+	}
+	typeKeys(e, "?bar")
+	if e.cy != 2 || e.cx != 0 {
+		t.Fatalf("?bar: cursor %d,%d", e.cy, e.cx)
+	}
+	typeKeys(e, "<CR><C-o>")
+	if e.cy != 0 || e.cx != 0 {
+		t.Fatalf("the jump should be from where the search started: cursor %d,%d", e.cy, e.cx)
+	}
+
+	e.exec("set noincsearch")
+	typeKeys(e, "/bar")
+	if e.cy != 0 || e.incMatch != nil {
+		t.Fatalf("noincsearch: cursor %d,%d", e.cy, e.cx)
+	}
+}
+
+func TestRenderSearchHighlight(t *testing.T) {
+	e := newTestEditor("if x[1] {", "\tbar x")
+	typeKeys(e, "/x<CR>gg")
+	matches := e.highlightMatches(0, 1)
+	if got, want := e.renderLine(0, 80, matches), "[white:black]i[-:-][blue]f[-:-] [black:yellow]x[-:-][1[] {"; got != want {
+		t.Errorf("line 1: got %q, want %q", got, want)
+	}
+	e.colOffset = 2
+	if got, want := e.renderLine(1, 5, matches), "  bar"; got != want {
+		t.Errorf("scrolled line 2: got %q, want %q", got, want)
+	}
+	if got, want := e.renderLine(1, 8, matches), "  bar [black:yellow]x[-:-]"; got != want {
+		t.Errorf("scrolled line 2: got %q, want %q", got, want)
+	}
+
+	e.exec("nohlsearch")
+	if matches := e.highlightMatches(0, 1); len(matches) != 0 {
+		t.Errorf(":nohlsearch should hide the matches, got %v", matches)
+	}
+	typeKeys(e, "n")
+	if matches := e.highlightMatches(0, 1); len(matches) != 2 {
+		t.Errorf("n should show the matches again, got %v", matches)
+	}
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// --- Shell Commands ---
+
+func init() {
+	registerEx(&exCommand{name: "!", rng: rangeLine, bang: true, run: exBang})
+}
+
+// runShell runs a command with the user's shell, feeding it input, and
+// returns its standard output. A command that fails returns an error holding
+// the first line of its standard error.
+func runShell(ctx context.Context, command string, input []string) ([]byte, error) {
+	shell := os.Getenv("SHELL")
+	if shell == "" {
+		shell = "sh"
+	}
+	cmd := exec.CommandContext(ctx, shell, "-c", command)
+	if input != nil {
+		cmd.Stdin = strings.NewReader(strings.Join(input, "\n") + "\n")
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	err := cmd.Run()
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return nil, fmt.Errorf("Command timed out: %s", command)
+	case errors.Is(ctx.Err(), context.Canceled):
+		return nil, fmt.Errorf("Command cancelled: %s", command)
+	case err != nil:
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return stdout.Bytes(), fmt.Errorf("%s: %s", err, strings.SplitN(msg, "\n", 2)[0])
+		}
+		return stdout.Bytes(), fmt.Errorf("%s: %s", command, err)
+	}
+	return stdout.Bytes(), nil
+}
+
+// startShell runs a command in the background and passes its output to
+// done on the UI goroutine. It is stopped after shelltimeout seconds.
+func (e *Editor) startShell(command string, input []string, done func(out []byte, err error)) error {
+	timeout := time.Duration(e.intOpt("shelltimeout")) * time.Second
+	return e.startJob(fmt.Sprintf("Running %s", command), timeout, func(ctx context.Context) func() {
+		out, err := runShell(ctx, command, input)
+		return func() { done(out, err) }
+	})
+}
+
+// startJob runs work in the background, with a timeout unless it is 0, and
+// then the function work returns on the UI goroutine. Only one job, such as
+// a shell command, runs at a time; Ctrl-C cancels it.
+func (e *Editor) startJob(status string, timeout time.Duration, work func(ctx context.Context) func()) error {
+	if e.shellCancel != nil {
+		return fmt.Errorf("A command is already running")
+	}
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), timeout)
+	} else {
+		ctx, cancel = context.WithCancel(context.Background())
+	}
+	e.shellCancel = cancel
+	e.statusMsg = status + " (Ctrl-C to cancel)"
+	go func() {
+		done := work(ctx)
+		cancel()
+		e.queueUpdate(func() {
+			e.shellCancel = nil
+			done()
+		})
+	}()
+	return nil
+}
+
+// outputLines splits command output into lines, without the empty line
+// after a final newline.
+func outputLines(out []byte) []string {
+	s := strings.TrimSuffix(string(out), "\n")
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, "\n")
+}
+
+// shellCommand returns the command of :!, :r ! or :w !. With repeat set,
+// as for :!!, the argument is appended to the previous command.
+func (e *Editor) shellCommand(arg string, repeat bool) (string, error) {
+	if err := e.checkSecure(); err != nil {
+		return "", err
+	}
+	if repeat {
+		if e.lastShellCmd == "" {
+			return "", fmt.Errorf("No previous command")
+		}
+		arg = e.lastShellCmd + " " + arg
+	}
+	arg = strings.TrimSpace(arg)
+	if arg == "" {
+		return "", fmt.Errorf("Argument required")
+	}
+	e.lastShellCmd = arg
+	return arg, nil
+}
+
+// exBang runs a shell command and shows its output, or with a range
+// replaces the lines with their output when piped through the command:
+// :!cmd and :[range]!cmd. :!! repeats the last command.
+func exBang(e *Editor, c *exCall) error {
+	command, err := e.shellCommand(c.arg, c.bang)
+	if err != nil {
+		return err
+	}
+	if c.addrs == 0 {
+		return e.startShell(command, nil, func(out []byte, err error) {
+			e.showOutput(command, out, err)
+		})
+	}
+	return e.filterLines(c.line1, c.line2, command)
+}
+
+// showOutput shows the output of a command in the pane.
+func (e *Editor) showOutput(command string, out []byte, err error) {
+	text := string(out)
+	if strings.TrimSpace(text) == "" {
+		text = "(no output)"
+	}
+	e.showPane("!"+command, text)
+	if err != nil {
+		e.statusMsg = err.Error()
+	} else {
+		e.statusMsg = fmt.Sprintf("%s finished", command)
+	}
+}
+
+// filterLines replaces lines first to last with their output when piped
+// through command. If the command fails, or the lines are deleted while it
+// runs, the buffer is left as it is.
+func (e *Editor) filterLines(first, last int, command string) error {
+	b := e.buffer
+	input := append([]string(nil), b.Lines[first:last+1]...)
+	start, end := b.anchor(first, 0), b.anchor(last, 0)
+	release := func() {
+		b.release(start)
+		b.release(end)
+	}
+	err := e.startShell(command, input, func(out []byte, err error) {
+		defer release()
+		switch {
+		case err != nil:
+			e.statusMsg = err.Error()
+			return
+		case b != e.buffer || start.deleted || end.deleted || !b.linesAre(start.line, end.line, input):
+			e.statusMsg = "Lines changed while filtering; not replaced"
+			return
+		}
+		e.pushUndo()
+		b.ReplaceLines(start.line, end.line+1, outputLines(out)...)
+		e.cy = clamp(start.line, 0, len(b.Lines)-1)
+		e.cx = firstNonBlank(b.Lines[e.cy])
+		e.statusMsg = plural(len(input), "line", "lines") + " filtered"
+	})
+	if err != nil {
+		release()
+	}
+	return err
+}
+
+// linesAre reports whether lines first to last of b are lines.
+func (b *Buffer) linesAre(first, last int, lines []string) bool {
+	if last-first+1 != len(lines) || last >= len(b.Lines) {
+		return false
+	}
+	for i, line := range lines {
+		if b.Lines[first+i] != line {
+			return false
+		}
+	}
+	return true
+}
+
+// readCommand inserts the output of a command below line y: :[line]r !cmd
+func (e *Editor) readCommand(y int, command string) error {
+	b := e.buffer
+	at := b.anchor(clamp(y, 0, len(b.Lines)-1), 0)
+	err := e.startShell(command, nil, func(out []byte, err error) {
+		defer b.release(at)
+		switch {
+		case err != nil:
+			e.statusMsg = err.Error()
+			return
+		case b != e.buffer || at.deleted:
+			e.statusMsg = "Line changed while the command ran; output not inserted"
+			return
+		}
+		lines := outputLines(out)
+		if len(lines) == 0 {
+			e.statusMsg = fmt.Sprintf("%s gave no output", command)
+			return
+		}
+		target := at.line + 1
+		if y < 0 {
+			target = 0
+		}
+		e.pushUndo()
+		b.InsertLines(target, lines...)
+		e.cy = target
+		e.cx = firstNonBlank(b.Lines[e.cy])
+		e.statusMsg = plural(len(lines), "line", "lines") + " read"
+	})
+	if err != nil {
+		b.release(at)
+	}
+	return err
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// runBackground runs an ex command that starts a shell command and waits
+// for its result to be applied.
+func runBackground(t *testing.T, e *Editor, cmd string) {
+	t.Helper()
+	done := make(chan func(), 1)
+	e.update = func(f func()) { done <- f }
+	e.exec(cmd)
+	if e.shellCancel == nil {
+		t.Fatalf("%q did not start a command (status %q)", cmd, e.statusMsg)
+	}
+	(<-done)()
+}
+
+func TestFilterLines(t *testing.T) {
+	e := newTestEditor("head", "c", "a", "b", "tail")
+	runBackground(t, e, "2,4!sort")
+	if want := []string{"head", "a", "b", "c", "tail"}; !reflect.DeepEqual(e.buffer.Lines, want) {
+		t.Fatalf("got %q", e.buffer.Lines)
+	}
+	if e.cy != 1 || e.statusMsg != "3 lines filtered" {
+		t.Fatalf("cy=%d status %q", e.cy, e.statusMsg)
+	}
+	e.undo()
+	if want := []string{"head", "c", "a", "b", "tail"}; !reflect.DeepEqual(e.buffer.Lines, want) {
+		t.Fatalf("filter should undo in one step, got %q", e.buffer.Lines)
+	}
+
+	runBackground(t, e, "%!tr a-z A-Z")
+	if want := []string{"HEAD", "C", "A", "B", "TAIL"}; !reflect.DeepEqual(e.buffer.Lines, want) {
+		t.Fatalf("whole buffer filter gave %q", e.buffer.Lines)
+	}
+}
+
+func TestFailingFilterKeepsBuffer(t *testing.T) {
+	e := newTestEditor("x", "y")
+	runBackground(t, e, "%!echo oops >&2; exit 3")
+	if want := []string{"x", "y"}; !reflect.DeepEqual(e.buffer.Lines, want) || e.buffer.Dirty {
+		t.Fatalf("buffer changed: %q", e.buffer.Lines)
+	}
+	if !strings.Contains(e.statusMsg, "oops") {
+		t.Fatalf("status should carry the error, got %q", e.statusMsg)
+	}
+}
+
+func TestFilterKeepsEditsMadeWhileRunning(t *testing.T) {
+	e := newTestEditor("c", "a", "b")
+	done := make(chan func(), 1)
+	e.update = func(f func()) { done <- f }
+	e.exec("%!sort")
+	typeKeys(e, "jAx<Esc>")
+	(<-done)()
+	if want := []string{"c", "ax", "b"}; !reflect.DeepEqual(e.buffer.Lines, want) {
+		t.Fatalf("the filter output replaced an edit: %q", e.buffer.Lines)
+	}
+	if e.statusMsg != "Lines changed while filtering; not replaced" {
+		t.Fatalf("status %q", e.statusMsg)
+	}
+}
+
+func TestReadAndWriteCommands(t *testing.T) {
+	e := newTestEditor("one", "two")
+	runBackground(t, e, "r !printf 'a\\nb\\n'")
+	if want := []string{"one", "a", "b", "two"}; !reflect.DeepEqual(e.buffer.Lines, want) {
+		t.Fatalf(":r ! gave %q", e.buffer.Lines)
+	}
+	runBackground(t, e, "0r !echo top")
+	if e.buffer.Lines[0] != "top" {
+		t.Fatalf(":0r ! gave %q", e.buffer.Lines)
+	}
+
+	runBackground(t, e, "w !wc -l")
+	if !e.paneVisible || !strings.Contains(e.outputView.GetText(false), "5") {
+		t.Fatalf(":w ! output %q", e.outputView.GetText(false))
+	}
+	typeKeys(e, "<Esc>")
+	if e.paneVisible {
+		t.Fatal("Esc should close the output pane")
+	}
+
+	runBackground(t, e, "!echo hello")
+	if !strings.Contains(e.outputView.GetText(false), "hello") || e.lastShellCmd != "echo hello" {
+		t.Fatalf(":! output %q", e.outputView.GetText(false))
+	}
+	runBackground(t, e, "!! world")
+	if !strings.Contains(e.outputView.GetText(false), "hello world") {
+		t.Fatalf(":!! output %q", e.outputView.GetText(false))
+	}
+}
+
+func TestCancelShellCommand(t *testing.T) {
+	e := newTestEditor("x")
+	done := make(chan func(), 1)
+	e.update = func(f func()) { done <- f }
+	e.exec("1!sleep 10")
+	if err := e.runEx("!echo"); err == nil {
+		t.Fatal("a second command should not start while one is running")
+	}
+	e.shellCancel()
+	(<-done)()
+	if e.buffer.Lines[0] != "x" || !strings.HasPrefix(e.statusMsg, "Command cancelled") {
+		t.Fatalf("after cancel: lines %q status %q", e.buffer.Lines, e.statusMsg)
+	}
+	if len(e.buffer.anchors) != len(e.buffer.marks) {
+		t.Fatal("anchors left behind")
+	}
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// --- Substitute ---
+
+// substitution is a :s command in progress. Matches are found in each line's
+// original text and replaced one at a time, so confirm mode can stop between
+// them.
+type substitution struct {
+	re      *regexp.Regexp
+	rep     string
+	global  bool
+	end     *mark   // Last line of the range
+	line    int     // Line being worked on
+	loaded  bool    // line has been searched
+	orig    string  // Text of the line before substitution
+	span    int     // Buffer lines the line occupies; replacements can add line breaks
+	matches [][]int // Matches in orig not yet replaced or skipped; the first is current
+	head    string  // New text of the line up to the current match
+	done    int     // End of the part of orig already in head
+	changed bool    // Something was replaced on the line
+	saved   bool    // The undo state before the substitution was pushed
+	found   int     // Matches found
+	subs    int     // Substitutions made
+	lines   int     // Lines changed
+	lastY   int     // Last line changed
+}
+
+func init() {
+	registerEx(&exCommand{name: "substitute", abbrev: "s", rng: rangeLine, run: exSubstitute})
+}
+
+// exSubstitute runs :[range]s/pattern/replacement/[gicn] [count]. Without
+// an argument it repeats the last substitution on the range.
+func exSubstitute(e *Editor, c *exCall) error {
+	var flags string
+	if c.arg == "" {
+		if e.lastSubPattern == "" {
+			return fmt.Errorf("No previous substitute pattern")
+		}
+	} else {
+		delim := c.arg[0]
+		if !validDelim(delim) {
+			return fmt.Errorf("Invalid pattern delimiter: %c", delim)
+		}
+		pat, rest, _ := splitPattern(c.arg[1:], delim)
+		rep, rest, _ := splitPattern(rest, delim)
+		if pat == "" {
+			pat = e.searchQuery
+		}
+		if pat == "" {
+			return fmt.Errorf("No previous search pattern")
+		}
+		e.lastSubPattern, e.lastSubReplacement = pat, rep
+		flags = strings.TrimLeft(rest, " ")
+	}
+
+	var global, confirm, countOnly bool
+	var caseFlag byte
+	for len(flags) > 0 && isExNameChar(flags[0]) {
+		switch flags[0] {
+		case 'g':
+			global = true
+		case 'i', 'I':
+			caseFlag = flags[0]
+		case 'c':
+			confirm = true
+		case 'n':
+			countOnly = true
+		default:
+			return fmt.Errorf("Invalid flag: %c", flags[0])
+		}
+		flags = flags[1:]
+	}
+	if err := e.countArg(c, flags); err != nil {
+		return err
+	}
+
+	pat := e.lastSubPattern
+	e.searchQuery = pat
+	switch caseFlag {
+	case 'i':
+		pat = `\c` + pat
+	case 'I':
+		pat = `\C` + pat
+	}
+	re, err := e.compilePattern(pat)
+	if err != nil {
+		return err
+	}
+
+	if confirm && e.inGlobal {
+		return fmt.Errorf("The c flag cannot be used under :global")
+	}
+	if countOnly {
+		return e.countMatches(re, c.line1, c.line2, global)
+	}
+
+	s := &substitution{
+		re:     re,
+		rep:    e.lastSubReplacement,
+		global: global,
+		end:    e.buffer.anchor(c.line2, 0),
+		line:   c.line1,
+	}
+	if !confirm {
+		for s.advance(e) {
+			s.replace(e)
+		}
+		return s.finish(e)
+	}
+	if !s.advance(e) {
+		return s.finish(e)
+	}
+	s.ask(e)
+	return nil
+}
+
+// countMatches reports the number of matches of re in lines first to last,
+// counting only the first on each line unless global is set.
+func (e *Editor) countMatches(re *regexp.Regexp, first, last int, global bool) error {
+	matches, lines := 0, 0
+	for y := first; y <= last; y++ {
+		if n := len(re.FindAllStringIndex(e.buffer.Lines[y], -1)); n > 0 {
+			if !global {
+				n = 1
+			}
+			matches += n
+			lines++
+		}
+	}
+	if matches == 0 {
+		return fmt.Errorf("Pattern not found: %s", e.lastSubPattern)
+	}
+	e.statusMsg = fmt.Sprintf("%s on %s", plural(matches, "match", "matches"), plural(lines, "line", "lines"))
+	return nil
+}
+
+// plural formats a count with the singular or plural form of a noun.
+func plural(n int, one, many string) string {
+	if n == 1 {
+		return "1 " + one
+	}
+	return fmt.Sprintf("%d %s", n, many)
+}
+
+// advance moves to the next match, loading the following lines of the range
+// as needed, and puts the cursor on it. It returns false when there are none left.
+func (s *substitution) advance(e *Editor) bool {
+	for len(s.matches) == 0 {
+		if s.loaded {
+			s.line += s.span
+		}
+		if s.line > s.end.line || s.end.deleted || s.line >= len(e.buffer.Lines) {
+			return false
+		}
+		n := 1
+		if s.global {
+			n = -1
+		}
+		s.orig = e.buffer.Lines[s.line]
+		s.matches = s.re.FindAllStringSubmatchIndex(s.orig, n)
+		s.head, s.done, s.span, s.changed, s.loaded = "", 0, 1, false, true
+	}
+	s.found++
+	text := s.head + s.orig[s.done:s.matches[0][0]]
+	e.cy = s.line + strings.Count(text, "\n")
+	e.cx = len(text) - strings.LastIndex(text, "\n") - 1
+	return true
+}
+
+// replace replaces the current match and writes the line back.
+func (s *substitution) replace(e *Editor) {
+	if !s.saved {
+		e.pushUndo()
+		s.saved = true
+	}
+	m := s.matches[0]
+	s.matches = s.matches[1:]
+	s.head += s.orig[s.done:m[0]] + expandReplacement(s.re, s.rep, s.orig, m)
+	s.done = m[1]
+	s.subs++
+	if !s.changed {
+		s.lines++
+		s.changed = true
+	}
+	s.lastY = s.line
+
+	parts := strings.Split(s.head+s.orig[s.done:], "\n")
+	for i := 0; i < s.span; i++ {
+		e.buffer.SetLine(s.line+i, parts[i])
+	}
+	e.buffer.InsertLines(s.line+s.span, parts[s.span:]...)
+	s.span = len(parts)
+}
+
+// skip leaves the current match unchanged.
+func (s *substitution) skip() {
+	m := s.matches[0]
+	s.matches = s.matches[1:]
+	s.head += s.orig[s.done:m[1]]
+	s.done = m[1]
+}
+
+// confirmLen returns the length in bytes of the match waiting for
+// confirmation, or 0.
+func (e *Editor) confirmLen() int {
+	if e.mode != ModeConfirm || e.sub == nil || len(e.sub.matches) == 0 {
+		return 0
+	}
+	return e.sub.matches[0][1] - e.sub.matches[0][0]
+}
+
+// prompt returns the confirm mode question for the current match.
+func (s *substitution) prompt() string {
+	return fmt.Sprintf("Replace with %s (y/n/a/q/l)?", s.rep)
+}
+
+// finish ends the substitution, leaving the cursor on the last line changed.
+func (s *substitution) finish(e *Editor) error {
+	e.buffer.release(s.end)
+	e.sub = nil
+	e.mode = ModeNormal
+	if s.found == 0 {
+		return fmt.Errorf("Pattern not found: %s", e.lastSubPattern)
+	}
+	if s.subs == 0 {
+		return nil
+	}
+	e.cy = s.lastY
+	e.cx = firstNonBlank(e.buffer.Lines[e.cy])
+	e.statusMsg = fmt.Sprintf("%s on %s", plural(s.subs, "substitution", "substitutions"), plural(s.lines, "line", "lines"))
+	return nil
+}
+
+// ask asks whether to replace the current match.
+func (s *substitution) ask(e *Editor) {
+	e.sub = s
+	e.confirm(s.prompt(), func(r rune) { s.answer(e, r) })
+}
+
+// answer handles the answer for the current match: y replaces it, n skips
+// it, a replaces it and all that follow, l replaces it and stops, and q or
+// Esc stops.
+func (s *substitution) answer(e *Editor, r rune) {
+	more := true
+	switch r {
+	case 'y':
+		s.replace(e)
+		more = s.advance(e)
+	case 'n':
+		s.skip()
+		more = s.advance(e)
+	case 'a':
+		for more {
+			s.replace(e)
+			more = s.advance(e)
+		}
+	case 'l':
+		s.replace(e)
+		more = false
+	case 'q':
+		more = false
+	}
+	if !more {
+		if err := s.finish(e); err != nil {
+			e.statusMsg = err.Error()
+		}
+		return
+	}
+	s.ask(e)
+}
+
+// expandReplacement returns the replacement for match m of src. It expands
+// & and \0 to the whole match, \1 to \9, $1 to $9 and ${name} to groups,
+// \r and \n to a line break and \t to a tab. \u and \l change the case of
+// the next character, and \U and \L that of the text up to \E or \e.
+func expandReplacement(re *regexp.Regexp, rep, src string, m []int) string {
+	var b strings.Builder
+	var once, span byte
+	group := func(n int) string {
+		if n < 0 || 2*n+1 >= len(m) || m[2*n] < 0 {
+			return ""
+		}
+		return src[m[2*n]:m[2*n+1]]
+	}
+	write := func(s string) {
+		for _, r := range s {
+			switch {
+			case once == 'u', once == 0 && span == 'U':
+				r = unicode.ToUpper(r)
+			case once == 'l', once == 0 && span == 'L':
+				r = unicode.ToLower(r)
+			}
+			once = 0
+			b.WriteRune(r)
+		}
+	}
+
+	for i := 0; i < len(rep); i++ {
+		ch := rep[i]
+		switch {
+		case ch == '&':
+			write(group(0))
+		case ch == '\\' && i+1 < len(rep):
+			i++
+			switch next := rep[i]; {
+			case next >= '0' && next <= '9':
+				write(group(int(next - '0')))
+			case next == 'u' || next == 'l':
+				once = next
+			case next == 'U' || next == 'L':
+				span = next
+			case next == 'E' || next == 'e':
+				span = 0
+			case next == 'r' || next == 'n':
+				b.WriteByte('\n')
+			case next == 't':
+				write("\t")
+			default:
+				write(string(next))
+			}
+		case ch == '$' && i+1 < len(rep) && rep[i+1] >= '0' && rep[i+1] <= '9':
+			i++
+			write(group(int(rep[i] - '0')))
+		case ch == '$' && strings.HasPrefix(rep[i+1:], "{") && strings.Contains(rep[i:], "}"):
+			end := i + strings.Index(rep[i:], "}")
+			name := rep[i+2 : end]
+			if n, rest := leadingNumber(name); rest == "" && name != "" {
+				write(group(n))
+			} else {
+				write(group(re.SubexpIndex(name)))
+			}
+			i = end
+		case ch == '$' && strings.HasPrefix(rep[i+1:], "$"):
+			i++
+			write("$")
+		default:
+			_, size := utf8.DecodeRuneInString(rep[i:])
+			write(rep[i : i+size])
+			i += size - 1
+		}
+	}
+	return b.String()
+}
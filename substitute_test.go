@@ -0,0 +1,94 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSubstitute(t *testing.T) {
+	tests := []struct {
+		cmd    string
+		lines  []string
+		want   []string
+		status string
+	}{
+		{"s/o/0/", []string{"foo boo"}, []string{"f0o boo"}, "1 substitution on 1 line"},
+		{"s/o/0/g", []string{"foo boo"}, []string{"f00 b00"}, "4 substitutions on 1 line"},
+		{"%s/(\\w+)=(\\w+)/$2=\\1/", []string{"a=b", "x", "cd=ef"}, []string{"b=a", "x", "ef=cd"}, "2 substitutions on 2 lines"},
+		{"s/\\w+/[&]/g", []string{"ab cd"}, []string{"[ab] [cd]"}, ""},
+		{"s/\\w+/\\u&/g", []string{"ab cd"}, []string{"Ab Cd"}, ""},
+		{"s/b(\\w+)/\\U$1\\E!/", []string{"abcd"}, []string{"aCD!"}, ""},
+		{"s/(?P<w>x+)/<${w}>/", []string{"axxb"}, []string{"a<xx>b"}, ""},
+		{"s/FOO/bar/i", []string{"Foo"}, []string{"bar"}, ""},
+		{"s#/#\\\\#g", []string{"a/b/c"}, []string{"a\\b\\c"}, ""},
+		{"s/, /\\r/g", []string{"a, b, c", "d"}, []string{"a", "b", "c", "d"}, "2 substitutions on 1 line"},
+		{"s/x/y/ 2", []string{"x", "x", "x"}, []string{"y", "y", "x"}, ""},
+		{"%s/x/y/n", []string{"xx", "a", "x"}, []string{"xx", "a", "x"}, "2 matches on 2 lines"},
+		{"%s/x/y/gn", []string{"xx", "a", "x"}, []string{"xx", "a", "x"}, "3 matches on 2 lines"},
+	}
+	for _, tt := range tests {
+		e := newTestEditor(tt.lines...)
+		e.exec(tt.cmd)
+		if !reflect.DeepEqual(e.buffer.Lines, tt.want) {
+			t.Errorf("%q: got %q, want %q", tt.cmd, e.buffer.Lines, tt.want)
+		}
+		if tt.status != "" && e.statusMsg != tt.status {
+			t.Errorf("%q: status %q, want %q", tt.cmd, e.statusMsg, tt.status)
+		}
+	}
+}
+
+func TestSubstituteErrorsAndUndo(t *testing.T) {
+	e := newTestEditor("one", "two", "three")
+	e.exec("%s/z/y/")
+	if e.statusMsg != "Pattern not found: z" || e.buffer.Dirty {
+		t.Fatalf("no match: status %q, dirty %v", e.statusMsg, e.buffer.Dirty)
+	}
+	e.exec("s/(/x/")
+	if e.statusMsg == "" || e.buffer.Dirty {
+		t.Fatalf("bad pattern: status %q", e.statusMsg)
+	}
+
+	e.exec("%s/o/0/g")
+	if want := []string{"0ne", "tw0", "three"}; !reflect.DeepEqual(e.buffer.Lines, want) {
+		t.Fatalf("got %q", e.buffer.Lines)
+	}
+	if e.cy != 1 {
+		t.Errorf("cursor should be on the last changed line, got %d", e.cy)
+	}
+	e.undo()
+	if want := []string{"one", "two", "three"}; !reflect.DeepEqual(e.buffer.Lines, want) {
+		t.Fatalf("one undo should restore everything, got %q", e.buffer.Lines)
+	}
+
+	e.exec("%s")
+	if want := []string{"0ne", "tw0", "three"}; !reflect.DeepEqual(e.buffer.Lines, want) {
+		t.Fatalf(":s should repeat the last substitution, got %q", e.buffer.Lines)
+	}
+}
+
+func TestSubstituteConfirm(t *testing.T) {
+	e := newTestEditor("a a", "a", "a")
+	e.exec("%s/a/b/gc")
+	if e.mode != ModeConfirm || e.cy != 0 || e.cx != 0 {
+		t.Fatalf("should wait on the first match, mode %s at %d,%d", e.mode, e.cy, e.cx)
+	}
+	typeKeys(e, "ny")
+	if e.cy != 1 || e.buffer.Lines[0] != "a b" {
+		t.Fatalf("after ny: cy=%d lines %q", e.cy, e.buffer.Lines)
+	}
+	typeKeys(e, "q")
+	if e.mode != ModeNormal || !reflect.DeepEqual(e.buffer.Lines, []string{"a b", "a", "a"}) {
+		t.Fatalf("after q: mode %s lines %q", e.mode, e.buffer.Lines)
+	}
+
+	e.exec("%s/a/c/c")
+	typeKeys(e, "a")
+	if !reflect.DeepEqual(e.buffer.Lines, []string{"c b", "c", "c"}) || e.statusMsg != "3 substitutions on 3 lines" {
+		t.Fatalf("after a: lines %q status %q", e.buffer.Lines, e.statusMsg)
+	}
+	e.undo()
+	if !reflect.DeepEqual(e.buffer.Lines, []string{"a b", "a", "a"}) {
+		t.Fatalf("confirmed substitution should undo in one step, got %q", e.buffer.Lines)
+	}
+}
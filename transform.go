@@ -0,0 +1,347 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// --- Text Transformations ---
+
+func init() {
+	registerEx(
+		&exCommand{name: "sort", abbrev: "sor", rng: rangeFile, bang: true, run: exSort},
+		&exCommand{name: "uniq", abbrev: "uni", rng: rangeFile, run: exUniq},
+		&exCommand{name: "align", abbrev: "al", rng: rangeLine, run: exAlign},
+		&exCommand{name: "upper", rng: rangeLine, run: caseCommand(strings.ToUpper)},
+		&exCommand{name: "lower", rng: rangeLine, run: caseCommand(strings.ToLower)},
+		&exCommand{name: "title", rng: rangeLine, run: caseCommand(titleCase)},
+		&exCommand{name: "snake", rng: rangeLine, run: caseCommand(snakeCase)},
+		&exCommand{name: "camel", rng: rangeLine, run: caseCommand(camelCase)},
+	)
+}
+
+// transformLines replaces lines first to last with what f makes of them, as
+// one undo step. It reports whether anything changed.
+func (e *Editor) transformLines(first, last int, f func(lines []string) []string) bool {
+	old := e.buffer.Lines[first : last+1]
+	lines := f(append([]string(nil), old...))
+	if len(lines) == len(old) {
+		same := true
+		for i := range lines {
+			same = same && lines[i] == old[i]
+		}
+		if same {
+			return false
+		}
+	}
+	e.pushUndo()
+	e.buffer.ReplaceLines(first, last+1, lines...)
+	e.cy = clamp(first, 0, len(e.buffer.Lines)-1)
+	e.cx = firstNonBlank(e.buffer.Lines[e.cy])
+	return true
+}
+
+// sortKey is a line being sorted and the part of it that is compared.
+type sortKey struct {
+	line  string
+	key   string
+	num   float64
+	found bool // The pattern, and with n a number, was found
+}
+
+var numberRe = regexp.MustCompile(`-?\d+(\.\d+)?`)
+
+// exSort sorts the lines of the range, the whole buffer by default:
+// :[range]sor[!] [n][i][u][r] [/pattern/]. ! reverses the order, n compares
+// the first number in each line, i ignores case and u drops lines that
+// compare equal to the one before. With a pattern, lines are compared on the
+// text after its match, or on the match itself with r; lines without a
+// match keep their order ahead of the others.
+func exSort(e *Editor, c *exCall) error {
+	var numeric, ignoreCase, unique, onMatch bool
+	var re *regexp.Regexp
+	arg := c.arg
+	for arg = strings.TrimSpace(arg); arg != ""; arg = strings.TrimSpace(arg) {
+		switch ch := arg[0]; {
+		case ch == 'n':
+			numeric = true
+		case ch == 'i':
+			ignoreCase = true
+		case ch == 'u':
+			unique = true
+		case ch == 'r':
+			onMatch = true
+		case validDelim(ch):
+			pat, rest, _ := splitPattern(arg[1:], ch)
+			if pat == "" {
+				pat = e.searchQuery
+			}
+			if pat == "" {
+				return fmt.Errorf("No previous search pattern")
+			}
+			var err error
+			if re, err = e.compilePattern(pat); err != nil {
+				return err
+			}
+			arg = rest
+			continue
+		default:
+			return fmt.Errorf("Invalid argument: %s", arg)
+		}
+		arg = arg[1:]
+	}
+
+	keyOf := func(line string) sortKey {
+		k := sortKey{line: line, key: line, found: true}
+		if re != nil {
+			m := re.FindStringIndex(line)
+			if m == nil {
+				return sortKey{line: line}
+			}
+			if onMatch {
+				k.key = line[m[0]:m[1]]
+			} else {
+				k.key = line[m[1]:]
+			}
+		}
+		if ignoreCase {
+			k.key = strings.ToLower(k.key)
+		}
+		if numeric {
+			n := numberRe.FindString(k.key)
+			k.num, _ = strconv.ParseFloat(n, 64)
+			k.found = n != ""
+		}
+		return k
+	}
+	compare := func(a, b sortKey) int {
+		switch {
+		case a.found != b.found:
+			if a.found {
+				return 1
+			}
+			return -1
+		case !a.found:
+			return 0
+		case numeric && a.num < b.num:
+			return -1
+		case numeric && a.num > b.num:
+			return 1
+		case numeric:
+			return 0
+		}
+		return strings.Compare(a.key, b.key)
+	}
+
+	e.transformLines(c.line1, c.line2, func(lines []string) []string {
+		keys := make([]sortKey, len(lines))
+		for i, l := range lines {
+			keys[i] = keyOf(l)
+		}
+		sort.SliceStable(keys, func(i, j int) bool {
+			if c.bang {
+				return compare(keys[i], keys[j]) > 0
+			}
+			return compare(keys[i], keys[j]) < 0
+		})
+		lines = lines[:0]
+		for i, k := range keys {
+			if unique && i > 0 && compare(keys[i-1], k) == 0 {
+				continue
+			}
+			lines = append(lines, k.line)
+		}
+		return lines
+	})
+	return nil
+}
+
+// exUniq removes lines that repeat the line before them, like uniq(1):
+// :[range]uni [i]. With i the lines are compared ignoring case.
+func exUniq(e *Editor, c *exCall) error {
+	arg := strings.TrimSpace(c.arg)
+	if arg != "" && arg != "i" {
+		return fmt.Errorf("Invalid argument: %s", arg)
+	}
+	removed := 0
+	e.transformLines(c.line1, c.line2, func(lines []string) []string {
+		kept := lines[:1]
+		for _, l := range lines[1:] {
+			prev := kept[len(kept)-1]
+			if l == prev || arg == "i" && strings.EqualFold(l, prev) {
+				removed++
+				continue
+			}
+			kept = append(kept, l)
+		}
+		return kept
+	})
+	if removed > 0 {
+		e.statusMsg = plural(removed, "duplicate line", "duplicate lines") + " removed"
+	}
+	return nil
+}
+
+// exAlign lines up the columns of lines split on a separator, with one
+// space on either side of it: :[range]al {sep}. Without a range it aligns
+// the block of lines around the cursor that hold the separator.
+func exAlign(e *Editor, c *exCall) error {
+	sep := strings.TrimSpace(c.arg)
+	if sep == "" {
+		return fmt.Errorf("Argument required")
+	}
+	if c.addrs == 0 {
+		lines := e.buffer.Lines
+		if !strings.Contains(lines[c.line1], sep) {
+			return fmt.Errorf("Pattern not found: %s", sep)
+		}
+		for c.line1 > 0 && strings.Contains(lines[c.line1-1], sep) {
+			c.line1--
+		}
+		for c.line2 < len(lines)-1 && strings.Contains(lines[c.line2+1], sep) {
+			c.line2++
+		}
+	}
+	e.transformLines(c.line1, c.line2, func(lines []string) []string {
+		return alignColumns(lines, sep)
+	})
+	return nil
+}
+
+// alignColumns pads the text between separators so that the separators of
+// each column line up. Lines without the separator are left alone.
+func alignColumns(lines []string, sep string) []string {
+	cells := make([][]string, len(lines))
+	var widths []int
+	for i, l := range lines {
+		parts := strings.Split(l, sep)
+		if len(parts) < 2 {
+			continue
+		}
+		for j := range parts {
+			if j > 0 {
+				parts[j] = strings.TrimLeft(parts[j], " \t")
+			}
+			if j == len(parts)-1 {
+				break
+			}
+			parts[j] = strings.TrimRight(parts[j], " \t")
+			if j == len(widths) {
+				widths = append(widths, 0)
+			}
+			if w := utf8.RuneCountInString(parts[j]); w > widths[j] {
+				widths[j] = w
+			}
+		}
+		cells[i] = parts
+	}
+	for i, parts := range cells {
+		if parts == nil {
+			continue
+		}
+		var b strings.Builder
+		for j, p := range parts[:len(parts)-1] {
+			b.WriteString(p)
+			b.WriteString(strings.Repeat(" ", widths[j]-utf8.RuneCountInString(p)))
+			b.WriteString(" " + sep + " ")
+		}
+		b.WriteString(parts[len(parts)-1])
+		lines[i] = strings.TrimRight(b.String(), " ")
+	}
+	return lines
+}
+
+// caseCommand returns the run function of a command that changes the case
+// of the lines of the range, the cursor line by default.
+func caseCommand(convert func(string) string) func(e *Editor, c *exCall) error {
+	return func(e *Editor, c *exCall) error {
+		if strings.TrimSpace(c.arg) != "" {
+			return fmt.Errorf("Trailing characters: %s", c.arg)
+		}
+		changed := 0
+		e.transformLines(c.line1, c.line2, func(lines []string) []string {
+			for i, l := range lines {
+				if lines[i] = convert(l); lines[i] != l {
+					changed++
+				}
+			}
+			return lines
+		})
+		if changed > 2 {
+			e.statusMsg = fmt.Sprintf("%d lines changed", changed)
+		}
+		return nil
+	}
+}
+
+var (
+	wordRe  = regexp.MustCompile(`[\p{L}\p{N}']+`)
+	identRe = regexp.MustCompile(`[\p{L}\p{N}][\p{L}\p{N}_-]*[\p{L}\p{N}]|[\p{L}\p{N}]`)
+)
+
+// titleCase capitalises the first letter of every word and lowers the rest.
+func titleCase(s string) string {
+	return wordRe.ReplaceAllStringFunc(s, func(w string) string {
+		r, n := utf8.DecodeRuneInString(w)
+		return string(unicode.ToUpper(r)) + strings.ToLower(w[n:])
+	})
+}
+
+// snakeCase rewrites every identifier as lower-case words joined by
+// underscores: fooBar and foo-bar become foo_bar.
+func snakeCase(s string) string {
+	return identRe.ReplaceAllStringFunc(s, func(id string) string {
+		return strings.ToLower(strings.Join(identWords(id), "_"))
+	})
+}
+
+// camelCase rewrites every identifier as words run together, each after
+// the first capitalised: foo_bar and foo-bar become fooBar.
+func camelCase(s string) string {
+	return identRe.ReplaceAllStringFunc(s, func(id string) string {
+		words := identWords(id)
+		for i, w := range words {
+			if i == 0 {
+				words[i] = strings.ToLower(w)
+			} else {
+				words[i] = titleCase(w)
+			}
+		}
+		return strings.Join(words, "")
+	})
+}
+
+// identWords splits an identifier into its words, at underscores, hyphens
+// and changes of case: HTTPServer_url gives HTTP, Server and url.
+func identWords(id string) []string {
+	var words []string
+	var cur []rune
+	rs := []rune(id)
+	for i, r := range rs {
+		switch {
+		case r == '_' || r == '-':
+			if len(cur) > 0 {
+				words = append(words, string(cur))
+			}
+			cur = nil
+			continue
+		case unicode.IsUpper(r) && len(cur) > 0:
+			prev := cur[len(cur)-1]
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) ||
+				unicode.IsUpper(prev) && i+1 < len(rs) && unicode.IsLower(rs[i+1]) {
+				words = append(words, string(cur))
+				cur = nil
+			}
+		}
+		cur = append(cur, r)
+	}
+	if len(cur) > 0 {
+		words = append(words, string(cur))
+	}
+	return words
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSortAndUniq(t *testing.T) {
+	tests := []struct {
+		cmd   string
+		lines []string
+		want  []string
+	}{
+		{"sort", []string{"b", "C", "a"}, []string{"C", "a", "b"}},
+		{"sort i", []string{"b", "C", "a"}, []string{"a", "b", "C"}},
+		{"sort!", []string{"b", "c", "a"}, []string{"c", "b", "a"}},
+		{"sort n", []string{"x10", "x9", "none", "x-1"}, []string{"none", "x-1", "x9", "x10"}},
+		{"sort! n", []string{"2", "10", "1"}, []string{"10", "2", "1"}},
+		{"sort u", []string{"b", "a", "b", "a"}, []string{"a", "b"}},
+		{"sort iu", []string{"B", "a", "b"}, []string{"a", "B"}},
+		{"sort /\\d+ /", []string{"1 c", "2 a", "plain", "3 b"}, []string{"plain", "2 a", "3 b", "1 c"}},
+		{"sort r /\\d+/", []string{"a3", "b1", "c2"}, []string{"b1", "c2", "a3"}},
+		{"2,3sort", []string{"z", "y", "x", "a"}, []string{"z", "x", "y", "a"}},
+		{"uniq", []string{"a", "a", "b", "a", "A"}, []string{"a", "b", "a", "A"}},
+		{"uniq i", []string{"a", "A", "b"}, []string{"a", "b"}},
+	}
+	for _, tt := range tests {
+		e := newTestEditor(tt.lines...)
+		e.exec(tt.cmd)
+		if !reflect.DeepEqual(e.buffer.Lines, tt.want) {
+			t.Errorf("%q: got %q, want %q (status %q)", tt.cmd, e.buffer.Lines, tt.want, e.statusMsg)
+		}
+	}
+
+	e := newTestEditor("b", "a")
+	e.exec("sort x")
+	if e.statusMsg != "Invalid argument: x" {
+		t.Errorf("bad flag: status %q", e.statusMsg)
+	}
+	e.exec("sort")
+	e.undo()
+	if !reflect.DeepEqual(e.buffer.Lines, []string{"b", "a"}) {
+		t.Errorf("sort should undo in one step, got %q", e.buffer.Lines)
+	}
+}
+
+func TestAlign(t *testing.T) {
+	e := newTestEditor("x", "a = 1", "long_name=2", "b  =  3 = 4", "y")
+	e.cy = 2
+	e.exec("align =")
+	want := []string{"x", "a         = 1", "long_name = 2", "b         = 3 = 4", "y"}
+	if !reflect.DeepEqual(e.buffer.Lines, want) {
+		t.Fatalf("got %q, want %q", e.buffer.Lines, want)
+	}
+	e.cy = 0
+	e.exec("align =")
+	if e.statusMsg != "Pattern not found: =" {
+		t.Errorf("status %q", e.statusMsg)
+	}
+
+	e = newTestEditor("a,bb,c", "ccc,d", "skip")
+	e.exec("%align ,")
+	if want := []string{"a   , bb , c", "ccc , d", "skip"}; !reflect.DeepEqual(e.buffer.Lines, want) {
+		t.Fatalf("got %q, want %q", e.buffer.Lines, want)
+	}
+}
+
+func TestCaseCommands(t *testing.T) {
+	tests := []struct {
+		cmd, line, want string
+	}{
+		{"upper", "Hello world", "HELLO WORLD"},
+		{"lower", "Hello World", "hello world"},
+		{"title", "hello wORLD, it's me", "Hello World, It's Me"},
+		{"snake", "var fooBar = HTTPServer(my-value)", "var foo_bar = http_server(my_value)"},
+		{"camel", "foo_bar := get_ID(x-y) _private", "fooBar := getId(xY) _private"},
+	}
+	for _, tt := range tests {
+		e := newTestEditor(tt.line)
+		e.exec(tt.cmd)
+		if e.buffer.Lines[0] != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.cmd, e.buffer.Lines[0], tt.want)
+		}
+	}
+
+	e := newTestEditor("a", "b", "c", "d")
+	e.exec("2,$upper")
+	if want := []string{"a", "B", "C", "D"}; !reflect.DeepEqual(e.buffer.Lines, want) || e.statusMsg != "3 lines changed" {
+		t.Errorf("range: got %q, status %q", e.buffer.Lines, e.statusMsg)
+	}
+}
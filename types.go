@@ -1,11 +1,13 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/gdamore/tcell/v2"
 	"github.com/rivo/tview"
@@ -15,44 +17,138 @@ import (
 type Mode string
 
 const (
-	ModeNormal  Mode = "normal"
-	ModeInsert  Mode = "insert"
-	ModeCommand Mode = "command"
-	ModeSearch  Mode = "search"
+	ModeNormal     Mode = "normal"
+	ModeInsert     Mode = "insert"
+	ModeReplace    Mode = "replace"
+	ModeCommand    Mode = "command"
+	ModeSearch     Mode = "search"
+	ModeConfirm    Mode = "confirm"    // Answering a question with a single key
+	ModeQuickfix   Mode = "quickfix"   // Moving through the quickfix pane
+	ModeFinder     Mode = "finder"     // Choosing a file in the file finder
+	ModeReplaceAll Mode = "replaceall" // Reviewing the changes of :replaceall
+	ModeUndoTree   Mode = "undotree"   // Browsing the undo tree
 )
 
 // Editor holds the entire state of the application.
 type Editor struct {
-	app          *tview.Application
-	mainView     *tview.TextView
-	statusBar    *tview.TextView
-	commandInput *tview.InputField
-	mainLayout   *tview.Flex
-	chatPanel    *tview.Flex
-	chatView     *tview.TextView
-	chatInput    *tview.InputField
-
-	buffer *Buffer
-	mode   Mode
-	cx, cy int // Cursor position in the buffer
-	rx     int // Rendered cursor x position (for tabs)
+	app           *tview.Application
+	mainView      *tview.TextView
+	statusBar     *tview.TextView
+	commandInput  *tview.InputField
+	mainLayout    *tview.Flex
+	chatPanel     *tview.Flex
+	chatView      *tview.TextView
+	chatInput     *tview.InputField
+	rootLayout    *tview.Flex
+	outputView    *tview.TextView // Pane below the text, e.g. for shell output
+	paneVisible   bool
+	wildView      *tview.TextView // Completion matches shown above the status bar
+	quickfixView  *tview.TextView // The quickfix list, below the text
+	qfVisible     bool
+	replaceView   *tview.TextView // Changes of :replaceall being reviewed, below the text
+	undoView      *tview.TextView // The :undotree pane, below the text
+	pages         *tview.Pages    // The editor, with the file finder shown over it
+	finderView    *tview.Flex
+	finderInput   *tview.InputField
+	finderList    *tview.TextView
+	finderPreview *tview.TextView
+
+	buffer  *Buffer
+	buffers []*Buffer // All open buffers, in the order they were opened
+	mode    Mode
+	cx, cy  int // Cursor position in the buffer
+	rx      int // Rendered cursor x position (for tabs)
 
 	rowOffset int // Top row of the file being displayed
 	colOffset int // Leftmost column of the file being displayed
 
-	undoStack [][]string
-	redoStack [][]string
-	undoMutex sync.Mutex
+	undoMutex      sync.Mutex
+	undoGroup      int  // Depth of nested undo groups
+	undoGroupSaved bool // The undo state for the current group was pushed
+	cmdUndo        bool // The normal-mode command in progress has an undo group
+	mapUndo        bool // The keys of a mapping being run have an undo group
+	undoJoin       bool // :undojoin joins the next change to the last one
+
+	globalMarks map[rune]*Buffer // Buffer holding each file mark A-Z
+	jumps       []jump           // Jump list, oldest first
+	jumpIdx     int              // Position in jumps; len(jumps) when not navigating
+
+	statusMsg         string
+	chatVisible       bool
+	chatHistory       []ChatMessage
+	clipboard         string // For storing copied text
+	clipboardLinewise bool   // The clipboard holds whole lines
+
+	history     map[byte][]string // Entered command lines for each prompt, ':' or '/' (also used by '?'), oldest first
+	historyPath string            // File the history is kept in between sessions; "" to not keep it
+	undoDir     string            // Directory undo files are kept in (see undofile); "" to not keep them
+	browse      *historyBrowse    // Moving through the history with Up and Down
+	wild        *completion       // Completion shown in the wildmenu
+
+	searchQuery    string
+	searchBackward bool         // The last search was made with ?, so n searches backward
+	searchOffset   searchOffset // Offset of the last search, e.g. e+1 in /foo/e+1
+	searchStart    *searchView  // Where the search being typed started
+	incPattern     string       // Pattern being typed at the search prompt
+	incMatch       *searchMatch // Its match the cursor is on
+	hlHidden       bool         // :nohlsearch hid the highlighting of the last search
+	searchResults  [][2]int     // [line, char_pos]
+
+	confirmFn          func(r rune)  // Receives the answer to the question asked in confirm mode
+	sub                *substitution // :s///c waiting for an answer
+	inGlobal           bool          // A :g command is running
+	lastSubPattern     string        // Pattern of the last :s
+	lastSubReplacement string        // Replacement of the last :s
+
+	count       int               // Count typed before the pending normal-mode command
+	pending     string            // Keys of an incomplete normal-mode command, e.g. "g"
+	pendingChar bool              // The pending command is waiting for its character argument
+	operator    string            // Operator waiting for its motion, e.g. "d"
+	opCount     int               // Count typed before the pending operator
+	cmdKeys     []*tcell.EventKey // Keys of the command in progress, for dot-repeat
+	cmdCount    int               // Count of the command in progress
+	cmdTick     int               // Buffer change tick when the command started
+	lastChange  changeRecord      // Last buffer-modifying command, replayed by "."
+	replaying   bool              // True while "." replays lastChange
+
+	insertCount int      // Number of times the current insert session is applied
+	insertFrom  int      // Index in cmdKeys where the insert session's keys start
+	insertAgain func()   // Prepares each repetition of a counted insert, e.g. opens a line for o
+	replaced    []string // Text overwritten by each key in replace mode, restored by backspace
 
-	statusMsg   string
-	chatVisible bool
-	chatHistory []ChatMessage
-	clipboard   string // For storing copied text
+	autoIndented bool // The cursor line's indentation was added automatically
 
-	searchQuery   string
-	searchResults [][2]int // [line, char_pos]
+	wantCol     int       // Screen column j and k try to keep
+	keepWantCol bool      // The last command moved vertically and kept wantCol
+	lastFind    findState // Last f, F, t or T search, for ; and ,
+
+	finder        *finder  // The open file finder
+	fileIndex     []string // Files the finder found last, relative to fileIndexRoot
+	fileIndexRoot string
+
+	quickfix []qfEntry // Matches of the last :grep
+	qfIdx    int       // Current entry of the quickfix list
+	qfTitle  string    // Command that filled the quickfix list
+
+	replaceAll *replaceAll // The :replaceall being reviewed
+	undoTree   *undoTree   // The undo tree being browsed
+
+	update       func(func())       // Replaces queueUpdate in tests
+	shellCancel  context.CancelFunc // Cancels the running shell command or other job
+	lastShellCmd string             // Last :! command, repeated by :!!
+
+	options    map[string]interface{} // Global option values set with :set
+	winOptions map[string]interface{} // Values of window options for the text window
+	ticker     *time.Ticker           // Redraws for background updates
+
+	sourceDepth int  // Config files being run by :source
+	secure      bool // A project config is running; no shell commands or writes
+
+	keymaps     map[byte]map[string]*keymap // Mappings of each mode, 'n', 'i' or 'c', by their keys
+	typeahead   []keyInput                  // Typed keys not yet mapped and run
+	mapDepth    int                         // Mappings expanded for the keys being run
+	keyTimerGen int                         // Identifies the latest wait for the rest of a mapping
 
-	lastKey   string
 	lastEvent *tcell.EventKey // For debugging
 	debugKeys bool
 
@@ -71,26 +167,38 @@ type Buffer struct {
 	FilePath string
 	ReadOnly bool
 	Dirty    bool
+	FileType string // Detected from the file extension, e.g. "go"
+
+	changedTick int                    // Incremented on every modification
+	options     map[string]interface{} // Values of buffer options set for this buffer
+
+	marks   map[rune]*mark // Named and automatic marks
+	anchors []*mark        // Every position kept in step with line edits
+
+	undoRoot  *undoNode   // Oldest state of the undo tree
+	undoCur   *undoNode   // State of the text in the undo tree
+	undoNodes []*undoNode // States of the undo tree, oldest first
+	undoSeq   int         // Number of the newest state
+	undoSaves int         // Number of times the buffer was written
+	undoOpen  bool        // Edits join the change that made undoCur
+	undoing   bool        // Edits are being undone or redone, not recorded
+	undoSize  int         // Bytes taken by the changes in the undo tree
 }
 
 // NewBuffer creates a new buffer, loading from a file if it exists.
 func NewBuffer(filePath string) (*Buffer, error) {
 	b := &Buffer{
 		FilePath: filePath,
+		FileType: detectFileType(filePath),
 	}
 	if _, err := os.Stat(filePath); os.IsNotExist(err) {
 		b.Lines = []string{""} // Start with one empty line for new files
 	} else {
-		content, err := os.ReadFile(filePath)
+		lines, err := readLines(filePath)
 		if err != nil {
 			return nil, err
 		}
-		b.Lines = strings.Split(string(content), "\n")
-		// Reading a file might add a trailing empty line if the file ends with a newline.
-		// Let's remove it for consistency.
-		if len(b.Lines) > 0 && b.Lines[len(b.Lines)-1] == "" {
-			b.Lines = b.Lines[:len(b.Lines)-1]
-		}
+		b.Lines = lines
 		if len(b.Lines) == 0 { // Ensure there's always at least one line
 			b.Lines = []string{""}
 		}
@@ -98,6 +206,45 @@ func NewBuffer(filePath string) (*Buffer, error) {
 	return b, nil
 }
 
+// readLines reads a file as lines. The empty line after a final newline is
+// dropped, so an empty file has no lines.
+func readLines(path string) ([]string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	lines := strings.Split(string(content), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines, nil
+}
+
+// writeLines writes lines to a file, creating any missing parent
+// directories. With appendTo set the lines are added to the end of the file
+// on a new line.
+func writeLines(path string, lines []string, appendTo bool) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	content := strings.Join(lines, "\n")
+	if !appendTo {
+		return os.WriteFile(path, []byte(content), 0644)
+	}
+	if old, err := os.ReadFile(path); err == nil && len(old) > 0 && old[len(old)-1] != '\n' {
+		content = "\n" + content
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(content); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
 // BaseName returns a display-friendly name for the buffer.
 func (b *Buffer) BaseName() string {
 	if b.FilePath == "" {
@@ -106,16 +253,120 @@ func (b *Buffer) BaseName() string {
 	return filepath.Base(b.FilePath)
 }
 
+// markChanged records that the buffer content was modified.
+func (b *Buffer) markChanged() {
+	b.Dirty = true
+	b.changedTick++
+}
+
+// SetLine replaces the text of line y.
+func (b *Buffer) SetLine(y int, s string) {
+	old := b.Lines[y]
+	b.record(lineEdit(y, old, s))
+	b.Lines[y] = s
+	col := 0
+	for col < len(old) && col < len(s) && old[col] == s[col] {
+		col++
+	}
+	b.setMark('.', y, col)
+	b.markChanged()
+}
+
+// InsertLines inserts lines before line index at.
+func (b *Buffer) InsertLines(at int, lines ...string) {
+	if len(lines) == 0 {
+		return
+	}
+	b.record(insertEdit(b.Lines, at, lines))
+	b.Lines = append(b.Lines[:at], append(append([]string(nil), lines...), b.Lines[at:]...)...)
+	b.adjustMarks(at, at, len(lines))
+	b.setMark('.', at, 0)
+	b.markChanged()
+}
+
+// DeleteLines removes lines [start, end). The buffer always keeps at least one line.
+func (b *Buffer) DeleteLines(start, end int) {
+	if start >= end {
+		return
+	}
+	b.record(deleteEdit(b.Lines, start, end))
+	b.Lines = append(b.Lines[:start], b.Lines[end:]...)
+	if len(b.Lines) == 0 {
+		b.Lines = []string{""}
+	}
+	b.adjustMarks(start, end, 0)
+	if start >= len(b.Lines) {
+		start = len(b.Lines) - 1
+	}
+	b.setMark('.', start, 0)
+	b.markChanged()
+}
+
+// ReplaceLines replaces lines [start, end) with lines. Lines that do not
+// change are left alone, so marks on them are kept.
+func (b *Buffer) ReplaceLines(start, end int, lines ...string) {
+	n := end - start
+	for i := 0; i < n && i < len(lines); i++ {
+		if b.Lines[start+i] != lines[i] {
+			b.SetLine(start+i, lines[i])
+		}
+	}
+	if len(lines) > n {
+		b.InsertLines(end, lines[n:]...)
+	} else {
+		b.DeleteLines(start+len(lines), end)
+	}
+}
+
+// MoveLines moves lines [start, end) to before line index to, which must not
+// be inside them. Marks on the moved lines move with them.
+func (b *Buffer) MoveLines(start, end, to int) {
+	if start >= end || to >= start && to <= end {
+		return
+	}
+	n := end - start
+	dest := to
+	if to > end {
+		dest = to - n
+	}
+	moved := append([]string(nil), b.Lines[start:end]...)
+	rest := append(append([]string(nil), b.Lines[:start]...), b.Lines[end:]...)
+	b.record(deleteEdit(b.Lines, start, end))
+	b.record(insertEdit(rest, dest, moved))
+	b.Lines = append(append(rest[:dest:dest], moved...), rest[dest:]...)
+	for _, m := range b.anchors {
+		switch {
+		case m.line >= start && m.line < end:
+			m.line += dest - start
+		case to > end && m.line >= end && m.line < to:
+			m.line -= n
+		case to < start && m.line >= to && m.line < start:
+			m.line += n
+		}
+	}
+	b.setMark('.', dest+n-1, 0)
+	b.markChanged()
+}
+
 // Save writes the buffer's content to its file path.
 func (b *Buffer) Save() error {
 	if b.FilePath == "" {
 		return fmt.Errorf("no file path specified")
 	}
-	content := strings.Join(b.Lines, "\n")
-	err := os.WriteFile(b.FilePath, []byte(content), 0644)
-	if err != nil {
+	if err := writeLines(b.FilePath, b.Lines, false); err != nil {
 		return err
 	}
 	b.Dirty = false
+	// The state written is kept apart from later changes, for :earlier 1f
+	b.initUndo()
+	b.undoSaves++
+	b.undoCur.save = b.undoSaves
+	b.undoOpen = false
 	return nil
 }
+
+// SetPath changes the file the buffer is saved to.
+func (b *Buffer) SetPath(path string) {
+	b.FilePath = path
+	b.FileType = detectFileType(path)
+}
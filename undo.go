@@ -0,0 +1,523 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// --- Undo/Redo ---
+
+// Undo history is kept as the edits made to a buffer rather than copies of
+// it. Every change to the lines goes through the Buffer methods, which
+// record what they replaced; undoing a change applies the inverse of its
+// edits in reverse order.
+//
+// The states of the text form a tree: a change made after undoing starts a
+// new branch instead of throwing away the changes undone. Each state has a
+// number in the order the states were made, which g- and g+ follow.
+
+// editOverhead is roughly the memory an edit takes besides its text.
+const editOverhead = 64
+
+// textEdit is a recorded edit of a buffer: at line and col, the text del
+// was replaced by ins. Either may span lines, which are joined by "\n".
+type textEdit struct {
+	line, col int
+	del, ins  string
+}
+
+// undoNode is a state of the text in the undo tree, and the change that
+// made it from its parent's state: a series of edits undone in one step.
+type undoNode struct {
+	seq      int // Number of the state; the oldest state kept has the lowest
+	parent   *undoNode
+	children []*undoNode // Oldest first
+	redo     *undoNode   // Child redo goes to: the one last made or left
+	edits    []textEdit
+	size     int // Bytes the edits take, roughly
+	time     time.Time
+	save     int        // Number of the write the state was saved by, or 0
+	dropped  bool       // Forgotten to keep within undolevels or undomemory
+	marks    []markSave // Marks on the lines the edits deleted
+}
+
+// markSave is where a mark was before an edit deleted its line, for
+// undoing the edit to put it back.
+type markSave struct {
+	m         *mark
+	edit      int // Index of the edit in its node
+	line, col int
+}
+
+func init() {
+	registerEx(&exCommand{name: "undojoin", abbrev: "undoj", run: func(e *Editor, c *exCall) error {
+		if b := e.buffer; b.undoCur != nil && !b.undoOpen && b.undoCur.redo != nil {
+			return fmt.Errorf("undojoin is not allowed after undo")
+		}
+		e.undoJoin = true
+		return nil
+	}})
+	registerEx(&exCommand{name: "earlier", abbrev: "ea", run: func(e *Editor, c *exCall) error {
+		return e.undoTravel(c.arg, -1)
+	}})
+	registerEx(&exCommand{name: "later", abbrev: "lat", run: func(e *Editor, c *exCall) error {
+		return e.undoTravel(c.arg, 1)
+	}})
+}
+
+// inverse returns the edit that undoes ed.
+func (ed textEdit) inverse() textEdit {
+	return textEdit{line: ed.line, col: ed.col, del: ed.ins, ins: ed.del}
+}
+
+// cloneString returns a copy of s that does not keep the string it was
+// sliced from in memory.
+func cloneString(s string) string {
+	return string(append([]byte(nil), s...))
+}
+
+// lineEdit returns the edit changing line y from old to new: the part
+// between their common prefix and suffix.
+func lineEdit(y int, old, new string) textEdit {
+	p := 0
+	for p < len(old) && p < len(new) && old[p] == new[p] {
+		p++
+	}
+	s := 0
+	for s < len(old)-p && s < len(new)-p && old[len(old)-1-s] == new[len(new)-1-s] {
+		s++
+	}
+	return textEdit{line: y, col: p, del: cloneString(old[p : len(old)-s]), ins: cloneString(new[p : len(new)-s])}
+}
+
+// insertEdit returns the edit inserting ins before line at of lines.
+func insertEdit(lines []string, at int, ins []string) textEdit {
+	text := strings.Join(ins, "\n")
+	if at < len(lines) {
+		return textEdit{line: at, ins: text + "\n"}
+	}
+	return textEdit{line: at - 1, col: len(lines[at-1]), ins: "\n" + text}
+}
+
+// deleteEdit returns the edit deleting lines [start, end) of lines. When
+// all of them go, one empty line is left.
+func deleteEdit(lines []string, start, end int) textEdit {
+	text := strings.Join(lines[start:end], "\n")
+	switch {
+	case end < len(lines):
+		return textEdit{line: start, del: text + "\n"}
+	case start > 0:
+		return textEdit{line: start - 1, col: len(lines[start-1]), del: "\n" + text}
+	}
+	return textEdit{del: text}
+}
+
+// initUndo starts the undo tree of the buffer with its current text, if
+// it has none yet.
+func (b *Buffer) initUndo() {
+	if b.undoCur == nil {
+		b.undoRoot = &undoNode{time: time.Now()}
+		b.undoCur = b.undoRoot
+		b.undoNodes = []*undoNode{b.undoRoot}
+	}
+}
+
+// record adds an edit to the buffer's undo history. It joins the current
+// change unless the change was closed by pushUndo or moved away from, in
+// which case it starts a new state below the current one.
+func (b *Buffer) record(ed textEdit) {
+	if b.undoing {
+		return
+	}
+	b.initUndo()
+	if !b.undoOpen || b.undoCur == b.undoRoot {
+		b.undoSeq++
+		n := &undoNode{seq: b.undoSeq, parent: b.undoCur, time: time.Now()}
+		b.undoCur.children = append(b.undoCur.children, n)
+		b.undoCur.redo = n
+		b.undoCur = n
+		b.undoNodes = append(b.undoNodes, n)
+		b.undoOpen = true
+	}
+	c := b.undoCur
+	c.edits = append(c.edits, ed)
+	n := len(ed.del) + len(ed.ins) + editOverhead
+	c.size += n
+	b.undoSize += n
+}
+
+// saveMark keeps where m was before the edit just recorded deleted its
+// line.
+func (b *Buffer) saveMark(m *mark) {
+	if b.undoing || b.undoCur == nil || len(b.undoCur.edits) == 0 {
+		return
+	}
+	c := b.undoCur
+	c.marks = append(c.marks, markSave{m: m, edit: len(c.edits) - 1, line: m.line, col: m.col})
+}
+
+// restoreMarks puts back the marks edit i of n deleted, once it is undone.
+// A mark set again since is left where it is.
+func (n *undoNode) restoreMarks(i int) {
+	for _, s := range n.marks {
+		if s.edit == i && s.m.deleted {
+			s.m.line, s.m.col, s.m.deleted = s.line, s.col, false
+		}
+	}
+}
+
+// applyEdit makes an edit without recording it.
+func (b *Buffer) applyEdit(ed textEdit) {
+	b.undoing = true
+	defer func() { b.undoing = false }()
+	last := ed.line + strings.Count(ed.del, "\n")
+	end := ed.col + len(ed.del)
+	if i := strings.LastIndexByte(ed.del, '\n'); i >= 0 {
+		end = len(ed.del) - i - 1
+	}
+	text := b.Lines[ed.line][:ed.col] + ed.ins + b.Lines[last][end:]
+	b.ReplaceLines(ed.line, last+1, strings.Split(text, "\n")...)
+}
+
+func (e *Editor) pushUndo() {
+	e.pushUndoFor(e.buffer)
+}
+
+// pushUndoFor starts a new undo step for b, which need not be the current
+// buffer: the edits that follow are undone together. The oldest changes
+// are forgotten once there are more than undolevels of them or they take
+// more than undomemory.
+func (e *Editor) pushUndoFor(b *Buffer) {
+	e.undoMutex.Lock()
+	defer e.undoMutex.Unlock()
+
+	// Inside a group the step started by its first edit goes on
+	if e.undoGroup > 0 {
+		if e.undoGroupSaved {
+			return
+		}
+		e.undoGroupSaved = true
+	}
+	if e.undoJoin {
+		e.undoJoin = false
+		return
+	}
+	b.undoOpen = false
+	e.trimUndo(b, 1)
+}
+
+// trimUndo forgets the oldest changes of b beyond undolevels and
+// undomemory, leaving room for the number of changes starting.
+func (e *Editor) trimUndo(b *Buffer, starting int) {
+	levels, limit := e.intOpt("undolevels")-starting, e.intOpt("undomemory")*1024
+	for b.undoRoot != nil && b.undoRoot != b.undoCur &&
+		(len(b.undoNodes)-1 > levels || limit > 0 && b.undoSize > limit) {
+		b.dropOldestUndo()
+	}
+}
+
+// dropOldestUndo forgets the oldest change leading to the current state,
+// whose state becomes the first in the tree, and the branches that do not
+// lead there.
+func (b *Buffer) dropOldestUndo() {
+	old := b.undoRoot
+	next := old.children[0]
+	if len(old.children) > 1 {
+		next = b.undoCur
+		for next.parent != old {
+			next = next.parent
+		}
+	}
+	old.dropped = true
+	branches := false
+	var drop func(n *undoNode)
+	drop = func(n *undoNode) {
+		n.dropped = true
+		b.undoSize -= n.size
+		for _, c := range n.children {
+			drop(c)
+		}
+	}
+	for _, c := range old.children {
+		if c != next {
+			drop(c)
+			branches = true
+		}
+	}
+	b.undoSize -= next.size
+	next.parent, next.edits, next.size = nil, nil, 0
+	b.undoRoot = next
+
+	if !branches {
+		b.undoNodes[0] = nil
+		b.undoNodes = b.undoNodes[1:]
+		return
+	}
+	kept := b.undoNodes[:0]
+	for _, n := range b.undoNodes {
+		if !n.dropped {
+			kept = append(kept, n)
+		}
+	}
+	b.undoNodes = kept
+}
+
+// beginUndoGroup starts a series of edits, such as the commands run by :g,
+// that undo as one step. Groups nest; only the outermost one counts.
+func (e *Editor) beginUndoGroup() {
+	if e.undoGroup == 0 {
+		e.undoGroupSaved = false
+	}
+	e.undoGroup++
+}
+
+// endUndoGroup ends a series of edits started by beginUndoGroup.
+func (e *Editor) endUndoGroup() {
+	e.undoGroup--
+}
+
+// beginCommandUndo starts the undo group of a normal-mode command, which
+// takes in the insert session the command starts. It ends when the command
+// completes or is abandoned.
+func (e *Editor) beginCommandUndo() {
+	if !e.cmdUndo {
+		e.cmdUndo = true
+		e.beginUndoGroup()
+	}
+}
+
+// endCommandUndo ends the undo group of a normal-mode command.
+func (e *Editor) endCommandUndo() {
+	if e.cmdUndo {
+		e.cmdUndo = false
+		e.endUndoGroup()
+	}
+}
+
+// undo undoes the last change and puts the cursor where it was made.
+func (e *Editor) undo() {
+	b := e.buffer
+	b.initUndo()
+	if b.undoCur == b.undoRoot {
+		e.statusMsg = "Already at oldest change"
+		return
+	}
+	e.undoTo(b.undoCur.parent)
+}
+
+// redo makes the last undone change again and puts the cursor where it
+// was made.
+func (e *Editor) redo() {
+	b := e.buffer
+	b.initUndo()
+	if b.undoCur.redo == nil {
+		e.statusMsg = "Already at newest change"
+		return
+	}
+	e.undoTo(b.undoCur.redo)
+}
+
+// undoChrono moves n states through the undo tree in the order they were
+// made, or back if n is negative, whatever branch they are on (g-, g+).
+func (e *Editor) undoChrono(n int) {
+	b := e.buffer
+	b.initUndo()
+	i := b.undoIndex(b.undoCur)
+	switch {
+	case n < 0 && i == 0:
+		e.statusMsg = "Already at oldest change"
+	case n > 0 && i == len(b.undoNodes)-1:
+		e.statusMsg = "Already at newest change"
+	default:
+		e.undoTo(b.undoNodes[clamp(i+n, 0, len(b.undoNodes)-1)])
+	}
+}
+
+// undoTravel goes back through the undo tree, or forward if dir is 1, by
+// the argument of :earlier or :later: a number of states, a time such as
+// 10s, 5m, 2h or 1d, or a number of writes such as 1f.
+func (e *Editor) undoTravel(arg string, dir int) error {
+	b := e.buffer
+	b.initUndo()
+	arg = strings.TrimSpace(arg)
+	n, unit := 1, arg
+	if arg != "" {
+		n, unit = leadingNumber(arg)
+		if len(unit) == len(arg) || len(unit) > 1 {
+			return fmt.Errorf("Invalid argument: %s", arg)
+		}
+	}
+	units := map[string]time.Duration{"s": time.Second, "m": time.Minute, "h": time.Hour, "d": 24 * time.Hour}
+	var node *undoNode
+	switch {
+	case unit == "":
+		e.undoChrono(dir * n)
+		return nil
+	case unit == "f":
+		node = b.undoAtSave(dir * n)
+	case units[unit] != 0:
+		node = b.undoAtTime(b.undoCur.time.Add(time.Duration(dir*n)*units[unit]), dir)
+	default:
+		return fmt.Errorf("Invalid argument: %s", arg)
+	}
+	if node == b.undoCur {
+		if dir < 0 {
+			e.statusMsg = "Already at oldest change"
+		} else {
+			e.statusMsg = "Already at newest change"
+		}
+		return nil
+	}
+	e.undoTo(node)
+	return nil
+}
+
+// undoAtTime returns the last state made at or before t going back (dir
+// -1), or the first made at or after t going forward, or else the oldest
+// or newest state.
+func (b *Buffer) undoAtTime(t time.Time, dir int) *undoNode {
+	if dir < 0 {
+		node := b.undoRoot
+		for _, n := range b.undoNodes {
+			if !n.time.After(t) {
+				node = n
+			}
+		}
+		return node
+	}
+	for _, n := range b.undoNodes {
+		if !n.time.Before(t) {
+			return n
+		}
+	}
+	return b.undoNodes[len(b.undoNodes)-1]
+}
+
+// undoAtSave returns the state n writes after the current one, or before
+// it if n is negative. With changes since the last write, going back one
+// write goes to the state it saved. Past the first write is the oldest
+// state; past the last, the newest.
+func (b *Buffer) undoAtSave(n int) *undoNode {
+	var saves []*undoNode
+	for _, node := range b.undoNodes {
+		if node.save > 0 {
+			saves = append(saves, node)
+		}
+	}
+	sort.Slice(saves, func(i, j int) bool { return saves[i].save < saves[j].save })
+	cur, at := -1, false
+	for i, node := range saves {
+		if node == b.undoCur {
+			cur, at = i, true
+			break
+		}
+		if node.seq < b.undoCur.seq {
+			cur = i
+		}
+	}
+	i := cur + n
+	if n < 0 && !at {
+		i++
+	}
+	switch {
+	case n < 0 && (cur < 0 || i < 0):
+		return b.undoRoot
+	case n > 0 && i >= len(saves):
+		return b.undoNodes[len(b.undoNodes)-1]
+	}
+	return saves[i]
+}
+
+// undoIndex returns the position of a state in b.undoNodes.
+func (b *Buffer) undoIndex(n *undoNode) int {
+	return sort.Search(len(b.undoNodes), func(i int) bool { return b.undoNodes[i].seq >= n.seq })
+}
+
+// undoTo brings the text to the state of node: it undoes the changes from
+// the current state back to the state both lead from, then makes those
+// down to node. The cursor goes to the last change undone or made.
+func (e *Editor) undoTo(node *undoNode) {
+	e.undoMutex.Lock()
+	defer e.undoMutex.Unlock()
+
+	b := e.buffer
+	b.undoOpen = false
+	if node == b.undoCur {
+		return
+	}
+	onPath := make(map[*undoNode]bool)
+	for n := node; n != nil; n = n.parent {
+		onPath[n] = true
+	}
+	var last textEdit
+	changes := 0
+	for !onPath[b.undoCur] {
+		c := b.undoCur
+		for i := len(c.edits) - 1; i >= 0; i-- {
+			b.applyEdit(c.edits[i].inverse())
+			c.restoreMarks(i)
+		}
+		last = c.edits[0].inverse()
+		c.parent.redo = c
+		b.undoCur = c.parent
+		changes++
+	}
+	var down []*undoNode
+	for n := node; n != b.undoCur; n = n.parent {
+		down = append(down, n)
+	}
+	for i := len(down) - 1; i >= 0; i-- {
+		c := down[i]
+		for _, ed := range c.edits {
+			b.applyEdit(ed)
+		}
+		last = c.edits[0]
+		c.parent.redo = c
+		changes++
+	}
+	b.undoCur = node
+	e.showChange(last)
+
+	before := len(down) == 0
+	at := node
+	if before {
+		at = node.redo
+	}
+	e.statusMsg = fmt.Sprintf("%s; after #%d  %s", plural(changes, "change", "changes"), node.seq, undoTime(node.time))
+	if before && at != nil {
+		e.statusMsg = fmt.Sprintf("%s; before #%d  %s", plural(changes, "change", "changes"), at.seq, undoTime(at.time))
+	}
+}
+
+// undoTime describes when a state was made: how many seconds ago if it was
+// recent, else the time or the date and time.
+func undoTime(t time.Time) string {
+	d := time.Since(t)
+	switch {
+	case d < 100*time.Second:
+		return plural(int(d/time.Second), "second", "seconds") + " ago"
+	case d < 12*time.Hour:
+		return t.Format("15:04:05")
+	}
+	return t.Format("2006/01/02 15:04:05")
+}
+
+// showChange puts the cursor at the start of the text ed inserted, or where
+// it deleted text, and scrolls it into the middle of the view if it is not
+// on screen.
+func (e *Editor) showChange(ed textEdit) {
+	e.cy, e.cx = ed.line, ed.col
+	if strings.HasPrefix(ed.ins, "\n") || strings.HasPrefix(ed.del, "\n") {
+		// Lines added or removed after the end of a line
+		e.cy, e.cx = ed.line+1, 0
+	}
+	e.cy = clamp(e.cy, 0, len(e.buffer.Lines)-1)
+	e.clampCursor()
+	e.updateWantCol()
+	e.recomputeLineStarts()
+	if height := e.viewHeight(); e.cy < e.rowOffset || e.cy >= e.rowOffset+height {
+		e.rowOffset = clamp(e.cy-height/2, 0, len(e.buffer.Lines)-1)
+	}
+}
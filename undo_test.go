@@ -0,0 +1,332 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUndoEdits(t *testing.T) {
+	e := newTestEditor("one", "two", "three")
+	b := e.buffer
+	var states [][]string
+	edit := func(f func()) {
+		states = append(states, append([]string(nil), b.Lines...))
+		e.pushUndo()
+		f()
+	}
+	edit(func() { b.SetLine(1, "tWO!") })
+	edit(func() { b.InsertLines(3, "four", "five") })
+	edit(func() { b.InsertLines(0, "zero") })
+	edit(func() { b.DeleteLines(1, 3) })
+	edit(func() { b.MoveLines(0, 2, 4) })
+	edit(func() { b.ReplaceLines(1, 3, "x", "y", "z", "w") })
+	edit(func() { b.DeleteLines(0, len(b.Lines)) })
+	edit(func() {
+		b.InsertLines(0, "a", "b")
+		b.SetLine(2, "c")
+	})
+	final := append([]string(nil), b.Lines...)
+
+	for i := len(states) - 1; i >= 0; i-- {
+		e.undo()
+		if !reflect.DeepEqual(b.Lines, states[i]) {
+			t.Fatalf("undo %d: got %q, want %q", len(states)-i, b.Lines, states[i])
+		}
+	}
+	e.undo()
+	if !reflect.DeepEqual(b.Lines, states[0]) {
+		t.Fatalf("undo with nothing left should do nothing: %q", b.Lines)
+	}
+	for i := 1; i < len(states); i++ {
+		e.redo()
+		if !reflect.DeepEqual(b.Lines, states[i]) {
+			t.Fatalf("redo %d: got %q, want %q", i, b.Lines, states[i])
+		}
+	}
+	e.redo()
+	if !reflect.DeepEqual(b.Lines, final) {
+		t.Fatalf("last redo: got %q, want %q", b.Lines, final)
+	}
+
+	e.undo()
+	e.pushUndo()
+	b.SetLine(0, "new")
+	e.redo()
+	if b.Lines[0] != "new" || e.statusMsg != "Already at newest change" {
+		t.Fatalf("an edit after undo should start a new branch: %q", b.Lines)
+	}
+}
+
+func TestUndoLimits(t *testing.T) {
+	long := strings.Repeat("x", 10000)
+	e := newTestEditor(long, long, long)
+	typeKeys(e, "ia<Esc>")
+	if e.buffer.undoSize > 1000 {
+		t.Fatalf("typing a character took %d bytes of undo history", e.buffer.undoSize)
+	}
+
+	e.exec("set undolevels=3")
+	typeKeys(e, "xxxxx")
+	if changes := len(e.buffer.undoNodes) - 1; changes != 3 {
+		t.Fatalf("undolevels=3: %d changes kept", changes)
+	}
+
+	e = newTestEditor(long, long, long)
+	e.exec("set undomemory=15")
+	typeKeys(e, "ddddx")
+	if changes := len(e.buffer.undoNodes) - 1; changes != 2 || e.buffer.undoSize > 15*1024 {
+		t.Fatalf("undomemory=15: %d changes in %d bytes kept", changes, e.buffer.undoSize)
+	}
+	e.undo()
+	e.undo()
+	e.undo()
+	if len(e.buffer.Lines) != 2 {
+		t.Fatalf("only the changes kept should be undone: %d lines", len(e.buffer.Lines))
+	}
+}
+
+func TestUndoGroups(t *testing.T) {
+	tests := []struct {
+		keys string
+		ex   string // Run before the keys
+	}{
+		{keys: "ihello<CR>world<Esc>u"},
+		{keys: "A!<BS>?<Esc>u"},
+		{keys: "cwnew<Esc>u"},
+		{keys: "3ia<Esc>u"},
+		{keys: "yy3pu"},
+		{keys: "Qu", ex: "nnoremap Q ddjdd"},
+		{keys: "u", ex: "%norm Ax"},
+		{keys: "u", ex: "%s/o/0/g"},
+	}
+	for _, tt := range tests {
+		lines := []string{"one two", "three", "four"}
+		e := newTestEditor(lines...)
+		if tt.ex != "" {
+			e.exec(tt.ex)
+		}
+		feedKeys(e, tt.keys)
+		if !reflect.DeepEqual(e.buffer.Lines, lines) {
+			t.Errorf("%s %s: got %q", tt.ex, tt.keys, e.buffer.Lines)
+		}
+	}
+
+	e := newTestEditor("abcd")
+	typeKeys(e, "x")
+	e.exec("undojoin")
+	typeKeys(e, "xx")
+	typeKeys(e, "u")
+	if e.buffer.Lines[0] != "cd" {
+		t.Fatalf("the last x should undo alone: %q", e.buffer.Lines[0])
+	}
+	typeKeys(e, "u")
+	if e.buffer.Lines[0] != "abcd" {
+		t.Fatalf(":undojoin should join the next change to the last: %q", e.buffer.Lines[0])
+	}
+	e.exec("undojoin")
+	if e.statusMsg != "undojoin is not allowed after undo" {
+		t.Fatalf("status %q", e.statusMsg)
+	}
+}
+
+func TestUndoCursor(t *testing.T) {
+	lines := make([]string, 100)
+	for i := range lines {
+		lines[i] = "line"
+	}
+	e := newTestEditor(lines...)
+	typeKeys(e, "50GA and more<Esc>gg")
+	typeKeys(e, "u")
+	if e.cy != 49 || e.cx != 3 || e.rowOffset > e.cy || e.cy >= e.rowOffset+e.viewHeight() {
+		t.Fatalf("undo: cursor %d,%d top %d", e.cy, e.cx, e.rowOffset)
+	}
+	typeKeys(e, "gg")
+	feedKeys(e, "<C-y>")
+	if e.cy != 49 || e.cx != 4 {
+		t.Fatalf("redo: cursor %d,%d", e.cy, e.cx)
+	}
+	typeKeys(e, "Gddggu")
+	if e.cy != 99 || e.cx != 0 || e.buffer.Lines[99] != "line" {
+		t.Fatalf("undoing dd on the last line: cursor %d,%d", e.cy, e.cx)
+	}
+	typeKeys(e, "uuu")
+	if e.statusMsg != "Already at oldest change" {
+		t.Fatalf("status %q", e.statusMsg)
+	}
+}
+
+func TestUndoTree(t *testing.T) {
+	e := newTestEditor("a")
+	b := e.buffer
+	text := func() string { return strings.Join(b.Lines, "\n") }
+	// #1 "", #2 "b", then #3 "c" on a branch from #1
+	typeKeys(e, "xib<Esc>uic<Esc>")
+	for _, step := range []struct{ keys, want string }{
+		{"u", ""}, {"<C-y>", "c"}, {"g-", "b"}, {"g-", ""}, {"g-", "a"}, {"g-", "a"},
+		{"g+", ""}, {"2g+", "c"}, {"g+", "c"}, {"3g-", "a"},
+	} {
+		feedKeys(e, step.keys)
+		if text() != step.want {
+			t.Fatalf("%s: got %q, want %q", step.keys, text(), step.want)
+		}
+	}
+	if e.statusMsg != "2 changes; before #1  0 seconds ago" {
+		t.Fatalf("status %q", e.statusMsg)
+	}
+
+	e.exec("undotree")
+	if e.mode != ModeUndoTree || e.undoTree.sel != 0 {
+		t.Fatalf("mode %s", e.mode)
+	}
+	want := []string{"> #0", "  #1", "    #2", "  #3"}
+	for i, line := range strings.Split(strings.TrimSpace(e.undoView.GetText(true)), "\n") {
+		if !strings.HasPrefix(line, want[i]) {
+			t.Fatalf("line %d: %q", i, line)
+		}
+	}
+	typeKeys(e, "jj")
+	if text() != "b" {
+		t.Fatalf("j should show the state selected: %q", text())
+	}
+	typeKeys(e, "<Esc>")
+	if e.mode != ModeNormal || text() != "a" {
+		t.Fatalf("Esc should go back: %q", text())
+	}
+	e.exec("undotree")
+	typeKeys(e, "jjj<CR>")
+	if e.mode != ModeNormal || e.undoTree != nil || text() != "c" {
+		t.Fatalf("Enter should keep the state: %q", text())
+	}
+
+	// Going back in time from #3
+	now := time.Now()
+	for i, n := range b.undoNodes {
+		n.time = now.Add(time.Duration(i-3) * 10 * time.Minute)
+	}
+	for _, step := range []struct{ cmd, want string }{
+		{"earlier 15m", ""}, {"later 10m", "b"}, {"earlier 1h", "a"}, {"later 1d", "c"},
+		{"earlier 2", ""}, {"later", "b"},
+	} {
+		e.exec(step.cmd)
+		if text() != step.want {
+			t.Fatalf("%s: got %q, want %q", step.cmd, text(), step.want)
+		}
+	}
+	e.exec("earlier 3x")
+	if e.statusMsg != "Invalid argument: 3x" {
+		t.Fatalf("status %q", e.statusMsg)
+	}
+}
+
+func TestUndoWrites(t *testing.T) {
+	e := newTestEditor("a")
+	b := e.buffer
+	b.SetPath(filepath.Join(t.TempDir(), "f"))
+	e.exec("w")
+	typeKeys(e, "x")
+	typeKeys(e, "ib<Esc>")
+	e.exec("w")
+	typeKeys(e, "x")
+	typeKeys(e, "ic<Esc>")
+	for _, step := range []struct{ cmd, want string }{
+		{"earlier 1f", "b"}, {"earlier 1f", "a"}, {"earlier 1f", "a"},
+		{"later 1f", "b"}, {"later 1f", "c"}, {"earlier 3f", "a"},
+	} {
+		e.exec(step.cmd)
+		if b.Lines[0] != step.want {
+			t.Fatalf("%s: got %q, want %q", step.cmd, b.Lines[0], step.want)
+		}
+	}
+	e.exec("earlier 1f")
+	if e.statusMsg != "Already at oldest change" {
+		t.Fatalf("status %q", e.statusMsg)
+	}
+}
+
+func TestUndoFile(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "f.txt")
+	writeFile(t, file, "one\ntwo\n")
+	open := func(opts ...string) *Editor {
+		e := newTestEditor("")
+		e.undoDir = filepath.Join(dir, "undo")
+		for _, o := range opts {
+			e.exec("set " + o)
+		}
+		e.exec("e " + file)
+		return e
+	}
+	text := func(e *Editor) string { return strings.Join(e.buffer.Lines, "\n") }
+
+	e := open()
+	typeKeys(e, "x")
+	e.exec("w")
+	if _, err := os.Stat(e.undoDir); !os.IsNotExist(err) {
+		t.Fatal("undo history written without undofile")
+	}
+
+	e = open("undofile")
+	typeKeys(e, "jddux")
+	e.exec("w")
+	typeKeys(e, "Ay<Esc>")
+	e.exec("w")
+
+	e = open("undofile")
+	if text(e) != "ne\nwoy" || len(e.buffer.undoNodes) != 4 {
+		t.Fatalf("reopened: %q with %d states", text(e), len(e.buffer.undoNodes))
+	}
+	for _, step := range []struct{ keys, want string }{
+		{"u", "ne\nwo"}, {"g-", "ne"}, {"u", "ne\ntwo"}, {"<C-y>", "ne"},
+		{"g+", "ne\nwo"}, {"<C-y>", "ne\nwoy"},
+	} {
+		feedKeys(e, step.keys)
+		if text(e) != step.want {
+			t.Fatalf("%s: got %q, want %q", step.keys, text(e), step.want)
+		}
+	}
+	e.exec("earlier 1f")
+	if text(e) != "ne\nwo" {
+		t.Fatalf("earlier 1f: %q", text(e))
+	}
+
+	e = open("undofile", "undolevels=1")
+	if changes := len(e.buffer.undoNodes) - 1; changes != 1 || text(e) != "ne\nwoy" {
+		t.Fatalf("undolevels=1: %d changes restored", changes)
+	}
+
+	writeFile(t, file, "changed\n")
+	e = open("undofile")
+	typeKeys(e, "u")
+	if text(e) != "changed" || e.statusMsg != "Already at oldest change" {
+		t.Fatalf("history of other text restored: %q", text(e))
+	}
+	if entries, _ := os.ReadDir(e.undoDir); len(entries) != 0 {
+		t.Fatalf("stale history left: %d files", len(entries))
+	}
+}
+
+// BenchmarkTyping types and deletes a character in the middle of files of
+// growing size; its time per keystroke should not grow with them.
+func BenchmarkTyping(b *testing.B) {
+	for _, n := range []int{1000, 100000, 1000000} {
+		b.Run(fmt.Sprintf("%dlines", n), func(b *testing.B) {
+			lines := make([]string, n)
+			for i := range lines {
+				lines[i] = "The quick brown fox jumps over the lazy dog"
+			}
+			e := newTestEditor(lines...)
+			e.cy, e.cx = n/2, 10
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				e.insertRune('x')
+				e.backspace()
+			}
+		})
+	}
+}
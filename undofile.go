@@ -0,0 +1,228 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// --- Undo Files ---
+
+// With undofile set, the undo tree of a buffer is written to a file in the
+// state directory whenever the buffer is, and read back when the file is
+// opened again. Each history records a hash of the text it was written
+// with; one that does not match the file, which was changed by something
+// else since, is thrown away.
+
+// undoFileVersion is the version of the undo file format.
+const undoFileVersion = 1
+
+// undoFile is the undo history of a buffer as it is written to disk.
+type undoFile struct {
+	Version int            `json:"version"`
+	Path    string         `json:"path"` // Absolute path of the file the history is of
+	Hash    string         `json:"hash"` // Hash of the text when the history was written
+	Cur     int            `json:"cur"`  // Number of the current state
+	Seq     int            `json:"seq"`
+	Saves   int            `json:"saves"`
+	Nodes   []undoFileNode `json:"nodes"` // Oldest first, the first being the root
+}
+
+// undoFileNode is a state of the undo tree on disk. Its parent and redo
+// child are given by their numbers.
+type undoFileNode struct {
+	Seq    int            `json:"seq"`
+	Parent int            `json:"parent,omitempty"`
+	Redo   int            `json:"redo,omitempty"`
+	Time   time.Time      `json:"time"`
+	Save   int            `json:"save,omitempty"`
+	Edits  []undoFileEdit `json:"edits,omitempty"`
+}
+
+// undoFileEdit is a textEdit on disk.
+type undoFileEdit struct {
+	Line int    `json:"line"`
+	Col  int    `json:"col"`
+	Del  string `json:"del,omitempty"`
+	Ins  string `json:"ins,omitempty"`
+}
+
+func init() {
+	registerOption(&option{name: "undofile", short: "udf", kind: boolOption, scope: bufferScope, def: false,
+		help: "Keep the undo history of files between sessions"})
+}
+
+// undoFilesDir returns the directory undo files are kept in, in the state
+// directory, or "" if there is none.
+func undoFilesDir() string {
+	if dir := stateDir(); dir != "" {
+		return filepath.Join(dir, "undo")
+	}
+	return ""
+}
+
+// undoFilePath returns the file the undo history of b is kept in, named
+// after the hash of its absolute path, and that path. Both are "" if the
+// buffer has no file or histories are not kept.
+func (e *Editor) undoFilePath(b *Buffer) (string, string) {
+	if e.undoDir == "" || b.FilePath == "" {
+		return "", ""
+	}
+	abs, err := filepath.Abs(b.FilePath)
+	if err != nil {
+		return "", ""
+	}
+	sum := sha256.Sum256([]byte(abs))
+	return filepath.Join(e.undoDir, hex.EncodeToString(sum[:])), abs
+}
+
+// textHash returns the hash of the text of lines.
+func textHash(lines []string) string {
+	h := sha256.New()
+	for i, line := range lines {
+		if i > 0 {
+			h.Write([]byte{'\n'})
+		}
+		h.Write([]byte(line))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// saveUndo writes the undo history of the current buffer, just written,
+// to its undo file if undofile is set. Only as much as undolevels and
+// undomemory allow is kept.
+func (e *Editor) saveUndo() error {
+	b := e.buffer
+	path, abs := e.undoFilePath(b)
+	if path == "" || !e.boolOpt("undofile") {
+		return nil
+	}
+	b.initUndo()
+	e.trimUndo(b, 0)
+	f := undoFile{Version: undoFileVersion, Path: abs, Hash: textHash(b.Lines),
+		Cur: b.undoCur.seq, Seq: b.undoSeq, Saves: b.undoSaves}
+	for _, n := range b.undoNodes {
+		fn := undoFileNode{Seq: n.seq, Time: n.time, Save: n.save}
+		if n.parent != nil {
+			fn.Parent = n.parent.seq
+		}
+		if n.redo != nil {
+			fn.Redo = n.redo.seq
+		}
+		for _, ed := range n.edits {
+			fn.Edits = append(fn.Edits, undoFileEdit{ed.line, ed.col, ed.del, ed.ins})
+		}
+		f.Nodes = append(f.Nodes, fn)
+	}
+	data, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(e.undoDir, 0700); err != nil {
+		return err
+	}
+	// Written whole or not at all, so an interrupted write cannot leave a
+	// history that does not match the text
+	tmp, err := os.CreateTemp(e.undoDir, ".undo.*")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// loadUndo gives the current buffer, just opened, the undo history in its
+// undo file if undofile is set. A history written with other text than
+// the buffer's is removed.
+func (e *Editor) loadUndo() {
+	b := e.buffer
+	path, abs := e.undoFilePath(b)
+	if path == "" || !e.boolOpt("undofile") {
+		return
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+	var f undoFile
+	if err := json.Unmarshal(data, &f); err != nil || f.Version != undoFileVersion || f.Path != abs {
+		Log(fmt.Sprintf("Ignoring undo file %s of %s", path, b.FilePath))
+		return
+	}
+	if f.Hash != textHash(b.Lines) {
+		os.Remove(path)
+		return
+	}
+	if err := b.restoreUndo(&f); err != nil {
+		Log(fmt.Sprintf("Ignoring undo file %s of %s: %v", path, b.FilePath, err))
+		return
+	}
+	e.trimUndo(b, 0)
+}
+
+// restoreUndo replaces the undo tree of b with the one in f, checking
+// that it is whole: every parent comes before its children and the
+// current state is in it.
+func (b *Buffer) restoreUndo(f *undoFile) error {
+	if len(f.Nodes) == 0 {
+		return fmt.Errorf("no states")
+	}
+	bySeq := make(map[int]*undoNode, len(f.Nodes))
+	var nodes []*undoNode
+	size := 0
+	for i, fn := range f.Nodes {
+		n := &undoNode{seq: fn.Seq, time: fn.Time, save: fn.Save}
+		if bySeq[fn.Seq] != nil || i > 0 && fn.Seq <= nodes[i-1].seq {
+			return fmt.Errorf("state %d out of order", fn.Seq)
+		}
+		if i > 0 {
+			if n.parent = bySeq[fn.Parent]; n.parent == nil {
+				return fmt.Errorf("state %d has no parent", fn.Seq)
+			}
+			n.parent.children = append(n.parent.children, n)
+			if len(fn.Edits) == 0 {
+				return fmt.Errorf("state %d has no edits", fn.Seq)
+			}
+		}
+		for _, ed := range fn.Edits {
+			n.edits = append(n.edits, textEdit{line: ed.Line, col: ed.Col, del: ed.Del, ins: ed.Ins})
+			n.size += len(ed.Del) + len(ed.Ins) + editOverhead
+		}
+		size += n.size
+		bySeq[fn.Seq] = n
+		nodes = append(nodes, n)
+	}
+	for _, fn := range f.Nodes {
+		if fn.Redo != 0 {
+			n, redo := bySeq[fn.Seq], bySeq[fn.Redo]
+			if redo == nil || redo.parent != n {
+				return fmt.Errorf("state %d redoes to another branch", fn.Seq)
+			}
+			n.redo = redo
+		}
+	}
+	cur := bySeq[f.Cur]
+	if cur == nil {
+		return fmt.Errorf("no current state %d", f.Cur)
+	}
+	b.undoRoot, b.undoCur, b.undoNodes = nodes[0], cur, nodes
+	b.undoSeq, b.undoSaves, b.undoSize = f.Seq, f.Saves, size
+	if last := nodes[len(nodes)-1].seq; b.undoSeq < last {
+		b.undoSeq = last
+	}
+	b.undoOpen = false
+	return nil
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+// --- Undo Tree Pane ---
+
+// undoTree is the undo tree of the current buffer being browsed in the
+// :undotree pane.
+type undoTree struct {
+	nodes  []*undoNode // The states, each branch below the state it leads from
+	depths []int       // How far each state is indented
+	sel    int         // Selected state, whose text is shown
+	orig   *undoNode   // State when the pane was opened, which Esc goes back to
+}
+
+func init() {
+	registerEx(&exCommand{name: "undotree", abbrev: "undot", run: func(e *Editor, c *exCall) error {
+		e.openUndoTree()
+		return nil
+	}})
+}
+
+// openUndoTree lists the states of the current buffer's undo tree in the
+// pane below the text, with the current one selected.
+func (e *Editor) openUndoTree() {
+	b := e.buffer
+	b.initUndo()
+	t := &undoTree{orig: b.undoCur}
+	// The newest child goes on in line with its parent; older ones are
+	// branches, listed first and indented one more
+	var walk func(n *undoNode, depth int)
+	walk = func(n *undoNode, depth int) {
+		for n != nil {
+			if n == b.undoCur {
+				t.sel = len(t.nodes)
+			}
+			t.nodes = append(t.nodes, n)
+			t.depths = append(t.depths, depth)
+			if len(n.children) == 0 {
+				return
+			}
+			for _, c := range n.children[:len(n.children)-1] {
+				walk(c, depth+1)
+			}
+			n = n.children[len(n.children)-1]
+		}
+	}
+	walk(b.undoRoot, 0)
+	e.undoTree = t
+	e.mode = ModeUndoTree
+	e.rebuildLayout()
+	e.renderUndoTree()
+	e.statusMsg = "j/k show a state, Enter keeps it, Esc goes back"
+}
+
+// closeUndoTree closes the :undotree pane.
+func (e *Editor) closeUndoTree() {
+	e.undoTree = nil
+	e.mode = ModeNormal
+	e.rebuildLayout()
+}
+
+// renderUndoTree fills the :undotree pane: each state with its number,
+// when it was made, the write that saved it and what its change did, the
+// current state marked with >.
+func (e *Editor) renderUndoTree() {
+	t := e.undoTree
+	cur := e.buffer.undoCur
+	var b strings.Builder
+	for i, n := range t.nodes {
+		mark := " "
+		if n == cur {
+			mark = ">"
+		}
+		line := fmt.Sprintf("%s %s#%d  %s", mark, strings.Repeat("  ", t.depths[i]), n.seq, undoTime(n.time))
+		if n.save > 0 {
+			line += fmt.Sprintf("  [w%d]", n.save)
+		}
+		line += "  " + n.summary()
+		line = tview.Escape(line)
+		if i == t.sel {
+			line = e.colorTag("menucolor") + line + "[-:-]"
+		}
+		b.WriteString(line + "\n")
+	}
+	e.undoView.SetTitle(fmt.Sprintf(" Undo tree: %s ", plural(len(t.nodes)-1, "change", "changes")))
+	e.undoView.SetText(b.String())
+	_, _, _, height := e.undoView.GetInnerRect()
+	top := t.sel - height/2
+	if top < 0 {
+		top = 0
+	}
+	e.undoView.ScrollTo(top, 0)
+}
+
+// summary describes the change that made a state: the lines it added and
+// removed, or the text it inserted and deleted.
+func (n *undoNode) summary() string {
+	if n.parent == nil {
+		return "original"
+	}
+	added, removed := 0, 0
+	var text []string
+	for _, ed := range n.edits {
+		added += strings.Count(ed.ins, "\n")
+		removed += strings.Count(ed.del, "\n")
+		if len(text) < 2 && ed.ins != "" && !strings.Contains(ed.ins, "\n") {
+			text = append(text, fmt.Sprintf("+%q", ed.ins))
+		}
+		if len(text) < 2 && ed.del != "" && !strings.Contains(ed.del, "\n") {
+			text = append(text, fmt.Sprintf("-%q", ed.del))
+		}
+	}
+	var parts []string
+	if added > 0 {
+		parts = append(parts, "+"+plural(added, "line", "lines"))
+	}
+	if removed > 0 {
+		parts = append(parts, "-"+plural(removed, "line", "lines"))
+	}
+	if len(parts) == 0 {
+		parts = text
+	}
+	return strings.Join(parts, " ")
+}
+
+// undoTreeKey handles a key in the :undotree pane: j and k (or the arrow
+// keys) select a state and bring the text to it, Enter keeps the selected
+// state and Esc or q goes back to the one the pane was opened at.
+func (e *Editor) undoTreeKey(event *tcell.EventKey) {
+	t := e.undoTree
+	key, ch := event.Key(), rune(0)
+	if key == tcell.KeyRune {
+		ch = event.Rune()
+	}
+	switch {
+	case key == tcell.KeyDown || ch == 'j':
+		t.sel = clamp(t.sel+1, 0, len(t.nodes)-1)
+	case key == tcell.KeyUp || ch == 'k':
+		t.sel = clamp(t.sel-1, 0, len(t.nodes)-1)
+	case key == tcell.KeyEnter:
+		e.closeUndoTree()
+		return
+	case key == tcell.KeyEsc || ch == 'q':
+		e.closeUndoTree()
+		e.undoTo(t.orig)
+		return
+	default:
+		return
+	}
+	e.undoTo(t.nodes[t.sel])
+	e.renderUndoTree()
+}